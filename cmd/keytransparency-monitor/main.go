@@ -57,7 +57,8 @@ var (
 	insecure           = flag.Bool("insecure", false, "Skip TLS checks")
 	ktCert             = flag.String("kt-cert", "genfiles/server.crt", "Path to kt-server's public key")
 
-	pollPeriod = flag.Duration("poll-period", time.Second*5, "Maximum time between polling the key-server. Ideally, this is equal to the min-period of paramerter of the keyserver.")
+	pollPeriod  = flag.Duration("poll-period", time.Second*5, "Maximum time between polling the key-server. Ideally, this is equal to the min-period of paramerter of the keyserver.")
+	epochOffset = flag.Int64("epoch-offset", 0, "The epoch-offset of the sequencer.")
 
 	// TODO(ismail): expose prometheus metrics: a variable that tracks valid/invalid MHs
 	// metricsAddr = flag.String("metrics-addr", ":8081", "The ip:port to publish metrics on")
@@ -148,8 +149,10 @@ func main() {
 	if err != nil {
 		glog.Exitf("Failed to initialize monitor: %v", err)
 	}
+	mon.EpochOffset = *epochOffset
 	mutCli := client.New(mcc, *pollPeriod)
-	responses, errs := mutCli.StartPolling(1)
+	// Map revision 0 is the empty map.
+	responses, errs := mutCli.StartPolling(*epochOffset + 1)
 	go func() {
 		for {
 			select {
@@ -18,6 +18,9 @@ import (
 	"database/sql"
 	"flag"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/google/keytransparency/core/mutator/entry"
@@ -25,11 +28,13 @@ import (
 
 	"github.com/google/keytransparency/impl/sql/engine"
 	"github.com/google/keytransparency/impl/sql/mutations"
+	"github.com/google/keytransparency/impl/sql/wal"
 	"github.com/google/keytransparency/impl/transaction"
 
 	"github.com/golang/glog"
 	"github.com/google/trillian"
 	_ "github.com/google/trillian/merkle/objhasher" // Register objhasher
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -40,12 +45,24 @@ var (
 	serverDBPath     = flag.String("db", "db", "Database connection string")
 	minEpochDuration = flag.Duration("min-period", time.Second*60, "Minimum time between epoch creation (create epochs only if there where mutations). Expected to be smaller than max-period.")
 	maxEpochDuration = flag.Duration("max-period", time.Hour*12, "Maximum time between epoch creation (independent from mutations). This value should about half the time guaranteed by the policy.")
+	epochTimeout     = flag.Duration("epoch-timeout", 0, "Maximum time spent creating a single epoch. Defaults to min-period.")
+	minMutations     = flag.Int("min-mutations", 0, "Minimum number of pending mutations required to create an epoch before max-period elapses.")
+	logWait          = flag.Duration("log-integration-timeout", 0, "Maximum time to wait for each map root to be integrated into the log. Zero disables waiting.")
+	drainTimeout     = flag.Duration("drain-timeout", 0, "Maximum time spent creating a final epoch for pending mutations on SIGINT or SIGTERM. Zero disables it.")
+	enableWAL        = flag.Bool("wal", false, "Record epochs in a write-ahead log to recover from crashes between the map and log writes.")
+	warmUpEpoch      = flag.Bool("warm-up-epoch", false, "Create an epoch on startup if the map has no epoch yet.")
+	epochOffset      = flag.Int64("epoch-offset", 0, "Number added to map revisions to number epochs, e.g. to continue the epochs of a previous deployment.")
 
 	// Info to connect to the trillian map and log.
 	mapID  = flag.Int64("map-id", 0, "ID for backend map")
 	mapURL = flag.String("map-url", "", "URL of Trilian Map Server")
 	logID  = flag.Int64("log-id", 0, "Trillian Log ID")
 	logURL = flag.String("log-url", "", "URL of Trillian Log Server for Signed Map Heads")
+
+	// Optional mirror log for Signed Map Heads.
+	mirrorLogID      = flag.Int64("mirror-log-id", 0, "Trillian Log ID of the mirror log")
+	mirrorLogURL     = flag.String("mirror-log-url", "", "URL of Trillian Log Server of the mirror log. Empty disables mirroring.")
+	mirrorBestEffort = flag.Bool("mirror-best-effort", false, "Drop the map roots that could not be added to the mirror log rather than retrying them.")
 )
 
 func openDB() *sql.DB {
@@ -100,8 +117,44 @@ func main() {
 		}
 	}()
 
-	signer := sequencer.New(*mapID, tmap, *logID, tlog, mutator, mutations, factory)
+	opts := sequencer.Options{
+		EpochTimeout:          *epochTimeout,
+		MinMutationsPerEpoch:  *minMutations,
+		LogIntegrationTimeout: *logWait,
+		ShutdownGracePeriod:   *drainTimeout,
+		WarmUpEpoch:           *warmUpEpoch,
+		EpochOffset:           *epochOffset,
+	}
+	if *enableWAL {
+		w, err := wal.New(sqldb, *mapID)
+		if err != nil {
+			glog.Exitf("Failed to create WAL: %v", err)
+		}
+		opts.WAL = w
+	}
+	if *mirrorLogURL != "" {
+		mirrorConn, err := grpc.Dial(*mirrorLogURL, grpc.WithInsecure())
+		if err != nil {
+			glog.Exitf("Failed to connect to %v: %v", *mirrorLogURL, err)
+		}
+		opts.MirrorLog = trillian.NewTrillianLogClient(mirrorConn)
+		opts.MirrorLogID = *mirrorLogID
+		opts.MirrorBestEffort = *mirrorBestEffort
+	}
+	signer := sequencer.New(*mapID, tmap, *logID, tlog, mutator, mutations, factory, prometheus.DefaultRegisterer, opts)
+	metricMux.Handle("/status", sequencer.StatusHandler(signer))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		glog.Infof("Received %v, shutting down", <-sigs)
+		cancel()
+	}()
+
 	glog.Infof("Signer starting")
-	signer.StartSigning(context.Background(), *minEpochDuration, *maxEpochDuration)
+	if err := signer.StartSigning(ctx, *minEpochDuration, *maxEpochDuration); err != nil {
+		glog.Errorf("StartSigning(): %v", err)
+	}
 	glog.Errorf("Signer exiting")
 }
@@ -59,6 +59,7 @@ var (
 	keyFile      = flag.String("tls-key", "genfiles/server.key", "TLS private key file")
 	certFile     = flag.String("tls-cert", "genfiles/server.crt", "TLS cert file")
 	authType     = flag.String("auth-type", "google", "Sets the type of authentication required from clients to update their entries. Accepted values are google (oauth tokens) and insecure-fake (for testing only).")
+	epochOffset  = flag.Int64("epoch-offset", 0, "The epoch-offset of the sequencer.")
 
 	// Info to connect to sparse merkle tree database.
 	mapID  = flag.Int64("map-id", 0, "ID for backend map")
@@ -190,7 +191,9 @@ func main() {
 		grpc.StreamInterceptor(grpc_prometheus.StreamServerInterceptor),
 		grpc.UnaryInterceptor(grpc_prometheus.UnaryServerInterceptor),
 	)
-	msrv := mutation.New(cmutation.New(*logID, *mapID, tlog, tmap, mutations, factory))
+	mcore := cmutation.New(*logID, *mapID, tlog, tmap, mutations, factory)
+	mcore.EpochOffset = *epochOffset
+	msrv := mutation.New(mcore)
 	ktpb.RegisterKeyTransparencyServiceServer(grpcServer, svr)
 	mpb.RegisterMutationServiceServer(grpcServer, msrv)
 	reflection.Register(grpcServer)
@@ -0,0 +1,92 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"time"
+
+	"github.com/google/keytransparency/core/sequencer"
+
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// Sequencer is a fake sequencer.Sequencer that counts epochs and keeps track of
+// registered channels. It does not send anything to the channels.
+type Sequencer struct {
+	// Epochs is the number of epochs created so far.
+	Epochs int
+	// Err, if set, is returned by all methods.
+	Err error
+	// Channels are the currently registered channels.
+	Channels []chan<- *tpb.GetMutationsResponse
+	// Rejections is returned by RecentRejections.
+	Rejections []sequencer.Rejection
+	// IsSaturated is returned by Saturated.
+	IsSaturated bool
+}
+
+var _ sequencer.Sequencer = &Sequencer{}
+
+// Initialize returns s.Err.
+func (s *Sequencer) Initialize(ctx context.Context) error {
+	return s.Err
+}
+
+// LastEpoch returns s.Epochs and the zero time.
+func (s *Sequencer) LastEpoch() (int64, time.Time) {
+	return int64(s.Epochs), time.Time{}
+}
+
+// RecentRejections returns s.Rejections.
+func (s *Sequencer) RecentRejections() []sequencer.Rejection {
+	return s.Rejections
+}
+
+// Saturated returns s.IsSaturated.
+func (s *Sequencer) Saturated() bool {
+	return s.IsSaturated
+}
+
+// CreateEpoch creates an epoch unless s.Err is set.
+func (s *Sequencer) CreateEpoch(ctx context.Context, forceNewEpoch bool) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.Epochs++
+	return nil
+}
+
+// StartSigning returns s.Err once ctx is done.
+func (s *Sequencer) StartSigning(ctx context.Context, minInterval, maxInterval time.Duration) error {
+	<-ctx.Done()
+	return s.Err
+}
+
+// RegisterMutationsChannel adds ch to s.Channels.
+func (s *Sequencer) RegisterMutationsChannel(ch chan<- *tpb.GetMutationsResponse, bufferSize int) {
+	s.Channels = append(s.Channels, ch)
+}
+
+// UnregisterMutationsChannel removes ch from s.Channels.
+func (s *Sequencer) UnregisterMutationsChannel(ch chan<- *tpb.GetMutationsResponse) {
+	for i, c := range s.Channels {
+		if c == ch {
+			s.Channels = append(s.Channels[:i], s.Channels[i+1:]...)
+			return
+		}
+	}
+}
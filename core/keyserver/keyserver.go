@@ -306,7 +306,7 @@ func (s *Server) UpdateEntry(ctx context.Context, in *tpb.UpdateEntryRequest) (*
 		glog.Errorf("entry.FromLeafValue: %v", err)
 		return nil, grpc.Errorf(codes.InvalidArgument, "invalid previous leaf value")
 	}
-	if _, err := s.mutator.Mutate(oldEntry, in.GetEntryUpdate().GetUpdate()); err == mutator.ErrReplay {
+	if _, err := s.mutator.Mutate(ctx, oldEntry, in.GetEntryUpdate().GetUpdate()); err == mutator.ErrReplay {
 		glog.Warningf("Discarding request due to replay")
 		// Return the response. The client should handle the replay case
 		// by comparing the returned response with the request. Check
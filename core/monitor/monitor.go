@@ -41,6 +41,10 @@ type Monitor struct {
 	// TODO(ismail): update last trusted signed log root
 	//trusted     trillian.SignedLogRoot
 	store *storage.Storage
+
+	// EpochOffset is the EpochOffset of the sequencer, which numbers the
+	// epoch of map revision r as r + EpochOffset.
+	EpochOffset int64
 }
 
 // New creates a new instance of the monitor.
@@ -71,6 +75,9 @@ func (m *Monitor) Process(resp *ktpb.GetMutationsResponse) error {
 	var err error
 	seen := time.Now().Unix()
 	errs := m.verifyMutationsResponse(resp)
+	if got, want := resp.GetEpoch(), resp.GetSmr().GetMapRevision()+m.EpochOffset; got != want {
+		errs = append(errs, fmt.Errorf("epoch %v does not match map revision %v", got, resp.GetSmr().GetMapRevision()))
+	}
 	if len(errs) == 0 {
 		glog.Infof("Successfully verified mutations response for epoch: %v", resp.Epoch)
 		smr, err = m.signMapRoot(resp)
@@ -40,6 +40,10 @@ type Server struct {
 	tmap      trillian.TrillianMapClient
 	mutations mutator.Mutation
 	factory   transaction.Factory
+
+	// EpochOffset is the EpochOffset of the sequencer, which numbers the
+	// epoch of map revision r as r + EpochOffset.
+	EpochOffset int64
 }
 
 // New creates a new instance of the monitor server.
@@ -61,23 +65,24 @@ func New(logID int64,
 
 // GetMutations returns a list of mutations paged by epoch number.
 func (s *Server) GetMutations(ctx context.Context, in *tpb.GetMutationsRequest) (*tpb.GetMutationsResponse, error) {
-	if err := validateGetMutationsRequest(in); err != nil {
+	if err := validateGetMutationsRequest(in, s.EpochOffset); err != nil {
 		glog.Errorf("validateGetMutationsRequest(%v): %v", in, err)
 		return nil, grpc.Errorf(codes.InvalidArgument, "Invalid request")
 	}
+	revision := in.Epoch - s.EpochOffset
 	// Get signed map root by revision.
 	resp, err := s.tmap.GetSignedMapRootByRevision(ctx, &trillian.GetSignedMapRootByRevisionRequest{
 		MapId:    s.mapID,
-		Revision: in.Epoch,
+		Revision: revision,
 	})
 	if err != nil {
-		glog.Errorf("GetSignedMapRootByRevision(%v, %v): %v", s.mapID, in.Epoch, err)
+		glog.Errorf("GetSignedMapRootByRevision(%v, %v): %v", s.mapID, revision, err)
 		return nil, grpc.Errorf(codes.Internal, "Get signed map root failed")
 	}
 
 	// Get highest and lowest sequence number.
 	highestSeq := uint64(resp.GetMapRoot().GetMetadata().GetHighestFullyCompletedSeq())
-	lowestSeq, err := s.lowestSequenceNumber(ctx, in.PageToken, in.Epoch-1)
+	lowestSeq, err := s.lowestSequenceNumber(ctx, in.PageToken, revision-1)
 	if err != nil {
 		return nil, err
 	}
@@ -101,12 +106,15 @@ func (s *Server) GetMutations(ctx context.Context, in *tpb.GetMutationsRequest)
 	indexes := make([][]byte, 0, len(mRange))
 	mutations := make([]*tpb.Mutation, 0, len(mRange))
 	for _, m := range mRange {
-		mutations = append(mutations, &tpb.Mutation{Update: m})
+		mutations = append(mutations, &tpb.Mutation{
+			Update:   m,
+			Metadata: m.GetMetadata(),
+		})
 		indexes = append(indexes, m.GetKeyValue().GetKey())
 	}
 	// Get leaf proofs.
 	// TODO: allow leaf proofs to be optional.
-	proofs, err := s.inclusionProofs(ctx, indexes, in.Epoch)
+	proofs, err := s.inclusionProofs(ctx, indexes, revision)
 	if err != nil {
 		return nil, err
 	}
@@ -178,7 +186,7 @@ func (s *Server) logProofs(ctx context.Context, firstTreeSize int64, epoch int64
 	return logRoot, logConsistency, logInclusion, nil
 }
 
-func (s *Server) lowestSequenceNumber(ctx context.Context, token string, epoch int64) (uint64, error) {
+func (s *Server) lowestSequenceNumber(ctx context.Context, token string, revision int64) (uint64, error) {
 	lowestSeq := int64(0)
 	if token != "" {
 		// A simple cast will panic if the underlying string is not a
@@ -188,13 +196,13 @@ func (s *Server) lowestSequenceNumber(ctx context.Context, token string, epoch i
 			glog.Errorf("strconv.ParseInt(%v, 10, 64): %v", token, err)
 			return 0, grpc.Errorf(codes.InvalidArgument, "%v is not a valid sequence number", token)
 		}
-	} else if epoch != 0 {
+	} else if revision != 0 {
 		resp, err := s.tmap.GetSignedMapRootByRevision(ctx, &trillian.GetSignedMapRootByRevisionRequest{
 			MapId:    s.mapID,
-			Revision: epoch,
+			Revision: revision,
 		})
 		if err != nil {
-			glog.Errorf("GetSignedMapRootByRevision(%v, %v): %v", s.mapID, epoch, err)
+			glog.Errorf("GetSignedMapRootByRevision(%v, %v): %v", s.mapID, revision, err)
 			return 0, grpc.Errorf(codes.Internal, "Get previous signed map root failed")
 		}
 		lowestSeq = resp.GetMapRoot().GetMetadata().GetHighestFullyCompletedSeq()
@@ -202,11 +210,11 @@ func (s *Server) lowestSequenceNumber(ctx context.Context, token string, epoch i
 	return uint64(lowestSeq), nil
 }
 
-func (s *Server) inclusionProofs(ctx context.Context, indexes [][]byte, epoch int64) ([]*trillian.MapLeafInclusion, error) {
+func (s *Server) inclusionProofs(ctx context.Context, indexes [][]byte, revision int64) ([]*trillian.MapLeafInclusion, error) {
 	getResp, err := s.tmap.GetLeaves(ctx, &trillian.GetMapLeavesRequest{
 		MapId:    s.mapID,
 		Index:    indexes,
-		Revision: epoch,
+		Revision: revision,
 	})
 	if err != nil {
 		glog.Errorf("GetLeaves(): %v", err)
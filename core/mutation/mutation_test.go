@@ -126,6 +126,40 @@ func TestGetMutations(t *testing.T) {
 	}
 }
 
+func TestGetMutationsEpochOffset(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMap := newFakeTrillianMapClient()
+	prepare(t, fakeMutations, fakeMap)
+	srv := New(logID, mapID, fake.NewFakeTrillianLogClient(), fakeMap, fakeMutations, &fakeFactory{})
+	srv.EpochOffset = 10
+
+	// Epoch 12 is map revision 2.
+	resp, err := srv.GetMutations(ctx, &tpb.GetMutationsRequest{Epoch: 12, PageSize: 4})
+	if err != nil {
+		t.Fatalf("GetMutations(12): %v", err)
+	}
+	if got, want := resp.Epoch, int64(12); got != want {
+		t.Errorf("resp.Epoch=%v, want %v", got, want)
+	}
+	if got, want := resp.GetSmr().GetMapRevision(), int64(2); got != want {
+		t.Errorf("resp.Smr.MapRevision=%v, want %v", got, want)
+	}
+	want := signedKV(t, 7, 10)
+	if got := len(resp.Mutations); got != len(want) {
+		t.Fatalf("len(resp.Mutations)=%v, want %v", got, len(want))
+	}
+	for i, m := range resp.Mutations {
+		if !reflect.DeepEqual(m.Update, want[i]) {
+			t.Errorf("resp.Mutations[%v].Update=%v, want %v", i, m.Update, want[i])
+		}
+	}
+	// Epoch 10 is map revision 0, which holds no mutations.
+	if _, err := srv.GetMutations(ctx, &tpb.GetMutationsRequest{Epoch: 10}); err == nil {
+		t.Errorf("GetMutations(10): nil error, want an error")
+	}
+}
+
 func TestLowestSequenceNumber(t *testing.T) {
 	ctx := context.Background()
 	fakeMutations := &fakeMutation{}
@@ -233,6 +267,10 @@ func (m *fakeMutation) ReadAll(txn transaction.Txn, startSequence uint64) (uint6
 	return 0, nil, nil
 }
 
+func (m *fakeMutation) HighestSequence(txn transaction.Txn) (uint64, error) {
+	return uint64(len(m.mtns)), nil
+}
+
 func (m *fakeMutation) Write(txn transaction.Txn, mutation *tpb.SignedKV) (uint64, error) {
 	m.mtns = append(m.mtns, mutation)
 	return uint64(len(m.mtns)), nil
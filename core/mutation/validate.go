@@ -33,14 +33,14 @@ var (
 	// ErrInvalidPageSize occurs when the page size is > 0.
 	ErrInvalidPageSize = errors.New("Invalid page size")
 	// ErrInvalidStart occurs when the start epoch of ListEntryHistoryRequest
-	// is not valid (not in [1, currentEpoch]).
+	// is not valid (not in [EpochOffset + 1, currentEpoch]).
 	ErrInvalidStart = errors.New("invalid start epoch")
 )
 
-// validateGetMutationsRequest ensures that start epoch starts with 1 and that
-// page size is > 0.
-func validateGetMutationsRequest(in *tpb.GetMutationsRequest) error {
-	if in.Epoch <= 0 {
+// validateGetMutationsRequest ensures that start epoch starts with
+// epochOffset + 1 and that page size is > 0.
+func validateGetMutationsRequest(in *tpb.GetMutationsRequest, epochOffset int64) error {
+	if in.Epoch-epochOffset <= 0 {
 		return ErrInvalidStart
 	}
 	switch {
@@ -24,6 +24,7 @@ import (
 	"github.com/google/keytransparency/core/crypto/signatures"
 	"github.com/google/keytransparency/core/mutator"
 	"github.com/google/trillian/crypto/sigpb"
+	"golang.org/x/net/context"
 
 	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
 )
@@ -39,7 +40,7 @@ func New() *Mutator {
 
 // Mutate verifies that this is a valid mutation for this item and applies
 // mutation to value.
-func (*Mutator) Mutate(oldValue, update proto.Message) ([]byte, error) {
+func (*Mutator) Mutate(ctx context.Context, oldValue, update proto.Message) ([]byte, error) {
 	// Ensure that the mutation size is within bounds.
 	if proto.Size(update) > mutator.MaxMutationSize {
 		glog.Warningf("mutation (%v bytes) is larger than the maximum accepted size (%v bytes).", proto.Size(update), mutator.MaxMutationSize)
@@ -22,6 +22,7 @@ import (
 	"github.com/google/keytransparency/core/mutator"
 
 	"github.com/benlaurie/objecthash/go/objecthash"
+	"golang.org/x/net/context"
 
 	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
 )
@@ -98,7 +99,7 @@ func TestCheckMutation(t *testing.T) {
 			t.Fatalf("prepareMutation(%v, %v, %v)=%v", tc.key, tc.newEntry, tc.previous, err)
 		}
 
-		if _, got := New().Mutate(tc.oldEntry, mutation); got != tc.err {
+		if _, got := New().Mutate(context.Background(), tc.oldEntry, mutation); got != tc.err {
 			t.Errorf("%d Mutate(%v, %v)=%v, want %v", i, tc.oldEntry, mutation, got, tc.err)
 		}
 	}
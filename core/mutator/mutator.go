@@ -21,6 +21,7 @@ import (
 	"errors"
 
 	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
 
 	"github.com/google/keytransparency/core/transaction"
 
@@ -53,8 +54,9 @@ var (
 // Mutator verifies mutations and transforms values in the map.
 type Mutator interface {
 	// Mutate verifies that this is a valid mutation for this item and
-	// applies mutation to value.
-	Mutate(value, mutation proto.Message) ([]byte, error)
+	// applies mutation to value. Mutators that perform I/O must stop when
+	// ctx is done.
+	Mutate(ctx context.Context, value, mutation proto.Message) ([]byte, error)
 }
 
 // Mutation reads and writes mutations to the database.
@@ -64,13 +66,34 @@ type Mutation interface {
 	// range. The range is identified by a starting sequence number and a
 	// count. Note that startSequence is not included in the result.
 	// ReadRange stops when endSequence or count is reached, whichever comes
-	// first. ReadRange also returns the maximum sequence number read.
+	// first. Mutations are returned in ascending sequence order. ReadRange
+	// also returns the maximum sequence number read.
 	ReadRange(txn transaction.Txn, startSequence, endSequence uint64, count int32) (uint64, []*tpb.SignedKV, error)
 	// ReadAll reads all mutations starting from the given sequence number.
-	// Note that startSequence is not included in the result. ReadAll also
-	// returns the maximum sequence number read.
+	// Note that startSequence is not included in the result. Mutations are
+	// returned in ascending sequence order. ReadAll also returns the maximum
+	// sequence number read.
 	ReadAll(txn transaction.Txn, startSequence uint64) (uint64, []*tpb.SignedKV, error)
+	// HighestSequence returns the highest sequence number written so far, or
+	// zero if no mutations have been written.
+	HighestSequence(txn transaction.Txn) (uint64, error)
 	// Write saves the mutation in the database. Write returns the sequence
 	// number that is written.
 	Write(txn transaction.Txn, mutation *tpb.SignedKV) (uint64, error)
 }
+
+// QueuedMutation is a mutation along with its sequence number.
+type QueuedMutation struct {
+	Sequence uint64
+	Mutation *tpb.SignedKV
+}
+
+// SequencedMutation is a Mutation that can return the sequence number of every
+// mutation it reads, e.g. so that the mutations of several shards can be
+// merged in sequence order.
+type SequencedMutation interface {
+	Mutation
+	// ReadQueued reads mutations like ReadRange, along with their sequence
+	// numbers.
+	ReadQueued(txn transaction.Txn, startSequence, endSequence uint64, count int32) ([]*QueuedMutation, error)
+}
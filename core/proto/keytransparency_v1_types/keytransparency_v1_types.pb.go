@@ -332,6 +332,9 @@ type SignedKV struct {
 	// current epochs. The first proves ownership of new epoch key, and the
 	// second proves that the correct owner is making this change.
 	Signatures map[string]*sigpb.DigitallySigned `protobuf:"bytes,2,rep,name=signatures" json:"signatures,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// metadata contains optional information attached by the client, e.g. a
+	// submission timestamp. It is not covered by signatures.
+	Metadata map[string]string `protobuf:"bytes,3,rep,name=metadata" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
 func (m *SignedKV) Reset()                    { *m = SignedKV{} }
@@ -353,6 +356,13 @@ func (m *SignedKV) GetSignatures() map[string]*sigpb.DigitallySigned {
 	return nil
 }
 
+func (m *SignedKV) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
 // Mutation contains the actual mutation and the inclusion proof of the
 // corresponding leaf.
 type Mutation struct {
@@ -361,6 +371,12 @@ type Mutation struct {
 	// proof contains a leaf and an inclusion proof in the map of the previous
 	// epoch. This is used by Storage-less monitors.
 	Proof *trillian1.MapLeafInclusion `protobuf:"bytes,2,opt,name=proof" json:"proof,omitempty"`
+	// metadata contains the metadata of update, for auditing.
+	Metadata map[string]string `protobuf:"bytes,3,rep,name=metadata" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// new_proof contains the leaf and an inclusion proof in the map of the
+	// epoch of the mutation. It is only set if the sequencer is configured to
+	// fetch it.
+	NewProof *trillian1.MapLeafInclusion `protobuf:"bytes,4,opt,name=new_proof,json=newProof" json:"new_proof,omitempty"`
 }
 
 func (m *Mutation) Reset()                    { *m = Mutation{} }
@@ -382,6 +398,20 @@ func (m *Mutation) GetProof() *trillian1.MapLeafInclusion {
 	return nil
 }
 
+func (m *Mutation) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *Mutation) GetNewProof() *trillian1.MapLeafInclusion {
+	if m != nil {
+		return m.NewProof
+	}
+	return nil
+}
+
 // GetEntryRequest for a user object.
 type GetEntryRequest struct {
 	// user_id is the user identifier. Most commonly an email address.
@@ -710,6 +740,24 @@ type GetMutationsResponse struct {
 	// A non-zero value may be used by the client to fetch the next page of
 	// results.
 	NextPageToken string `protobuf:"bytes,7,opt,name=next_page_token,json=nextPageToken" json:"next_page_token,omitempty"`
+	// mutator_version identifies the mutation rules that were applied to
+	// create epoch.
+	MutatorVersion string `protobuf:"bytes,8,opt,name=mutator_version,json=mutatorVersion" json:"mutator_version,omitempty"`
+	// smr_signature is an optional signature of the sequencer over smr,
+	// serialized like the log leaves but before any compression.
+	SmrSignature []byte `protobuf:"bytes,9,opt,name=smr_signature,json=smrSignature,proto3" json:"smr_signature,omitempty"`
+	// content_hash is a hash of the leaves written to the map and the highest
+	// fully completed sequence number of the epoch. Sequencers that apply the
+	// same mutations produce the same content_hash.
+	ContentHash []byte `protobuf:"bytes,10,opt,name=content_hash,json=contentHash,proto3" json:"content_hash,omitempty"`
+	// proofs_incomplete is set when the epoch was committed but its log proofs
+	// could not be fetched. log_root, log_consistency and log_inclusion are
+	// then empty.
+	ProofsIncomplete bool `protobuf:"varint,11,opt,name=proofs_incomplete,json=proofsIncomplete" json:"proofs_incomplete,omitempty"`
+	// leaves_read is the number of map leaves read to apply the mutations.
+	LeavesRead int64 `protobuf:"varint,12,opt,name=leaves_read,json=leavesRead" json:"leaves_read,omitempty"`
+	// leaves_written is the number of map leaves written by the epoch.
+	LeavesWritten int64 `protobuf:"varint,13,opt,name=leaves_written,json=leavesWritten" json:"leaves_written,omitempty"`
 }
 
 func (m *GetMutationsResponse) Reset()                    { *m = GetMutationsResponse{} }
@@ -766,6 +814,48 @@ func (m *GetMutationsResponse) GetNextPageToken() string {
 	return ""
 }
 
+func (m *GetMutationsResponse) GetMutatorVersion() string {
+	if m != nil {
+		return m.MutatorVersion
+	}
+	return ""
+}
+
+func (m *GetMutationsResponse) GetSmrSignature() []byte {
+	if m != nil {
+		return m.SmrSignature
+	}
+	return nil
+}
+
+func (m *GetMutationsResponse) GetContentHash() []byte {
+	if m != nil {
+		return m.ContentHash
+	}
+	return nil
+}
+
+func (m *GetMutationsResponse) GetProofsIncomplete() bool {
+	if m != nil {
+		return m.ProofsIncomplete
+	}
+	return false
+}
+
+func (m *GetMutationsResponse) GetLeavesRead() int64 {
+	if m != nil {
+		return m.LeavesRead
+	}
+	return 0
+}
+
+func (m *GetMutationsResponse) GetLeavesWritten() int64 {
+	if m != nil {
+		return m.LeavesWritten
+	}
+	return 0
+}
+
 // GetDomainInfoRequest contains an empty request to query the GetDomainInfo
 // APIs.
 type GetDomainInfoRequest struct {
@@ -942,82 +1032,93 @@ func init() {
 func init() { proto.RegisterFile("keytransparency_v1_types.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 1225 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x57, 0xdd, 0x6e, 0x1b, 0x37,
-	0x13, 0xcd, 0x4a, 0x5e, 0x59, 0x1a, 0x3b, 0x76, 0xc2, 0x38, 0xce, 0x46, 0x1f, 0x12, 0xf8, 0xdb,
-	0xa0, 0x6d, 0x5a, 0x14, 0x6a, 0xac, 0xc0, 0x69, 0x93, 0x00, 0x6d, 0x9a, 0x1f, 0xc4, 0x86, 0x1d,
-	0xc0, 0xa0, 0x93, 0xb4, 0x77, 0x0b, 0x5a, 0x4b, 0xc9, 0x84, 0x56, 0xcb, 0x2d, 0x49, 0x09, 0x59,
-	0x03, 0x05, 0xf2, 0x00, 0x05, 0x8a, 0xbe, 0x43, 0x5f, 0xa0, 0x37, 0x7d, 0x81, 0xbe, 0x45, 0x1f,
-	0xa4, 0xd7, 0x05, 0x7f, 0x56, 0x5a, 0xd9, 0x92, 0x1d, 0xe7, 0xa2, 0x37, 0x36, 0x39, 0x9c, 0x21,
-	0x67, 0xce, 0x9c, 0xc3, 0xa5, 0xe0, 0x76, 0x9f, 0xe6, 0x4a, 0x90, 0x54, 0x66, 0x44, 0xd0, 0xb4,
-	0x93, 0x47, 0xa3, 0xcd, 0x48, 0xe5, 0x19, 0x95, 0xad, 0x4c, 0x70, 0xc5, 0x51, 0x70, 0x62, 0xbd,
-	0x35, 0xda, 0x6c, 0x99, 0xf5, 0x66, 0xb3, 0x23, 0xf2, 0x4c, 0xf1, 0xaf, 0xfa, 0x34, 0x97, 0xd9,
-	0xa1, 0xfb, 0x67, 0xa3, 0x9a, 0x81, 0x5b, 0x93, 0xac, 0x97, 0x1d, 0xda, 0xbf, 0x6e, 0x65, 0x45,
-	0x09, 0x96, 0x24, 0x8c, 0xa4, 0x6e, 0xbe, 0x5e, 0xcc, 0xa3, 0x01, 0xc9, 0x22, 0x92, 0x31, 0x6b,
-	0x0f, 0x37, 0xa1, 0xf1, 0x8c, 0x0f, 0x06, 0x4c, 0x29, 0x1a, 0xa3, 0x2b, 0x50, 0xed, 0xd3, 0x3c,
-	0xf0, 0x36, 0xbc, 0xbb, 0xcb, 0x58, 0x0f, 0x11, 0x82, 0x85, 0x98, 0x28, 0x12, 0x54, 0x8c, 0xc9,
-	0x8c, 0xc3, 0x5f, 0x3c, 0x58, 0x7a, 0x91, 0x2a, 0x91, 0xbf, 0xc9, 0x62, 0xa2, 0x28, 0x7a, 0x04,
-	0xb5, 0xa1, 0x19, 0x19, 0xaf, 0xa5, 0x76, 0xd8, 0x9a, 0x57, 0x4b, 0xeb, 0x80, 0xf5, 0x52, 0x1a,
-	0xef, 0xbe, 0xc5, 0x2e, 0x02, 0x7d, 0x0f, 0x8d, 0x4e, 0x71, 0x7c, 0x50, 0x35, 0xe1, 0x77, 0xe6,
-	0x87, 0x8f, 0x33, 0xc5, 0x93, 0xa8, 0xf0, 0x37, 0x0f, 0x7c, 0x93, 0x0e, 0xba, 0x0d, 0x60, 0xcd,
-	0x03, 0x9a, 0x2a, 0x57, 0x45, 0xc9, 0x82, 0xf6, 0x60, 0x95, 0x0c, 0xd5, 0x11, 0x17, 0xec, 0x98,
-	0xc6, 0x91, 0x06, 0x32, 0xa8, 0x6c, 0x54, 0xcf, 0x3e, 0x72, 0x7f, 0x78, 0x98, 0xb0, 0xce, 0x2e,
-	0xcd, 0xf1, 0xca, 0x24, 0x76, 0x97, 0xe6, 0x12, 0x35, 0xa1, 0x9e, 0x09, 0x3a, 0x62, 0x7c, 0x28,
-	0x4d, 0xe6, 0xcb, 0x78, 0x3c, 0x0f, 0x7f, 0xf7, 0xa0, 0x31, 0x8e, 0x44, 0x4d, 0x58, 0xa4, 0x71,
-	0x7b, 0x6b, 0x6b, 0xf3, 0xa1, 0x4d, 0x6a, 0xfb, 0x12, 0x2e, 0x0c, 0xe8, 0x31, 0xdc, 0x14, 0x92,
-	0x44, 0x23, 0x2a, 0x58, 0x37, 0x67, 0x69, 0x2f, 0x92, 0x47, 0xa4, 0xbd, 0xf5, 0x20, 0xba, 0x7f,
-	0xef, 0xeb, 0xb6, 0x45, 0x7d, 0xfb, 0x12, 0x5e, 0x17, 0x92, 0xbc, 0x2d, 0x3c, 0x0e, 0x8c, 0x83,
-	0x5e, 0x47, 0x6d, 0x58, 0xa3, 0x9d, 0x78, 0x2a, 0x3c, 0x6b, 0x6f, 0x3d, 0xb0, 0xe9, 0x6c, 0x5f,
-	0xc2, 0xc8, 0xac, 0x8e, 0x23, 0xf7, 0xdb, 0x5b, 0x0f, 0x9e, 0x02, 0xd4, 0xfb, 0x34, 0x37, 0xdc,
-	0x0b, 0xdb, 0x50, 0xdf, 0xa5, 0xf9, 0x5b, 0x92, 0x0c, 0xe9, 0x8c, 0xde, 0xaf, 0x81, 0x3f, 0xd2,
-	0x4b, 0xae, 0xf9, 0x76, 0x12, 0xfe, 0xe3, 0x41, 0xbd, 0x68, 0x23, 0xfa, 0x0e, 0x1a, 0x7a, 0x33,
-	0xeb, 0xe6, 0x9d, 0xd7, 0xfd, 0xe2, 0x2c, 0xac, 0x33, 0xb0, 0xa7, 0x62, 0x00, 0xc9, 0x7a, 0x29,
-	0x51, 0x43, 0x41, 0x8b, 0x6e, 0xb4, 0xcf, 0xe7, 0x8f, 0x19, 0xd8, 0x20, 0xd3, 0x7a, 0x5c, 0xda,
-	0xa5, 0xf9, 0x06, 0x56, 0x4f, 0x2c, 0x97, 0x8b, 0x6b, 0xd8, 0xe2, 0xbe, 0x2c, 0x17, 0xb7, 0xd4,
-	0x5e, 0x6f, 0x59, 0xf1, 0x3c, 0x67, 0x3d, 0xa6, 0x48, 0x92, 0xe4, 0xf6, 0x24, 0x57, 0xf4, 0xa3,
-	0xca, 0x37, 0x5e, 0xf8, 0x0e, 0xea, 0xaf, 0x86, 0x8a, 0x28, 0xc6, 0xd3, 0x12, 0xe5, 0xbd, 0x0b,
-	0x53, 0xfe, 0x1e, 0xf8, 0x99, 0xe0, 0xbc, 0xeb, 0x4e, 0x6e, 0xb6, 0xc6, 0x4a, 0x7d, 0x45, 0xb2,
-	0x3d, 0x4a, 0xba, 0x3b, 0x69, 0x27, 0x19, 0x4a, 0xc6, 0x53, 0x6c, 0x1d, 0x43, 0x06, 0xab, 0x2f,
-	0xa9, 0xb2, 0x85, 0xd2, 0x9f, 0x86, 0x54, 0x2a, 0x74, 0x03, 0x16, 0x87, 0x92, 0x8a, 0x88, 0xc5,
-	0xae, 0xa8, 0x9a, 0x9e, 0xee, 0xc4, 0xe8, 0x3a, 0xd4, 0x48, 0x96, 0x69, 0x7b, 0xc5, 0xd8, 0x7d,
-	0x92, 0x65, 0x3b, 0x31, 0xfa, 0x14, 0x56, 0xbb, 0x4c, 0x48, 0x15, 0x29, 0x41, 0x69, 0x24, 0xd9,
-	0x31, 0x35, 0x24, 0xa9, 0xe2, 0xcb, 0xc6, 0xfc, 0x5a, 0x50, 0x7a, 0xc0, 0x8e, 0x69, 0xf8, 0x77,
-	0x05, 0xae, 0x4c, 0xce, 0x92, 0x19, 0x4f, 0x25, 0x45, 0xff, 0x83, 0xc6, 0x48, 0x74, 0x23, 0x9b,
-	0xb5, 0x25, 0x48, 0x7d, 0x24, 0xba, 0xfb, 0x7a, 0x3e, 0xad, 0xe0, 0xca, 0xc7, 0x28, 0x18, 0x3d,
-	0x04, 0x48, 0x28, 0x29, 0x0e, 0xa8, 0x9e, 0x0b, 0x4b, 0x43, 0x7b, 0xdb, 0xd3, 0x3f, 0x87, 0xaa,
-	0x1c, 0x88, 0x60, 0xc1, 0xc4, 0xdc, 0x98, 0xc4, 0x58, 0xd4, 0x5f, 0x91, 0x0c, 0x73, 0xae, 0xb0,
-	0xf6, 0x41, 0x6d, 0xa8, 0x27, 0xbc, 0x17, 0x09, 0xce, 0x55, 0xe0, 0xcf, 0xf6, 0xdf, 0xe3, 0x3d,
-	0xe3, 0xbf, 0x98, 0xd8, 0x01, 0xfa, 0x0c, 0x56, 0x75, 0x4c, 0x87, 0xa7, 0x92, 0x49, 0xa5, 0x4b,
-	0x09, 0x6a, 0x1b, 0xd5, 0xbb, 0xcb, 0x78, 0x25, 0xe1, 0xbd, 0x67, 0x13, 0x2b, 0xba, 0x03, 0x97,
-	0xb5, 0x23, 0x2b, 0x72, 0x0c, 0x16, 0x8d, 0xdb, 0x72, 0xc2, 0x7b, 0xe3, 0xbc, 0xf5, 0xad, 0x70,
-	0x63, 0x8f, 0x49, 0x8b, 0xee, 0x36, 0x93, 0x8a, 0x7f, 0x40, 0x43, 0xd7, 0xc0, 0x97, 0x8a, 0x08,
-	0x65, 0xb0, 0xad, 0x62, 0x3b, 0xd1, 0x2d, 0xc9, 0x48, 0xaf, 0xd4, 0x49, 0x1f, 0xd7, 0xb5, 0x41,
-	0x37, 0xb1, 0xc4, 0x81, 0x85, 0x73, 0x38, 0xe0, 0xcf, 0xe2, 0xc0, 0xcf, 0x10, 0x9c, 0xce, 0xd2,
-	0x51, 0xe1, 0x29, 0xd4, 0x8c, 0x22, 0x64, 0xe0, 0x19, 0xad, 0x7e, 0x31, 0xbf, 0xd5, 0x27, 0x69,
-	0x84, 0x5d, 0x24, 0xba, 0x05, 0x90, 0xd2, 0x77, 0x2a, 0x2a, 0x97, 0xd5, 0xd0, 0x96, 0x03, 0x6d,
-	0x08, 0xff, 0xf4, 0x00, 0xd9, 0x2f, 0xcb, 0x7f, 0xc1, 0x78, 0xb4, 0x0d, 0xcb, 0x54, 0x9f, 0x13,
-	0x39, 0x41, 0x5b, 0x2a, 0x7d, 0x32, 0xbf, 0xae, 0xd2, 0xa7, 0x0f, 0x2f, 0xd1, 0xc9, 0x24, 0xfc,
-	0x01, 0xae, 0x4d, 0xe5, 0xed, 0x20, 0x7b, 0x52, 0xe8, 0xdd, 0x5e, 0x15, 0x17, 0x41, 0xcc, 0xe9,
-	0xff, 0x57, 0x0f, 0xae, 0xbd, 0xa4, 0xaa, 0xb8, 0x7d, 0x64, 0x01, 0xc9, 0x1a, 0xf8, 0x34, 0xe3,
-	0x9d, 0x23, 0xb3, 0x73, 0x15, 0xdb, 0xc9, 0xac, 0xc2, 0x2b, 0xb3, 0x0a, 0xbf, 0x05, 0x60, 0x28,
-	0xa4, 0x78, 0x9f, 0xa6, 0x06, 0x9b, 0x06, 0x36, 0xa4, 0x7a, 0xad, 0x0d, 0xd3, 0x0c, 0x5b, 0x98,
-	0x66, 0x58, 0xf8, 0x57, 0x05, 0xd6, 0xa6, 0x33, 0x72, 0xc5, 0xce, 0x4e, 0xc9, 0xa9, 0xb4, 0x72,
-	0x41, 0x95, 0x56, 0x3f, 0x5e, 0xa5, 0x0b, 0x1f, 0xa6, 0x52, 0xff, 0xb4, 0x4a, 0xd1, 0x13, 0x68,
-	0x0c, 0x8a, 0xba, 0x8c, 0xda, 0xcf, 0xbc, 0xde, 0x0b, 0x08, 0xf0, 0x24, 0x48, 0x77, 0xc0, 0x10,
-	0xbc, 0x04, 0xef, 0xa2, 0x81, 0xf7, 0xb2, 0x36, 0xef, 0x17, 0x10, 0x87, 0xeb, 0x06, 0xc4, 0xe7,
-	0x7c, 0x40, 0x58, 0xba, 0x93, 0x76, 0xb9, 0xeb, 0x6b, 0xf8, 0xde, 0x83, 0xeb, 0x27, 0x16, 0x1c,
-	0xbc, 0x1b, 0x50, 0x4d, 0x78, 0xcf, 0x31, 0x69, 0x65, 0x02, 0x8c, 0x6e, 0x2a, 0xd6, 0x4b, 0xda,
-	0x63, 0x40, 0x32, 0x07, 0xf5, 0x29, 0x8f, 0x01, 0xc9, 0xd0, 0x1d, 0xa8, 0x8e, 0x44, 0x71, 0xcd,
-	0x5e, 0x6d, 0xb9, 0xf7, 0xe4, 0xe4, 0x9d, 0xa3, 0x57, 0xc3, 0xff, 0xc3, 0xd2, 0x1b, 0x49, 0xc5,
-	0xbe, 0xe0, 0x5d, 0x96, 0xd0, 0xf1, 0x33, 0xd0, 0x2b, 0x3d, 0x03, 0xdf, 0x57, 0xe0, 0xe6, 0x53,
-	0xa2, 0x3a, 0x47, 0x13, 0xd2, 0x33, 0x3a, 0xe6, 0xe6, 0x6b, 0xf0, 0xb5, 0x3e, 0x8b, 0x7b, 0xe2,
-	0xdb, 0xf9, 0x08, 0xce, 0xdd, 0xa3, 0xa5, 0x33, 0x70, 0xdf, 0x77, 0xbb, 0xd9, 0x3c, 0xad, 0x5f,
-	0x87, 0x9a, 0x7e, 0x86, 0xb0, 0xd8, 0xd1, 0xd8, 0xef, 0xd3, 0x7c, 0x27, 0x6e, 0x46, 0x00, 0x93,
-	0x2d, 0x66, 0xbc, 0x01, 0x1e, 0x4f, 0xbf, 0x01, 0xce, 0xd0, 0x7c, 0x09, 0x8b, 0xf2, 0x93, 0xe0,
-	0x0f, 0x0f, 0x9a, 0xb3, 0xd2, 0x77, 0xdd, 0xfa, 0x11, 0x6a, 0x54, 0x08, 0x3e, 0x06, 0xe1, 0xc9,
-	0xc5, 0x40, 0xb0, 0xbb, 0xb4, 0x5e, 0x98, 0x2d, 0x2c, 0x0c, 0x6e, 0xbf, 0xe6, 0x43, 0x58, 0x2a,
-	0x99, 0x67, 0x94, 0x36, 0xf5, 0x76, 0x6b, 0x94, 0x73, 0x46, 0xf6, 0x03, 0xaf, 0x75, 0x59, 0x00,
-	0x1d, 0x12, 0xb8, 0x5a, 0xb2, 0xb9, 0xec, 0xf7, 0xca, 0x3a, 0xb0, 0x8c, 0x6b, 0x9d, 0x79, 0x77,
-	0x9d, 0xba, 0x0d, 0x4a, 0x9a, 0x38, 0xac, 0x99, 0x9f, 0x1b, 0xf7, 0xff, 0x0d, 0x00, 0x00, 0xff,
-	0xff, 0xba, 0xa9, 0xd3, 0xc5, 0x08, 0x0d, 0x00, 0x00,
+	// 1396 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x57, 0xdf, 0x6f, 0x13, 0xc7,
+	0x13, 0xe7, 0xec, 0xd8, 0xb1, 0xc7, 0x4e, 0x02, 0x4b, 0x08, 0x87, 0xbf, 0x82, 0x6f, 0x38, 0x44,
+	0xa1, 0x3f, 0xe4, 0x12, 0xa3, 0x40, 0x01, 0xa9, 0xa5, 0xfc, 0x10, 0x89, 0x92, 0x48, 0xd1, 0x05,
+	0x42, 0xdf, 0x4e, 0x1b, 0xdf, 0xda, 0x59, 0xe5, 0x7c, 0x7b, 0xdd, 0x5d, 0x9b, 0x1e, 0x52, 0x25,
+	0xfa, 0x5e, 0xa9, 0xea, 0xff, 0xd0, 0x97, 0x3e, 0xf6, 0xa5, 0x4f, 0xfd, 0x4b, 0xfa, 0xd7, 0x54,
+	0xfb, 0xe3, 0xce, 0xe7, 0x60, 0x27, 0x84, 0x4a, 0x7d, 0xb1, 0x6f, 0x67, 0x67, 0x66, 0x67, 0x3f,
+	0xf3, 0x99, 0xb9, 0x39, 0xb8, 0x76, 0x44, 0x52, 0xc9, 0x71, 0x2c, 0x12, 0xcc, 0x49, 0xdc, 0x4d,
+	0x83, 0xd1, 0x5a, 0x20, 0xd3, 0x84, 0x88, 0x76, 0xc2, 0x99, 0x64, 0xc8, 0x3d, 0xb6, 0xdf, 0x1e,
+	0xad, 0xb5, 0xf5, 0x7e, 0xab, 0xd5, 0xe5, 0x69, 0x22, 0xd9, 0x97, 0x47, 0x24, 0x15, 0xc9, 0x81,
+	0xfd, 0x33, 0x56, 0x2d, 0xd7, 0xee, 0x09, 0xda, 0x4f, 0x0e, 0xcc, 0xaf, 0xdd, 0x59, 0x94, 0x9c,
+	0x46, 0x11, 0xc5, 0xb1, 0x5d, 0xaf, 0x64, 0xeb, 0x60, 0x80, 0x93, 0x00, 0x27, 0xd4, 0xc8, 0xbd,
+	0x35, 0xa8, 0x3f, 0x65, 0x83, 0x01, 0x95, 0x92, 0x84, 0xe8, 0x3c, 0x94, 0x8f, 0x48, 0xea, 0x3a,
+	0xab, 0xce, 0xed, 0xa6, 0xaf, 0x1e, 0x11, 0x82, 0xb9, 0x10, 0x4b, 0xec, 0x96, 0xb4, 0x48, 0x3f,
+	0x7b, 0x3f, 0x3b, 0xd0, 0x78, 0x1e, 0x4b, 0x9e, 0xbe, 0x4a, 0x42, 0x2c, 0x09, 0x7a, 0x08, 0xd5,
+	0xa1, 0x7e, 0xd2, 0x5a, 0x8d, 0x8e, 0xd7, 0x9e, 0x75, 0x97, 0xf6, 0x1e, 0xed, 0xc7, 0x24, 0xdc,
+	0xda, 0xf7, 0xad, 0x05, 0xfa, 0x16, 0xea, 0xdd, 0xec, 0x78, 0xb7, 0xac, 0xcd, 0x6f, 0xcc, 0x36,
+	0xcf, 0x23, 0xf5, 0xc7, 0x56, 0xde, 0xaf, 0x0e, 0x54, 0x74, 0x38, 0xe8, 0x1a, 0x80, 0x11, 0x0f,
+	0x48, 0x2c, 0xed, 0x2d, 0x0a, 0x12, 0xb4, 0x0d, 0x4b, 0x78, 0x28, 0x0f, 0x19, 0xa7, 0x6f, 0x49,
+	0x18, 0x28, 0x20, 0xdd, 0xd2, 0x6a, 0xf9, 0xe4, 0x23, 0x77, 0x87, 0x07, 0x11, 0xed, 0x6e, 0x91,
+	0xd4, 0x5f, 0x1c, 0xdb, 0x6e, 0x91, 0x54, 0xa0, 0x16, 0xd4, 0x12, 0x4e, 0x46, 0x94, 0x0d, 0x85,
+	0x8e, 0xbc, 0xe9, 0xe7, 0x6b, 0xef, 0x37, 0x07, 0xea, 0xb9, 0x25, 0x6a, 0xc1, 0x3c, 0x09, 0x3b,
+	0xeb, 0xeb, 0x6b, 0x0f, 0x4c, 0x50, 0x1b, 0xe7, 0xfc, 0x4c, 0x80, 0x1e, 0xc1, 0x15, 0x2e, 0x70,
+	0x30, 0x22, 0x9c, 0xf6, 0x52, 0x1a, 0xf7, 0x03, 0x71, 0x88, 0x3b, 0xeb, 0xf7, 0x82, 0xbb, 0x77,
+	0xee, 0x77, 0x0c, 0xea, 0x1b, 0xe7, 0xfc, 0x15, 0x2e, 0xf0, 0x7e, 0xa6, 0xb1, 0xa7, 0x15, 0xd4,
+	0x3e, 0xea, 0xc0, 0x32, 0xe9, 0x86, 0x13, 0xe6, 0x49, 0x67, 0xfd, 0x9e, 0x09, 0x67, 0xe3, 0x9c,
+	0x8f, 0xf4, 0x6e, 0x6e, 0xb9, 0xdb, 0x59, 0xbf, 0xf7, 0x04, 0xa0, 0x76, 0x44, 0x52, 0xcd, 0x3d,
+	0xaf, 0x03, 0xb5, 0x2d, 0x92, 0xee, 0xe3, 0x68, 0x48, 0xa6, 0xe4, 0x7e, 0x19, 0x2a, 0x23, 0xb5,
+	0x65, 0x93, 0x6f, 0x16, 0xde, 0x4f, 0x65, 0xa8, 0x65, 0x69, 0x44, 0xdf, 0x40, 0x5d, 0x39, 0x33,
+	0x6a, 0xce, 0x69, 0xd9, 0xcf, 0xce, 0xf2, 0x6b, 0x47, 0xf6, 0x09, 0xf9, 0x00, 0x82, 0xf6, 0x63,
+	0x2c, 0x87, 0x9c, 0x64, 0xd9, 0xe8, 0x9c, 0xce, 0x9f, 0xf6, 0x5e, 0x6e, 0xa4, 0x53, 0xef, 0x17,
+	0xbc, 0xa0, 0x6d, 0xa8, 0x0d, 0x88, 0xc4, 0x9a, 0xb7, 0x65, 0xed, 0xf1, 0xce, 0x07, 0x78, 0xdc,
+	0xb1, 0x26, 0xc6, 0x5f, 0xee, 0xa1, 0xf5, 0x0a, 0x96, 0x8e, 0x1d, 0x56, 0x84, 0xaa, 0x6e, 0xa0,
+	0xfa, 0xa2, 0x08, 0x55, 0xa3, 0xb3, 0xd2, 0x36, 0xa5, 0xf8, 0x8c, 0xf6, 0xa9, 0xc4, 0x51, 0x94,
+	0x9a, 0x53, 0x2c, 0x84, 0x0f, 0x4b, 0x5f, 0x39, 0xad, 0x47, 0xb0, 0x30, 0x71, 0xe2, 0x14, 0xa7,
+	0x13, 0xf8, 0xd7, 0x0b, 0xc6, 0xde, 0x5f, 0x25, 0xa8, 0xed, 0x0c, 0x25, 0x96, 0x94, 0xc5, 0x85,
+	0xf2, 0x73, 0xce, 0x5c, 0x7e, 0x77, 0xa0, 0x92, 0x70, 0xc6, 0x7a, 0x36, 0xee, 0x56, 0x3b, 0xef,
+	0x1a, 0x3b, 0x38, 0xd9, 0x26, 0xb8, 0xb7, 0x19, 0x77, 0xa3, 0xa1, 0xa0, 0x2c, 0xf6, 0x8d, 0xe2,
+	0xd9, 0xc0, 0xcd, 0x62, 0x9c, 0x05, 0x2e, 0xba, 0x0f, 0xf5, 0x98, 0xbc, 0x09, 0x4c, 0x0c, 0x73,
+	0xa7, 0xc6, 0x50, 0x8b, 0xc9, 0x9b, 0x5d, 0xa5, 0xfb, 0xef, 0xe0, 0xa3, 0xb0, 0xf4, 0x82, 0x48,
+	0x13, 0x0b, 0xf9, 0x7e, 0x48, 0x84, 0x44, 0x97, 0x61, 0x7e, 0x28, 0x08, 0x0f, 0x68, 0x68, 0x5d,
+	0x54, 0xd5, 0x72, 0x33, 0x44, 0x97, 0xa0, 0x8a, 0x93, 0x44, 0xc9, 0xad, 0x1b, 0x9c, 0x24, 0x9b,
+	0x21, 0xfa, 0x04, 0x96, 0x7a, 0x94, 0x0b, 0x19, 0x48, 0x4e, 0x48, 0x20, 0xe8, 0x5b, 0xa2, 0x8b,
+	0xae, 0xec, 0x2f, 0x68, 0xf1, 0x4b, 0x4e, 0xc8, 0x1e, 0x7d, 0x4b, 0xbc, 0xbf, 0x4b, 0x70, 0x7e,
+	0x7c, 0x96, 0x48, 0x58, 0x2c, 0x08, 0xfa, 0x1f, 0xd4, 0x47, 0xbc, 0x67, 0x6f, 0x6d, 0x0a, 0xae,
+	0x36, 0xe2, 0x3d, 0x7d, 0xb3, 0xc9, 0x8e, 0x58, 0xfa, 0x98, 0x8e, 0x88, 0x1e, 0x00, 0x44, 0x04,
+	0x67, 0x07, 0x94, 0x4f, 0x85, 0xb5, 0xae, 0xb4, 0xcd, 0xe9, 0x9f, 0x42, 0x59, 0x0c, 0xb8, 0x4d,
+	0xc5, 0xe5, 0xb1, 0x8d, 0x61, 0xce, 0x0e, 0x4e, 0x7c, 0xc6, 0xa4, 0xaf, 0x74, 0x50, 0x07, 0x6a,
+	0x11, 0xeb, 0x07, 0x9c, 0x31, 0xe9, 0x56, 0xa6, 0xeb, 0x6f, 0xb3, 0xbe, 0xd6, 0x9f, 0x8f, 0xcc,
+	0x03, 0xba, 0x05, 0x4b, 0xca, 0xa6, 0xcb, 0x62, 0x41, 0x85, 0x54, 0x57, 0x71, 0xab, 0xab, 0xe5,
+	0xdb, 0x4d, 0x7f, 0x31, 0x62, 0xfd, 0xa7, 0x63, 0x29, 0xba, 0x01, 0x0b, 0x4a, 0x91, 0x66, 0x31,
+	0xba, 0xf3, 0x5a, 0xad, 0x19, 0xb1, 0x7e, 0x1e, 0xb7, 0xea, 0xb2, 0x97, 0xb7, 0xa9, 0x30, 0xe8,
+	0x6e, 0x50, 0x21, 0xd9, 0x07, 0x24, 0x74, 0x19, 0x2a, 0x42, 0x62, 0x2e, 0x35, 0xb6, 0x65, 0xdf,
+	0x2c, 0x54, 0x4a, 0x12, 0xdc, 0x2f, 0x64, 0xb2, 0xe2, 0xd7, 0x94, 0x40, 0x25, 0xb1, 0xc0, 0x81,
+	0xb9, 0x53, 0x38, 0x50, 0x99, 0xc6, 0x81, 0x1f, 0xc1, 0x7d, 0x3f, 0x4a, 0x4b, 0x85, 0x27, 0x50,
+	0xd5, 0xbc, 0x14, 0xae, 0xa3, 0x8b, 0xe9, 0xb3, 0xd9, 0xa9, 0x3e, 0x4e, 0x23, 0xdf, 0x5a, 0xa2,
+	0xab, 0x00, 0x31, 0xf9, 0x41, 0x06, 0xc5, 0x6b, 0xd5, 0x95, 0x64, 0x4f, 0x09, 0xbc, 0x3f, 0x1d,
+	0x40, 0xe6, 0x4d, 0xfd, 0x5f, 0x30, 0x1e, 0x6d, 0x40, 0x93, 0xa8, 0x73, 0x02, 0xdb, 0x94, 0x0c,
+	0x95, 0x6e, 0xce, 0xbe, 0x57, 0x61, 0x94, 0xf0, 0x1b, 0x64, 0xbc, 0xf0, 0x5e, 0xc3, 0xc5, 0x89,
+	0xb8, 0x2d, 0x64, 0x8f, 0xb3, 0x9e, 0x65, 0xda, 0xdd, 0x59, 0x10, 0x33, 0x86, 0xde, 0x2f, 0x0e,
+	0x5c, 0x7c, 0x41, 0x64, 0xd6, 0x9d, 0x44, 0x06, 0xc9, 0x32, 0x54, 0x48, 0xc2, 0xba, 0x87, 0xda,
+	0x73, 0xd9, 0x37, 0x8b, 0x69, 0x17, 0x2f, 0x4d, 0xbb, 0xf8, 0x55, 0x00, 0x4d, 0x21, 0xc9, 0x8e,
+	0x48, 0xac, 0xb1, 0xa9, 0xfb, 0x9a, 0x54, 0x2f, 0x95, 0x60, 0x92, 0x61, 0x73, 0x93, 0x0c, 0xf3,
+	0x7e, 0x9f, 0x83, 0xe5, 0xc9, 0x88, 0xec, 0x65, 0xa7, 0x87, 0x64, 0xab, 0xb4, 0x74, 0xc6, 0x2a,
+	0x2d, 0x7f, 0x7c, 0x95, 0xce, 0x7d, 0x58, 0x95, 0x56, 0xde, 0xaf, 0x52, 0xf4, 0x18, 0xea, 0x83,
+	0xec, 0x5e, 0xba, 0xda, 0x4f, 0x7c, 0x45, 0x65, 0x10, 0xf8, 0x63, 0x23, 0x95, 0x01, 0x4d, 0xf0,
+	0x02, 0xbc, 0xf3, 0x1a, 0xde, 0x05, 0x25, 0xde, 0xcd, 0x21, 0xbe, 0x05, 0x4b, 0xda, 0x88, 0x71,
+	0x35, 0x10, 0xe9, 0x80, 0x6a, 0x5a, 0x6f, 0xd1, 0x8a, 0xf7, 0x8d, 0x54, 0xc5, 0x2d, 0x06, 0x3c,
+	0xc8, 0x67, 0x06, 0xb7, 0xae, 0x9b, 0x70, 0x53, 0x0c, 0x78, 0xfe, 0xae, 0x47, 0xd7, 0xa1, 0xd9,
+	0x65, 0xb1, 0x24, 0xb1, 0x0c, 0x0e, 0xb1, 0x38, 0x74, 0x41, 0xeb, 0x34, 0xac, 0x6c, 0x03, 0x8b,
+	0x43, 0xf4, 0x39, 0x5c, 0xd0, 0x8c, 0x12, 0x0a, 0x02, 0x36, 0x48, 0x22, 0x22, 0x89, 0xdb, 0x58,
+	0x75, 0x6e, 0xd7, 0xfc, 0xf3, 0x66, 0x63, 0x33, 0x97, 0xa3, 0xff, 0x43, 0x23, 0x22, 0x78, 0x44,
+	0x44, 0xc0, 0x09, 0x0e, 0xdd, 0xa6, 0x4e, 0x28, 0x18, 0x91, 0x4f, 0x70, 0x88, 0x6e, 0xc2, 0xa2,
+	0x55, 0x78, 0xc3, 0x55, 0x23, 0x8f, 0xdd, 0x05, 0xc3, 0x33, 0x23, 0x7d, 0x6d, 0x84, 0xde, 0x8a,
+	0xa6, 0xca, 0x33, 0x36, 0xc0, 0x34, 0xde, 0x8c, 0x7b, 0xcc, 0xb2, 0xd7, 0x7b, 0xe7, 0xc0, 0xa5,
+	0x63, 0x1b, 0x96, 0x44, 0xab, 0x50, 0x8e, 0x58, 0xdf, 0xd6, 0xcb, 0xe2, 0x38, 0xfd, 0x8a, 0xba,
+	0xbe, 0xda, 0x52, 0x1a, 0x03, 0x9c, 0xb8, 0xa5, 0xe9, 0x1a, 0x03, 0x9c, 0xa0, 0x1b, 0x50, 0x1e,
+	0xf1, 0xec, 0x65, 0x72, 0xa1, 0x6d, 0xbf, 0x42, 0xc6, 0xd3, 0xb1, 0xda, 0xf5, 0xae, 0x43, 0xe3,
+	0x95, 0x20, 0x7c, 0x97, 0xb3, 0x1e, 0x8d, 0x48, 0xfe, 0xf1, 0xe0, 0x14, 0x3e, 0x1e, 0xde, 0x95,
+	0xe0, 0xca, 0x13, 0x2c, 0xbb, 0x87, 0xe3, 0xd2, 0xa6, 0x24, 0xaf, 0xc0, 0x97, 0x50, 0x51, 0x5d,
+	0x28, 0xeb, 0x86, 0x5f, 0xcf, 0xe6, 0xc9, 0x4c, 0x1f, 0x6d, 0x15, 0x81, 0x9d, 0x0a, 0x8d, 0xb3,
+	0x59, 0x1d, 0xed, 0x12, 0x54, 0xd5, 0xf0, 0x4a, 0x43, 0x5b, 0xac, 0x95, 0x23, 0x92, 0x6e, 0x86,
+	0xad, 0x00, 0x60, 0xec, 0x62, 0xca, 0x5c, 0xf1, 0x68, 0x72, 0xd6, 0x3b, 0xa1, 0xb3, 0x15, 0xb0,
+	0x28, 0x8e, 0x1f, 0x7f, 0x38, 0xd0, 0x9a, 0x16, 0xbe, 0xcd, 0xd6, 0x77, 0x50, 0x25, 0x9c, 0xb3,
+	0x1c, 0x84, 0xc7, 0x67, 0x03, 0xc1, 0x78, 0x69, 0x3f, 0xd7, 0x2e, 0x0c, 0x0c, 0xd6, 0x5f, 0xeb,
+	0x01, 0x34, 0x0a, 0xe2, 0x33, 0x8d, 0x4c, 0xc8, 0x8c, 0x31, 0xaa, 0xfb, 0x64, 0x40, 0x7b, 0x18,
+	0x2e, 0x14, 0x64, 0x36, 0xfa, 0xed, 0x62, 0xb5, 0x1b, 0xc6, 0xb5, 0x4f, 0xec, 0xd0, 0xef, 0xf5,
+	0xbc, 0x42, 0xe5, 0x1f, 0x54, 0xf5, 0x47, 0xea, 0xdd, 0x7f, 0x06, 0x00, 0xc9, 0xfe, 0x0b, 0xb3,
+	0x3e, 0x0f, 0x00, 0x00,
 }
@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// epochParentKey is the context key of the context passed to beginEpoch.
+type epochParentKey struct{}
+
+// epochAborter holds the cancel function of the epoch being created.
+type epochAborter struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	aborted bool
+}
+
+// AbortCurrentEpoch cancels the epoch being created, if any, e.g. to
+// interrupt an epoch waiting on an unresponsive map or log server without
+// stopping the signer. The epoch fails with ErrEpochAborted and its mutations
+// are picked up by the next epoch. Epochs can only be aborted before their map
+// revision is written, since the map would otherwise be left ahead of the log.
+// AbortCurrentEpoch returns false if no epoch was being created, or if its map
+// revision is being or has been written.
+func (s *sequencer) AbortCurrentEpoch() bool {
+	s.aborter.mu.Lock()
+	defer s.aborter.mu.Unlock()
+	if s.aborter.cancel == nil {
+		return false
+	}
+	s.aborter.cancel()
+	s.aborter.aborted = true
+	return true
+}
+
+// beginEpoch returns a context for an epoch that AbortCurrentEpoch can cancel,
+// and a function to call once the epoch is done, which reports whether it was
+// aborted.
+func (s *sequencer) beginEpoch(ctx context.Context) (context.Context, func() bool) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	s.aborter.mu.Lock()
+	s.aborter.cancel = cancel
+	s.aborter.aborted = false
+	s.aborter.mu.Unlock()
+	ctx = context.WithValue(ctx, epochParentKey{}, parent)
+	return ctx, func() bool {
+		s.aborter.mu.Lock()
+		defer s.aborter.mu.Unlock()
+		cancel()
+		s.aborter.cancel = nil
+		return s.aborter.aborted
+	}
+}
+
+// commitEpoch is called before writing the map revision of the epoch of ctx.
+// It returns ErrEpochAborted if the epoch was aborted. Otherwise, further
+// calls to AbortCurrentEpoch return false, and the returned context is only
+// cancelled along with the context passed to beginEpoch.
+func (s *sequencer) commitEpoch(ctx context.Context) (context.Context, error) {
+	parent, ok := ctx.Value(epochParentKey{}).(context.Context)
+	if !ok {
+		return ctx, nil
+	}
+	s.aborter.mu.Lock()
+	defer s.aborter.mu.Unlock()
+	if s.aborter.aborted {
+		return nil, ErrEpochAborted
+	}
+	s.aborter.cancel = nil
+	return committedContext{Context: ctx, parent: parent}, nil
+}
+
+// committedContext holds the values of its embedded context, but is only
+// cancelled along with parent.
+type committedContext struct {
+	context.Context
+	parent context.Context
+}
+
+func (c committedContext) Deadline() (time.Time, bool) { return c.parent.Deadline() }
+func (c committedContext) Done() <-chan struct{}       { return c.parent.Done() }
+func (c committedContext) Err() error                  { return c.parent.Err() }
@@ -0,0 +1,110 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestAbortCurrentEpoch(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	tmap := &slowMap{fakeMap: newFakeMap(), getLeavesDelay: time.Hour}
+	s.tmap = tmap
+
+	if s.AbortCurrentEpoch() {
+		t.Errorf("AbortCurrentEpoch(): true without an epoch in progress")
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.CreateEpoch(ctx, false)
+	}()
+	for !s.AbortCurrentEpoch() {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		if err != ErrEpochAborted {
+			t.Errorf("CreateEpoch(): %v, want %v", err, ErrEpochAborted)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CreateEpoch() did not return after AbortCurrentEpoch()")
+	}
+	if got, want := len(tmap.roots), 1; got != want {
+		t.Errorf("len(roots): %v, want %v", got, want)
+	}
+	if s.AbortCurrentEpoch() {
+		t.Errorf("AbortCurrentEpoch(): true after the epoch returned")
+	}
+}
+
+// blockingLog blocks QueueLeaf until release is closed or ctx is done.
+type blockingLog struct {
+	*fakeLog
+	queued  chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	close(l.queued)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.release:
+	}
+	return l.fakeLog.QueueLeaf(ctx, in, opts...)
+}
+
+func TestAbortAfterSetLeaves(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	tlog := &blockingLog{
+		fakeLog: &fakeLog{},
+		queued:  make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	s.tlog = tlog
+
+	done := make(chan error)
+	go func() {
+		done <- s.CreateEpoch(ctx, false)
+	}()
+	<-tlog.queued
+	// The map revision has been written, so the epoch must complete.
+	if s.AbortCurrentEpoch() {
+		t.Errorf("AbortCurrentEpoch(): true after SetLeaves")
+	}
+	close(tlog.release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("CreateEpoch(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CreateEpoch() did not return")
+	}
+	if got, want := len(tlog.leaves), 1; got != want {
+		t.Errorf("len(log leaves): %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+)
+
+// AuditRecord is a compact description of an epoch, meant for append-only
+// audit logs. Unlike the epochs sent to mutation channels, it does not carry
+// the mutations themselves.
+type AuditRecord struct {
+	// Revision is the map revision of the epoch.
+	Revision int64
+	// RootHash is the map root hash of the epoch.
+	RootHash []byte
+	// StartSequence and EndSequence bound the sequence numbers of the
+	// mutations of the epoch, in (StartSequence, EndSequence].
+	StartSequence, EndSequence int64
+	// Mutations is the number of mutations of the epoch.
+	Mutations int
+	// Time is the timestamp of the map root.
+	Time time.Time
+	// Indexes are the indexes of the leaves changed by the epoch, in index
+	// order.
+	Indexes [][]byte
+}
+
+// AuditSink receives an AuditRecord for every epoch.
+type AuditSink interface {
+	// Write appends r to the audit log.
+	Write(ctx context.Context, r *AuditRecord) error
+}
+
+// audit writes the AuditRecord of an epoch to AuditSink, if set. Errors are
+// logged and counted but do not fail the epoch, which has been committed.
+func (s *sequencer) audit(ctx context.Context, smr *trillian.SignedMapRoot, startSequence, endSequence int64, mutations int, leaves []*trillian.MapLeaf) {
+	if s.AuditSink == nil {
+		return
+	}
+	indexes := make([][]byte, 0, len(leaves))
+	for _, l := range leaves {
+		indexes = append(indexes, l.GetIndex())
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return bytes.Compare(indexes[i], indexes[j]) < 0
+	})
+	r := &AuditRecord{
+		Revision:      smr.GetMapRevision(),
+		RootHash:      smr.GetRootHash(),
+		StartSequence: startSequence,
+		EndSequence:   endSequence,
+		Mutations:     mutations,
+		Time:          time.Unix(0, smr.GetTimestampNanos()),
+		Indexes:       indexes,
+	}
+	if err := s.AuditSink.Write(ctx, r); err != nil {
+		glog.Errorf("CreateEpoch: AuditSink.Write(%v): %v", r.Revision, err)
+		auditErrCtr.Inc()
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// AuditSink fake.
+type fakeAuditSink struct {
+	records []*AuditRecord
+}
+
+func (a *fakeAuditSink) Write(ctx context.Context, r *AuditRecord) error {
+	a.records = append(a.records, r)
+	return nil
+}
+
+func TestAuditSink(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	sink := &fakeAuditSink{}
+	s.AuditSink = sink
+	tmap := s.tmap.(*fakeMap)
+
+	updates := signedKV(2, 2)
+	updates[0].KeyValue.Value = []byte("value_2b")
+	for i, tc := range []struct {
+		mutations          int
+		write              func()
+		wantStart, wantEnd int64
+		wantIndexes        []string
+	}{
+		{3, func() { fakeMutations.write(signedKV(3, 3)...); fakeMutations.write(signedKV(1, 2)...) }, 0, 3, []string{"key_1", "key_2", "key_3"}},
+		{1, func() { fakeMutations.write(updates...) }, 3, 4, []string{"key_2"}},
+	} {
+		tc.write()
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		if got, want := len(sink.records), i+1; got != want {
+			t.Fatalf("len(records): %v, want %v", got, want)
+		}
+		r := sink.records[i]
+		root := tmap.roots[len(tmap.roots)-1]
+		if r.Revision != root.GetMapRevision() || !bytes.Equal(r.RootHash, root.GetRootHash()) {
+			t.Errorf("record: revision %v, root %x, want %v, %x", r.Revision, r.RootHash, root.GetMapRevision(), root.GetRootHash())
+		}
+		if !r.Time.Equal(time.Unix(0, root.GetTimestampNanos())) {
+			t.Errorf("record: time %v, want %v", r.Time, time.Unix(0, root.GetTimestampNanos()))
+		}
+		if r.StartSequence != tc.wantStart || r.EndSequence != tc.wantEnd || r.Mutations != tc.mutations {
+			t.Errorf("record: sequences (%v, %v], %v mutations, want (%v, %v], %v",
+				r.StartSequence, r.EndSequence, r.Mutations, tc.wantStart, tc.wantEnd, tc.mutations)
+		}
+		var indexes []string
+		for _, index := range r.Indexes {
+			indexes = append(indexes, string(index))
+		}
+		if !reflect.DeepEqual(indexes, tc.wantIndexes) {
+			t.Errorf("record: indexes %v, want %v", indexes, tc.wantIndexes)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// subscriber is a channel registered to receive new epochs.
+type subscriber struct {
+	ch chan<- *tpb.GetMutationsResponse
+	// buf, if not nil, holds the epochs that have not been delivered to ch
+	// yet.
+	buf chan *tpb.GetMutationsResponse
+	// blocking is set if buf only holds the unclaimed epochs of an
+	// unbuffered subscriber, in which case epochs wait for room in buf
+	// instead of replacing the oldest one.
+	blocking bool
+	// done is closed when the subscriber is unregistered.
+	done chan struct{}
+}
+
+// RegisterMutationsChannel registers ch to receive the GetMutationsResponse of
+// every epoch created from now on.
+//
+// If bufferSize is zero, epochs are sent to ch directly and CreateEpoch blocks
+// until ch is ready or unregistered, or until the dissemination queue has room
+// if DisseminationQueueSize is set, so subscribers must keep up with the
+// signer.
+// Otherwise epochs are queued in a buffer of bufferSize epochs and delivered
+// to ch in the background. When the buffer is full the oldest epoch is
+// dropped, so a slow subscriber is at most bufferSize epochs behind, plus the
+// epoch being delivered, and never stalls the signer.
+//
+// The epochs kept because of UnclaimedEpochs are sent to ch first, in order.
+// If bufferSize is zero, they are delivered in the background and later
+// epochs queue up behind them, so CreateEpoch only blocks once ch is more
+// than UnclaimedEpochs epochs behind.
+func (s *sequencer) RegisterMutationsChannel(ch chan<- *tpb.GetMutationsResponse, bufferSize int) {
+	sub := &subscriber{ch: ch, done: make(chan struct{})}
+	s.mMux.Lock()
+	defer s.mMux.Unlock()
+	if bufferSize <= 0 && len(s.unclaimed) > 0 {
+		bufferSize = len(s.unclaimed)
+		sub.blocking = true
+	}
+	if bufferSize > 0 {
+		sub.buf = make(chan *tpb.GetMutationsResponse, bufferSize)
+		go sub.forward()
+	}
+	for _, resp := range s.unclaimed {
+		sub.push(resp)
+	}
+	s.unclaimed = nil
+	s.mChannels = append(s.mChannels, sub)
+	channelsGauge.Set(float64(len(s.mChannels)))
+}
+
+// UnregisterMutationsChannel stops sending epochs to ch. Buffered epochs that
+// have not been delivered yet are discarded.
+func (s *sequencer) UnregisterMutationsChannel(ch chan<- *tpb.GetMutationsResponse) {
+	s.mMux.Lock()
+	defer s.mMux.Unlock()
+	for i, sub := range s.mChannels {
+		if sub.ch == ch {
+			close(sub.done)
+			s.mChannels = append(s.mChannels[:i], s.mChannels[i+1:]...)
+			break
+		}
+	}
+	channelsGauge.Set(float64(len(s.mChannels)))
+}
+
+// disseminateMutations sends resp to all registered channels, or keeps it
+// for the next registered channel if there are none and UnclaimedEpochs is
+// set. Channels may be registered and unregistered while it waits for a slow
+// subscriber. Once ctx is done, subscribers that are not ready to receive resp
+// miss it.
+func (s *sequencer) disseminateMutations(ctx context.Context, resp *tpb.GetMutationsResponse) {
+	s.mMux.Lock()
+	if len(s.mChannels) == 0 && s.UnclaimedEpochs > 0 {
+		s.unclaimed = append(s.unclaimed, resp)
+		if n := len(s.unclaimed) - s.UnclaimedEpochs; n > 0 {
+			glog.Warningf("disseminateMutations: no registered channel, dropping %v unclaimed epochs", n)
+			droppedEpochsCtr.Add(float64(n))
+			s.unclaimed = s.unclaimed[n:]
+		}
+		s.mMux.Unlock()
+		return
+	}
+	subs := make([]*subscriber, len(s.mChannels))
+	copy(subs, s.mChannels)
+	s.mMux.Unlock()
+
+	for _, sub := range subs {
+		if sub.buf != nil && !sub.blocking {
+			sub.push(resp)
+			continue
+		}
+		ch := sub.ch
+		if sub.blocking {
+			ch = sub.buf
+		}
+		select {
+		case ch <- resp:
+		case <-sub.done:
+		case <-ctx.Done():
+			// Still deliver resp to the other subscribers.
+			glog.Errorf("disseminateMutations(%v): %v, dropping the epoch for a blocked subscriber", resp.GetEpoch(), ctx.Err())
+			droppedEpochsCtr.Inc()
+		}
+	}
+}
+
+// push adds resp to the buffer, dropping the oldest epochs if it is full.
+func (sub *subscriber) push(resp *tpb.GetMutationsResponse) {
+	for {
+		select {
+		case sub.buf <- resp:
+			return
+		default:
+		}
+		select {
+		case dropped := <-sub.buf:
+			glog.Warningf("disseminateMutations: buffer full, dropping epoch %v", dropped.GetEpoch())
+			droppedEpochsCtr.Inc()
+		default:
+		}
+	}
+}
+
+// forward delivers buffered epochs to the subscriber until it is unregistered.
+func (sub *subscriber) forward() {
+	for {
+		select {
+		case resp := <-sub.buf:
+			select {
+			case sub.ch <- resp:
+			case <-sub.done:
+				return
+			}
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// disseminationQueue holds the epochs waiting to be delivered to the
+// subscribers when DisseminationQueueSize is set.
+type disseminationQueue struct {
+	mu     sync.Mutex
+	queue  chan *tpb.GetMutationsResponse
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// publish delivers resp to the subscribers, through the dissemination queue if
+// DisseminationQueueSize is set. The dissemination goroutine is started on
+// first use.
+func (s *sequencer) publish(ctx context.Context, resp *tpb.GetMutationsResponse) {
+	if s.DisseminationQueueSize <= 0 {
+		s.disseminateMutations(ctx, resp)
+		return
+	}
+	d := &s.dissemination
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.queue == nil {
+		var dctx context.Context
+		dctx, d.cancel = context.WithCancel(context.Background())
+		d.queue = make(chan *tpb.GetMutationsResponse, s.DisseminationQueueSize)
+		d.done = make(chan struct{})
+		go s.disseminate(dctx, d.queue, d.done)
+	}
+	select {
+	case d.queue <- resp:
+	case <-ctx.Done():
+		glog.Errorf("publish(%v): dissemination queue full: %v", resp.GetEpoch(), ctx.Err())
+	}
+}
+
+// disseminate delivers the epochs of queue to the subscribers until queue is
+// closed, then closes done.
+func (s *sequencer) disseminate(ctx context.Context, queue <-chan *tpb.GetMutationsResponse, done chan<- struct{}) {
+	defer close(done)
+	for resp := range queue {
+		s.disseminateMutations(ctx, resp)
+	}
+}
+
+// StopDissemination stops the goroutine started when DisseminationQueueSize
+// is set, after it delivers the queued epochs. If ctx is done first, the
+// remaining epochs are discarded. A later epoch starts a new goroutine.
+func (s *sequencer) StopDissemination(ctx context.Context) {
+	d := &s.dissemination
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.queue == nil {
+		return
+	}
+	close(d.queue)
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		glog.Warningf("StopDissemination: %v, discarding queued epochs", ctx.Err())
+		d.cancel()
+		<-d.done
+	}
+	d.cancel()
+	d.queue = nil
+}
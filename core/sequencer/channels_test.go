@@ -0,0 +1,256 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+func TestRegisteredChannels(t *testing.T) {
+	s := newTestSequencer(&fakeMutation{})
+	chs := make([]chan *tpb.GetMutationsResponse, 3)
+	for i := range chs {
+		chs[i] = make(chan *tpb.GetMutationsResponse, 1)
+		s.RegisterMutationsChannel(chs[i], 0)
+	}
+	s.UnregisterMutationsChannel(chs[1])
+	if got, want := gaugeValue(t, channelsGauge), 2.0; got != want {
+		t.Errorf("channelsGauge: %v, want %v", got, want)
+	}
+
+	if err := s.CreateEpoch(context.Background(), true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	for i, want := range []int{1, 0, 1} {
+		if got := len(chs[i]); got != want {
+			t.Errorf("channel %v received %v epochs, want %v", i, got, want)
+		}
+	}
+}
+
+func TestBufferedChannel(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	ch := make(chan *tpb.GetMutationsResponse)
+	s.RegisterMutationsChannel(ch, 3)
+	defer s.UnregisterMutationsChannel(ch)
+
+	// The subscriber does not read while epochs are created.
+	before := counterValue(t, droppedEpochsCtr)
+	for i := 0; i < 10; i++ {
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	var epochs []int64
+	for len(epochs) == 0 || epochs[len(epochs)-1] != 10 {
+		select {
+		case resp := <-ch:
+			epochs = append(epochs, resp.Epoch)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for epoch 10, received %v", epochs)
+		}
+	}
+	// One epoch may have been in flight before the buffer filled up.
+	if len(epochs) > 4 {
+		t.Errorf("Received epochs %v, want at most 4", epochs)
+	}
+	if got, want := epochs[len(epochs)-3:], []int64{8, 9, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Received epochs %v, want %v last", epochs, want)
+	}
+	if got, want := counterValue(t, droppedEpochsCtr)-before, float64(10-len(epochs)); got != want {
+		t.Errorf("droppedEpochsCtr: %v, want %v", got, want)
+	}
+}
+
+func TestUnregisterBlockedChannel(t *testing.T) {
+	s := newTestSequencer(&fakeMutation{})
+	// Nobody reads from ch.
+	ch := make(chan *tpb.GetMutationsResponse)
+	s.RegisterMutationsChannel(ch, 0)
+
+	done := make(chan error)
+	go func() {
+		done <- s.CreateEpoch(context.Background(), true)
+	}()
+	// Registering and unregistering channels does not wait for ch.
+	other := make(chan *tpb.GetMutationsResponse, 1)
+	s.RegisterMutationsChannel(other, 0)
+	s.UnregisterMutationsChannel(other)
+	// CreateEpoch stops waiting once ch is unregistered.
+	s.UnregisterMutationsChannel(ch)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CreateEpoch() blocked on an unregistered channel")
+	}
+}
+
+func TestDisseminateCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := newTestSequencer(&fakeMutation{})
+	// Nobody reads from blocked.
+	blocked := make(chan *tpb.GetMutationsResponse)
+	s.RegisterMutationsChannel(blocked, 0)
+	buffered := make(chan *tpb.GetMutationsResponse, 1)
+	s.RegisterMutationsChannel(buffered, 1)
+
+	before := counterValue(t, droppedEpochsCtr)
+	s.disseminateMutations(ctx, &tpb.GetMutationsResponse{Epoch: 1})
+	select {
+	case resp := <-buffered:
+		if got, want := resp.GetEpoch(), int64(1); got != want {
+			t.Errorf("Epoch: %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Epoch not delivered after a blocked subscriber")
+	}
+	if got, want := counterValue(t, droppedEpochsCtr)-before, 1.0; got != want {
+		t.Errorf("droppedEpochsCtr: %v, want %v", got, want)
+	}
+}
+
+func TestDisseminationQueue(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	s.DisseminationQueueSize = 2
+	// The subscriber does not read until the epochs are created.
+	ch := make(chan *tpb.GetMutationsResponse)
+	s.RegisterMutationsChannel(ch, 0)
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 2; i++ {
+			if err := s.CreateEpoch(ctx, true); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CreateEpoch() blocked on a slow subscriber")
+	}
+	for _, want := range []int64{1, 2} {
+		select {
+		case resp := <-ch:
+			if got := resp.GetEpoch(); got != want {
+				t.Errorf("Received epoch %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for epoch %v", want)
+		}
+	}
+
+	// Stopping discards the epochs that cannot be delivered in time.
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	s.StopDissemination(cctx)
+	select {
+	case resp := <-ch:
+		t.Errorf("Received epoch %v after StopDissemination", resp.GetEpoch())
+	default:
+	}
+}
+
+func TestDisseminationStopTimeout(t *testing.T) {
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.ShutdownGracePeriod = time.Second
+	s.DisseminationQueueSize = 2
+	s.DisseminationStopTimeout = 5 * time.Second
+	// Prevent StartSigning from forcing an epoch when it starts.
+	s.tmap.(*fakeMap).roots[0].TimestampNanos = time.Now().UnixNano()
+	ch := make(chan *tpb.GetMutationsResponse)
+	s.RegisterMutationsChannel(ch, 0)
+
+	received := make(chan int64)
+	go func() {
+		// The subscriber is slow to read the final epoch.
+		time.Sleep(50 * time.Millisecond)
+		received <- (<-ch).GetEpoch()
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.StartSigning(ctx, time.Hour, 2*time.Hour); err != nil {
+		t.Fatalf("StartSigning(): %v", err)
+	}
+	select {
+	case got := <-received:
+		if want := int64(1); got != want {
+			t.Errorf("Received epoch %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The final epoch was not delivered")
+	}
+}
+
+func TestUnclaimedEpochs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	s.UnclaimedEpochs = 2
+	// Only the two most recent epochs are kept.
+	for i := 0; i < 3; i++ {
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+
+	// Registering an unbuffered channel does not wait for it to receive the
+	// unclaimed epochs.
+	ch := make(chan *tpb.GetMutationsResponse)
+	s.RegisterMutationsChannel(ch, 0)
+	defer s.UnregisterMutationsChannel(ch)
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	// A second channel only receives new epochs.
+	late := make(chan *tpb.GetMutationsResponse, 3)
+	s.RegisterMutationsChannel(late, 0)
+	var epochs []int64
+	for len(epochs) < 3 {
+		select {
+		case resp := <-ch:
+			epochs = append(epochs, resp.Epoch)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for epochs, received %v", epochs)
+		}
+	}
+	if got, want := epochs, []int64{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Received epochs %v, want %v", got, want)
+	}
+	if got := len(late); got != 0 {
+		t.Errorf("Late channel received %v epochs, want 0", got)
+	}
+}
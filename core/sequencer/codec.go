@@ -0,0 +1,106 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian"
+)
+
+// LeafCodec determines how map roots are serialized into log leaves.
+type LeafCodec int
+
+const (
+	// JSONLeafCodec serializes map roots as JSON.
+	JSONLeafCodec LeafCodec = iota
+	// ProtoLeafCodec serializes map roots with the protobuf wire encoding,
+	// which is more compact than JSON. The encoding is deterministic since
+	// SignedMapRoot does not contain map fields.
+	ProtoLeafCodec
+)
+
+// compressedLeafPrefix starts compressed leaf values. Neither JSON nor the
+// protobuf wire encoding of a map root can start with a zero byte.
+var compressedLeafPrefix = []byte{0x00, 'k', 't', 'z'}
+
+// Marshal serializes smr.
+func (c LeafCodec) Marshal(smr *trillian.SignedMapRoot) ([]byte, error) {
+	switch c {
+	case JSONLeafCodec:
+		return json.Marshal(smr)
+	case ProtoLeafCodec:
+		return proto.Marshal(smr)
+	default:
+		return nil, fmt.Errorf("unknown leaf codec %v", c)
+	}
+}
+
+// Unmarshal parses a map root serialized by Marshal.
+func (c LeafCodec) Unmarshal(leafValue []byte) (*trillian.SignedMapRoot, error) {
+	smr := &trillian.SignedMapRoot{}
+	var err error
+	switch c {
+	case JSONLeafCodec:
+		err = json.Unmarshal(leafValue, smr)
+	case ProtoLeafCodec:
+		err = proto.Unmarshal(leafValue, smr)
+	default:
+		err = fmt.Errorf("unknown leaf codec %v", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return smr, nil
+}
+
+// compressLeafValue returns leafValue compressed with DEFLATE, preceded by
+// compressedLeafPrefix.
+func compressLeafValue(leafValue []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(compressedLeafPrefix)
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(leafValue); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressLeafValue returns the serialized map root held by a log leaf
+// value, which can then be parsed by LeafCodec.Unmarshal. Leaf values that
+// were not compressed are returned unchanged.
+func DecompressLeafValue(leafValue []byte) ([]byte, error) {
+	if !bytes.HasPrefix(leafValue, compressedLeafPrefix) {
+		return leafValue, nil
+	}
+	r := flate.NewReader(bytes.NewReader(leafValue[len(compressedLeafPrefix):]))
+	defer r.Close()
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing leaf value: %v", err)
+	}
+	return value, nil
+}
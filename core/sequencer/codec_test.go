@@ -0,0 +1,144 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian"
+	"github.com/google/trillian/crypto/sigpb"
+	"golang.org/x/net/context"
+)
+
+func TestLeafCodecs(t *testing.T) {
+	smr := &trillian.SignedMapRoot{
+		TimestampNanos: 1500000000000000000,
+		RootHash:       bytes.Repeat([]byte{0xab}, 32),
+		Metadata:       &trillian.MapperMetadata{HighestFullyCompletedSeq: 42},
+		Signature:      &sigpb.DigitallySigned{Signature: []byte("signature")},
+		MapId:          mapID,
+		MapRevision:    7,
+	}
+	sizes := make(map[LeafCodec]int)
+	for _, codec := range []LeafCodec{JSONLeafCodec, ProtoLeafCodec} {
+		leafValue, err := codec.Marshal(smr)
+		if err != nil {
+			t.Fatalf("Marshal(codec: %v): %v", codec, err)
+		}
+		again, err := codec.Marshal(smr)
+		if err != nil {
+			t.Fatalf("Marshal(codec: %v): %v", codec, err)
+		}
+		if !bytes.Equal(leafValue, again) {
+			t.Errorf("Marshal(codec: %v) is not stable: %x != %x", codec, leafValue, again)
+		}
+		got, err := codec.Unmarshal(leafValue)
+		if err != nil {
+			t.Fatalf("Unmarshal(codec: %v): %v", codec, err)
+		}
+		if !proto.Equal(got, smr) {
+			t.Errorf("Unmarshal(codec: %v): %v, want %v", codec, got, smr)
+		}
+		leaf, err := mapRootLeaf(smr, codec, false)
+		if err != nil {
+			t.Fatalf("mapRootLeaf(codec: %v): %v", codec, err)
+		}
+		if !bytes.Equal(leaf.LeafValue, leafValue) {
+			t.Errorf("mapRootLeaf(codec: %v).LeafValue: %x, want %x", codec, leaf.LeafValue, leafValue)
+		}
+		if want := leafIdentityHash(smr, leafValue); !bytes.Equal(leaf.LeafIdentityHash, want) {
+			t.Errorf("mapRootLeaf(codec: %v).LeafIdentityHash: %x, want %x", codec, leaf.LeafIdentityHash, want)
+		}
+		sizes[codec] = len(leafValue)
+	}
+	if sizes[ProtoLeafCodec] >= sizes[JSONLeafCodec] {
+		t.Errorf("proto leaf size %v, want smaller than JSON leaf size %v", sizes[ProtoLeafCodec], sizes[JSONLeafCodec])
+	}
+	if _, err := LeafCodec(-1).Marshal(smr); err == nil {
+		t.Errorf("Marshal(unknown codec): nil, want error")
+	}
+}
+
+func TestCompressedLeaves(t *testing.T) {
+	smr := &trillian.SignedMapRoot{
+		TimestampNanos: 1500000000000000000,
+		RootHash:       bytes.Repeat([]byte{0xab}, 32),
+		Metadata:       &trillian.MapperMetadata{HighestFullyCompletedSeq: 42},
+		Signature:      &sigpb.DigitallySigned{Signature: []byte("signature")},
+		MapId:          mapID,
+		MapRevision:    7,
+	}
+	for _, codec := range []LeafCodec{JSONLeafCodec, ProtoLeafCodec} {
+		leafValue, err := codec.Marshal(smr)
+		if err != nil {
+			t.Fatalf("Marshal(codec: %v): %v", codec, err)
+		}
+		leaf, err := mapRootLeaf(smr, codec, true)
+		if err != nil {
+			t.Fatalf("mapRootLeaf(codec: %v): %v", codec, err)
+		}
+		if !bytes.HasPrefix(leaf.LeafValue, compressedLeafPrefix) {
+			t.Errorf("mapRootLeaf(codec: %v).LeafValue: %x, want prefix %x", codec, leaf.LeafValue, compressedLeafPrefix)
+		}
+		if want := leafIdentityHash(smr, leaf.LeafValue); !bytes.Equal(leaf.LeafIdentityHash, want) {
+			t.Errorf("mapRootLeaf(codec: %v).LeafIdentityHash: %x, want %x", codec, leaf.LeafIdentityHash, want)
+		}
+		got, err := DecompressLeafValue(leaf.LeafValue)
+		if err != nil {
+			t.Fatalf("DecompressLeafValue(codec: %v): %v", codec, err)
+		}
+		if !bytes.Equal(got, leafValue) {
+			t.Errorf("DecompressLeafValue(codec: %v): %x, want %x", codec, got, leafValue)
+		}
+		root, err := codec.Unmarshal(got)
+		if err != nil {
+			t.Fatalf("Unmarshal(codec: %v): %v", codec, err)
+		}
+		if !proto.Equal(root, smr) {
+			t.Errorf("Unmarshal(codec: %v): %v, want %v", codec, root, smr)
+		}
+		// Uncompressed leaf values are returned unchanged.
+		if got, err := DecompressLeafValue(leafValue); err != nil || !bytes.Equal(got, leafValue) {
+			t.Errorf("DecompressLeafValue(uncompressed): %x, %v, want %x, nil", got, err, leafValue)
+		}
+	}
+	corrupt := append(append([]byte(nil), compressedLeafPrefix...), 0xff, 0xff)
+	if _, err := DecompressLeafValue(corrupt); err == nil {
+		t.Errorf("DecompressLeafValue(corrupt): nil, want error")
+	}
+}
+
+func TestInvalidLeafCodec(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.LeafCodec = LeafCodec(-1)
+	tmap := s.tmap.(*fakeMap)
+	tlog := s.tlog.(*fakeLog)
+
+	if err := s.CreateEpoch(ctx, false); err == nil {
+		t.Fatalf("CreateEpoch(): nil error, want a marshal error")
+	}
+	// The map must not be left ahead of the log.
+	if got, want := len(tmap.roots), 1; got != want {
+		t.Errorf("len(roots): %v, want %v", got, want)
+	}
+	if got, want := len(tlog.leaves), 0; got != want {
+		t.Errorf("len(log leaves): %v, want %v", got, want)
+	}
+}
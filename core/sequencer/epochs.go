@@ -0,0 +1,124 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"fmt"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// epochReadBatch is the number of log leaves read per request by ReadEpochs.
+const epochReadBatch = 100
+
+// EpochReader reads the signed map roots that a sequencer added to its log, one
+// per epoch, e.g. for verifiers and auditors. The leaf at index i of the log
+// holds the map root of map revision i, which is epoch i + EpochOffset.
+type EpochReader struct {
+	// Codec is the LeafCodec used by the sequencer that wrote the log.
+	Codec LeafCodec
+	// EpochOffset is the EpochOffset of the sequencer that wrote the log.
+	EpochOffset int64
+
+	tlog     trillian.TrillianLogClient
+	logID    int64
+	callOpts []grpc.CallOption
+}
+
+// NewEpochReader returns an EpochReader for log logID. The optional callOpts
+// are applied to every call to the log.
+func NewEpochReader(tlog trillian.TrillianLogClient, logID int64, callOpts ...grpc.CallOption) *EpochReader {
+	return &EpochReader{
+		tlog:     tlog,
+		logID:    logID,
+		callOpts: callOpts,
+	}
+}
+
+// ReadEpoch returns the map root of epoch.
+func (r *EpochReader) ReadEpoch(ctx context.Context, epoch int64) (*trillian.SignedMapRoot, error) {
+	revision := epoch - r.EpochOffset
+	smrs, err := r.readRevisions(ctx, revision, revision+1)
+	if err != nil {
+		return nil, err
+	}
+	return smrs[0], nil
+}
+
+// ReadEpochs calls f with the map roots of epochs start to end exclusive, in
+// order. It stops at the first error returned by f and returns it.
+func (r *EpochReader) ReadEpochs(ctx context.Context, start, end int64, f func(epoch int64, smr *trillian.SignedMapRoot) error) error {
+	start, end = start-r.EpochOffset, end-r.EpochOffset
+	for batch := start; batch < end; batch += epochReadBatch {
+		batchEnd := batch + epochReadBatch
+		if batchEnd > end {
+			batchEnd = end
+		}
+		smrs, err := r.readRevisions(ctx, batch, batchEnd)
+		if err != nil {
+			return err
+		}
+		for i, smr := range smrs {
+			if err := f(batch+int64(i)+r.EpochOffset, smr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readRevisions returns the map roots of map revisions start to end
+// exclusive, in order.
+func (r *EpochReader) readRevisions(ctx context.Context, start, end int64) ([]*trillian.SignedMapRoot, error) {
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("invalid revision range [%v, %v)", start, end)
+	}
+	indexes := make([]int64, 0, end-start)
+	for i := start; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+	resp, err := r.tlog.GetLeavesByIndex(ctx, &trillian.GetLeavesByIndexRequest{
+		LogId:     r.logID,
+		LeafIndex: indexes,
+	}, r.callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("GetLeavesByIndex(%v, [%v, %v)): %v", r.logID, start, end, err)
+	}
+	// The log may return the leaves in any order.
+	smrs := make([]*trillian.SignedMapRoot, end-start)
+	for _, leaf := range resp.GetLeaves() {
+		i := leaf.GetLeafIndex()
+		if i < start || i >= end {
+			return nil, fmt.Errorf("GetLeavesByIndex(%v, [%v, %v)): unexpected leaf %v", r.logID, start, end, i)
+		}
+		value, err := DecompressLeafValue(leaf.GetLeafValue())
+		if err != nil {
+			return nil, fmt.Errorf("revision %v: %v", i, err)
+		}
+		smr, err := r.Codec.Unmarshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("revision %v: Unmarshal(): %v", i, err)
+		}
+		smrs[i-start] = smr
+	}
+	for i, smr := range smrs {
+		if smr == nil {
+			return nil, fmt.Errorf("revision %v: leaf not found in log %v", start+int64(i), r.logID)
+		}
+	}
+	return smrs, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+)
+
+func TestEpochReader(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	var want []*trillian.SignedMapRoot
+	for i := int64(0); i < 3; i++ {
+		smr := &trillian.SignedMapRoot{
+			MapId:       mapID,
+			MapRevision: i,
+			RootHash:    []byte{byte(i)},
+		}
+		if err := s.queueLogLeaf(ctx, smr); err != nil {
+			t.Fatalf("queueLogLeaf(%v): %v", i, err)
+		}
+		want = append(want, smr)
+	}
+	r := NewEpochReader(s.tlog, logID)
+
+	for i, smr := range want {
+		got, err := r.ReadEpoch(ctx, int64(i))
+		if err != nil {
+			t.Fatalf("ReadEpoch(%v): %v", i, err)
+		}
+		if !proto.Equal(got, smr) {
+			t.Errorf("ReadEpoch(%v): %v, want %v", i, got, smr)
+		}
+	}
+	var got []*trillian.SignedMapRoot
+	if err := r.ReadEpochs(ctx, 0, 3, func(epoch int64, smr *trillian.SignedMapRoot) error {
+		if epoch != int64(len(got)) {
+			t.Errorf("ReadEpochs(): epoch %v, want %v", epoch, len(got))
+		}
+		got = append(got, smr)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadEpochs(): %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ReadEpochs(): %v roots, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if !proto.Equal(got[i], want[i]) {
+			t.Errorf("ReadEpochs()[%v]: %v, want %v", i, got[i], want[i])
+		}
+	}
+	if _, err := r.ReadEpoch(ctx, 3); err == nil {
+		t.Errorf("ReadEpoch(3): nil error, want an error for a missing leaf")
+	}
+
+	// Epochs are numbered from EpochOffset.
+	r.EpochOffset = 10
+	got = nil
+	if err := r.ReadEpochs(ctx, 11, 13, func(epoch int64, smr *trillian.SignedMapRoot) error {
+		if want := int64(11 + len(got)); epoch != want {
+			t.Errorf("ReadEpochs(): epoch %v, want %v", epoch, want)
+		}
+		got = append(got, smr)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadEpochs(): %v", err)
+	}
+	for i, smr := range got {
+		if !proto.Equal(smr, want[i+1]) {
+			t.Errorf("ReadEpochs()[%v]: %v, want %v", i, smr, want[i+1])
+		}
+	}
+	if smr, err := r.ReadEpoch(ctx, 10); err != nil || !proto.Equal(smr, want[0]) {
+		t.Errorf("ReadEpoch(10): %v, %v, want %v", smr, err, want[0])
+	}
+}
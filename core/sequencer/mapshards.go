@@ -0,0 +1,236 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+)
+
+// ErrMapShardBehind occurs when a map shard is at a revision lower than the
+// one committed for it by shard 0.
+var ErrMapShardBehind = errors.New("sequencer: map shard behind its committed revision")
+
+// ShardRootsIndex is the index of the leaf of shard 0 that holds the roots of
+// shards 1 and up when sequencer.MapShardFunc is set. Since the root of shard 0
+// is signed and added to the log, so are the roots of the other shards.
+var ShardRootsIndex = func() []byte {
+	h := sha256.Sum256([]byte("keytransparency map shard roots"))
+	return h[:]
+}()
+
+// MapShard is a Trillian map holding one shard of the map leaves when
+// sequencer.MapShardFunc is set.
+type MapShard struct {
+	MapID  int64
+	Client trillian.TrillianMapClient
+}
+
+// ShardRoot is the root of a map shard written by an epoch.
+type ShardRoot struct {
+	MapID    int64
+	Revision int64
+	RootHash []byte
+}
+
+// ParseShardRoots returns the roots of shards 1 and up, in order, held by the
+// value of the leaf at ShardRootsIndex.
+func ParseShardRoots(leafValue []byte) ([]ShardRoot, error) {
+	var roots []ShardRoot
+	if err := json.Unmarshal(leafValue, &roots); err != nil {
+		return nil, fmt.Errorf("sequencer: invalid shard roots: %v", err)
+	}
+	return roots, nil
+}
+
+// shardRootsLeaf returns the leaf at ShardRootsIndex holding roots.
+func shardRootsLeaf(roots []ShardRoot) (*trillian.MapLeaf, error) {
+	value, err := json.Marshal(roots)
+	if err != nil {
+		return nil, err
+	}
+	return &trillian.MapLeaf{Index: ShardRootsIndex, LeafValue: value}, nil
+}
+
+// mapShard returns the map holding shard n. Shard 0 is the map passed to New.
+func (s *sequencer) mapShard(n int) (MapShard, error) {
+	switch {
+	case n == 0:
+		return MapShard{MapID: s.mapID, Client: s.tmap}, nil
+	case n > 0 && n <= len(s.MapShards):
+		return s.MapShards[n-1], nil
+	default:
+		return MapShard{}, fmt.Errorf("invalid map shard %v", n)
+	}
+}
+
+// shardOf returns the shard holding the leaf at index.
+func (s *sequencer) shardOf(index []byte) (int, error) {
+	if bytes.Equal(index, ShardRootsIndex) {
+		return 0, fmt.Errorf("index %x is reserved for the shard roots", index)
+	}
+	n := s.MapShardFunc(index)
+	if _, err := s.mapShard(n); err != nil {
+		return 0, fmt.Errorf("MapShardFunc(%x): %v", index, err)
+	}
+	return n, nil
+}
+
+// shardRevisions returns the revision of every shard committed by revision
+// revision of shard 0. The shard writes of an epoch whose shard 0 write
+// failed are not committed, so the other shards may be at later revisions.
+func (s *sequencer) shardRevisions(ctx context.Context, revision int64) ([]int64, error) {
+	revisions := make([]int64, len(s.MapShards)+1)
+	for n := range revisions {
+		// Maps written before the shard roots were recorded.
+		revisions[n] = revision
+	}
+	if revision == 0 {
+		return revisions, nil
+	}
+	resp, err := s.getMapLeaves(ctx, MapShard{MapID: s.mapID, Client: s.tmap}, [][]byte{ShardRootsIndex}, revision)
+	if err != nil {
+		return nil, fmt.Errorf("map shard 0: %v", err)
+	}
+	for _, inc := range resp.GetMapLeafInclusion() {
+		leaf := inc.GetLeaf()
+		if !bytes.Equal(leaf.GetIndex(), ShardRootsIndex) || len(leaf.GetLeafValue()) == 0 {
+			continue
+		}
+		roots, err := ParseShardRoots(leaf.GetLeafValue())
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range roots {
+			if i >= len(s.MapShards) || r.MapID != s.MapShards[i].MapID {
+				return nil, fmt.Errorf("sequencer: shard roots do not match MapShards: %v", roots)
+			}
+			revisions[i+1] = r.Revision
+		}
+	}
+	return revisions, nil
+}
+
+// checkMapShards returns ErrMapShardBehind if a shard is at a revision lower
+// than the one committed for it by revision revision of shard 0. Shards ahead
+// of their committed revision hold the writes of a failed epoch, which are
+// overwritten by the next epoch, since it applies the same mutations to the
+// committed leaves.
+func (s *sequencer) checkMapShards(ctx context.Context, revision int64) error {
+	revisions, err := s.shardRevisions(ctx, revision)
+	if err != nil {
+		return err
+	}
+	for n := 1; n <= len(s.MapShards); n++ {
+		shard, _ := s.mapShard(n)
+		rootResp, err := shard.Client.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+			MapId: shard.MapID,
+		}, s.callOpts...)
+		if err != nil {
+			return fmt.Errorf("GetSignedMapRoot(map shard %v, map %v): %v", n, shard.MapID, err)
+		}
+		latest := rootResp.GetMapRoot().GetMapRevision()
+		switch {
+		case latest < revisions[n]:
+			glog.Errorf("checkMapShards: map shard %v is at revision %v, before committed revision %v", n, latest, revisions[n])
+			return ErrMapShardBehind
+		case latest > revisions[n]:
+			glog.Warningf("checkMapShards: map shard %v is at revision %v, after committed revision %v", n, latest, revisions[n])
+			mapShardDriftCtr.Inc()
+		}
+	}
+	return nil
+}
+
+// getShardedLeaves reads the leaves at indexes from the shards holding them,
+// at the revisions committed by revision revision of shard 0.
+func (s *sequencer) getShardedLeaves(ctx context.Context, indexes [][]byte, revision int64) (*trillian.GetMapLeavesResponse, error) {
+	byShard := make(map[int][][]byte)
+	for _, index := range indexes {
+		n, err := s.shardOf(index)
+		if err != nil {
+			return nil, err
+		}
+		byShard[n] = append(byShard[n], index)
+	}
+	revisions, err := s.shardRevisions(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	resp := &trillian.GetMapLeavesResponse{}
+	for n := 0; n <= len(s.MapShards); n++ {
+		if len(byShard[n]) == 0 {
+			continue
+		}
+		shard, _ := s.mapShard(n)
+		shardResp, err := s.getMapLeaves(ctx, shard, byShard[n], revisions[n])
+		if err != nil {
+			return nil, fmt.Errorf("map shard %v: %v", n, err)
+		}
+		resp.MapLeafInclusion = append(resp.MapLeafInclusion, shardResp.GetMapLeafInclusion()...)
+	}
+	return resp, nil
+}
+
+// shardLeaves partitions leaves by shard.
+func (s *sequencer) shardLeaves(leaves []*trillian.MapLeaf) (map[int][]*trillian.MapLeaf, error) {
+	byShard := make(map[int][]*trillian.MapLeaf)
+	for _, l := range leaves {
+		n, err := s.shardOf(l.GetIndex())
+		if err != nil {
+			return nil, err
+		}
+		byShard[n] = append(byShard[n], l)
+	}
+	return byShard, nil
+}
+
+// setShardLeaves writes the leaves of every shard but shard 0 and returns the
+// leaves to write to shard 0, which commit the epoch along with the new roots
+// of the other shards. Every shard is written, even without leaves.
+func (s *sequencer) setShardLeaves(ctx context.Context, byShard map[int][]*trillian.MapLeaf, seq int64) ([]*trillian.MapLeaf, error) {
+	roots := make([]ShardRoot, 0, len(s.MapShards))
+	for n := 1; n <= len(s.MapShards); n++ {
+		shard, _ := s.mapShard(n)
+		setReq := &trillian.SetMapLeavesRequest{
+			MapId:      shard.MapID,
+			Leaves:     byShard[n],
+			MapperData: s.mapperData(seq),
+		}
+		setLeavesBytesHist.Observe(float64(proto.Size(setReq)))
+		setResp, err := shard.Client.SetLeaves(ctx, setReq, s.callOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("SetLeaves(map shard %v, map %v): %v", n, shard.MapID, err)
+		}
+		roots = append(roots, ShardRoot{
+			MapID:    shard.MapID,
+			Revision: setResp.GetMapRoot().GetMapRevision(),
+			RootHash: setResp.GetMapRoot().GetRootHash(),
+		})
+	}
+	rootsLeaf, err := shardRootsLeaf(roots)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]*trillian.MapLeaf(nil), byShard[0]...), rootsLeaf), nil
+}
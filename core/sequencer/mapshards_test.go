@@ -0,0 +1,157 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestMapShards(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	primary := &recordingMap{fakeMap: newFakeMap()}
+	shard := &recordingMap{fakeMap: newFakeMap()}
+	s.tmap = primary
+	// Keys with an odd last digit go to shard 1.
+	s.MapShardFunc = func(index []byte) int {
+		return int(index[len(index)-1]) % 2
+	}
+	s.MapShards = []MapShard{{MapID: mapID + 1, Client: shard}}
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	// Update a leaf of each shard.
+	updates := signedKV(2, 3)
+	for _, m := range updates {
+		m.KeyValue.Value = append(m.KeyValue.Value, '2')
+	}
+	fakeMutations.write(updates...)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		m    *recordingMap
+		want []string
+	}{
+		{"shard 0", primary, []string{"key_2"}},
+		{"shard 1", shard, []string{"key_1", "key_3"}},
+	} {
+		var got []string
+		for index := range tc.m.leaves {
+			if index != string(ShardRootsIndex) {
+				got = append(got, index)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: leaves %v, want %v", tc.desc, got, tc.want)
+		}
+		for _, index := range tc.m.indexes {
+			if bytes.Equal(index, ShardRootsIndex) {
+				continue
+			}
+			if i := sort.SearchStrings(tc.want, string(index)); i == len(tc.want) || tc.want[i] != string(index) {
+				t.Errorf("%v: index %s was read or written", tc.desc, index)
+			}
+		}
+		// Both shards advance with every epoch.
+		if got, want := len(tc.m.roots), 3; got != want {
+			t.Errorf("%v: len(roots): %v, want %v", tc.desc, got, want)
+		}
+	}
+}
+
+// shardMap records the revisions leaves are read at, and fails SetLeaves
+// while failSet is set.
+type shardMap struct {
+	*fakeMap
+	revisions []int64
+	failSet   bool
+}
+
+func (m *shardMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	m.revisions = append(m.revisions, in.Revision)
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+func (m *shardMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	if m.failSet {
+		return nil, errors.New("SetLeaves failed")
+	}
+	return m.fakeMap.SetLeaves(ctx, in, opts...)
+}
+
+func TestMapShardsPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 2)...)
+	s := newTestSequencer(fakeMutations)
+	primary := &shardMap{fakeMap: newFakeMap()}
+	shard := &shardMap{fakeMap: newFakeMap()}
+	s.tmap = primary
+	s.MapShardFunc = func(index []byte) int {
+		return int(index[len(index)-1]) % 2
+	}
+	s.MapShards = []MapShard{{MapID: mapID + 1, Client: shard}}
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	fakeMutations.write(signedKV(3, 4)...)
+	// Shard 1 is written, but the epoch is not committed.
+	primary.failSet = true
+	if err := s.CreateEpoch(ctx, false); err == nil {
+		t.Fatalf("CreateEpoch(): nil, want error")
+	}
+	if got, want := len(shard.roots), 3; got != want {
+		t.Fatalf("len(shard roots): %v, want %v", got, want)
+	}
+	primary.failSet = false
+	shard.revisions = nil
+	before := counterValue(t, mapShardDriftCtr)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := counterValue(t, mapShardDriftCtr)-before, 1.0; got != want {
+		t.Errorf("map shard drift: %v, want %v", got, want)
+	}
+	// Shard 1 is read at the revision committed by the first epoch.
+	if got, want := shard.revisions, []int64{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("shard GetLeaves revisions: %v, want %v", got, want)
+	}
+	// Shard 0 commits the latest root of shard 1.
+	roots, err := ParseShardRoots(primary.leaves[string(ShardRootsIndex)].GetLeafValue())
+	if err != nil {
+		t.Fatalf("ParseShardRoots(): %v", err)
+	}
+	latest := shard.roots[len(shard.roots)-1]
+	want := []ShardRoot{{MapID: mapID + 1, Revision: latest.MapRevision, RootHash: latest.RootHash}}
+	if !reflect.DeepEqual(roots, want) {
+		t.Errorf("shard roots: %v, want %v", roots, want)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"golang.org/x/net/context"
+)
+
+// checkpoint records that the first count mutations of a batch are all the
+// mutations with sequence numbers up to seq. Mutations are read in pages, so
+// there is a checkpoint after every page.
+type checkpoint struct {
+	count int
+	seq   int64
+}
+
+// partialStop returns a function that reports whether applying a batch of
+// mutations should stop after the first applied mutations, so that the epoch
+// can be committed before the deadline of ctx. Applying only stops at a
+// checkpoint, after at least one page, once less than PartialEpochMargin is
+// left before the deadline. partialStop returns nil if partial epochs are
+// disabled or ctx has no deadline.
+func (s *sequencer) partialStop(ctx context.Context, checkpoints []checkpoint) func(applied int) bool {
+	deadline, ok := ctx.Deadline()
+	if s.PartialEpochMargin <= 0 || !ok || len(checkpoints) < 2 {
+		return nil
+	}
+	clock := s.clock()
+	return func(applied int) bool {
+		if _, ok := checkpointAt(checkpoints, applied); !ok || applied == 0 {
+			return false
+		}
+		return deadline.Sub(clock.Now()) < s.PartialEpochMargin
+	}
+}
+
+// checkpointAt returns the highest sequence number of the checkpoints after
+// the first count mutations.
+func checkpointAt(checkpoints []checkpoint, count int) (int64, bool) {
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		if checkpoints[i].count == count {
+			return checkpoints[i].seq, true
+		}
+	}
+	return 0, false
+}
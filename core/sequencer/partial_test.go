@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian/util"
+	"golang.org/x/net/context"
+)
+
+// tickingMutator advances clock by a second for every mutation.
+type tickingMutator struct {
+	fakeMutator
+	clock *util.FakeTimeSource
+}
+
+func (m tickingMutator) Mutate(ctx context.Context, value, mutation proto.Message) ([]byte, error) {
+	m.clock.Set(m.clock.Now().Add(time.Second))
+	return m.fakeMutator.Mutate(ctx, value, mutation)
+}
+
+func TestPartialEpoch(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		margin      time.Duration
+		pageSize    int32
+		wantLeaves  int
+		wantSeq     int64
+		wantPartial float64
+	}{
+		{margin: 0, pageSize: 3, wantLeaves: 20, wantSeq: 20},
+		// Less than 5s are left after 6 mutations.
+		{margin: 5 * time.Second, pageSize: 3, wantLeaves: 6, wantSeq: 6, wantPartial: 1},
+		{margin: 5 * time.Second, pageSize: 4, wantLeaves: 8, wantSeq: 8, wantPartial: 1},
+		// A single page is always applied entirely.
+		{margin: 5 * time.Second, pageSize: 20, wantLeaves: 20, wantSeq: 20},
+	} {
+		ctx, cancel := context.WithDeadline(context.Background(), now.Add(10*time.Second))
+		clock := util.NewFakeTimeSource(now)
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 20)...)
+		s := newTestSequencer(fakeMutations)
+		s.mutator = tickingMutator{clock: clock}
+		s.Clock = clock
+		s.ReadPageSize = tc.pageSize
+		s.PartialEpochMargin = tc.margin
+		tmap := s.tmap.(*fakeMap)
+
+		before := counterValue(t, partialEpochsCtr)
+		err := s.CreateEpoch(ctx, false)
+		cancel()
+		if err != nil {
+			t.Fatalf("CreateEpoch(margin: %v, page size: %v): %v", tc.margin, tc.pageSize, err)
+		}
+		if got := len(tmap.leaves); got != tc.wantLeaves {
+			t.Errorf("CreateEpoch(margin: %v, page size: %v): %v leaves, want %v", tc.margin, tc.pageSize, got, tc.wantLeaves)
+		}
+		for i := 1; i <= tc.wantLeaves; i++ {
+			if _, ok := tmap.leaves[fmt.Sprintf("key_%v", i)]; !ok {
+				t.Errorf("CreateEpoch(margin: %v, page size: %v): leaf key_%v not written", tc.margin, tc.pageSize, i)
+			}
+		}
+		if got := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(); got != tc.wantSeq {
+			t.Errorf("CreateEpoch(margin: %v, page size: %v): HighestFullyCompletedSeq %v, want %v", tc.margin, tc.pageSize, got, tc.wantSeq)
+		}
+		if got := counterValue(t, partialEpochsCtr) - before; got != tc.wantPartial {
+			t.Errorf("partialEpochsCtr: %v, want %v", got, tc.wantPartial)
+		}
+
+		// The next epoch picks up the remaining mutations.
+		if err := s.CreateEpoch(context.Background(), false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		if got, want := len(tmap.leaves), 20; got != want {
+			t.Errorf("len(leaves) after next epoch: %v, want %v", got, want)
+		}
+		if got, want := tmap.roots[len(tmap.roots)-1].GetMetadata().GetHighestFullyCompletedSeq(), int64(20); got != want {
+			t.Errorf("HighestFullyCompletedSeq after next epoch: %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCheckpointAt(t *testing.T) {
+	checkpoints := []checkpoint{{2, 3}, {2, 5}, {4, 8}}
+	for _, tc := range []struct {
+		count  int
+		want   int64
+		wantOK bool
+	}{
+		{0, 0, false},
+		{2, 5, true},
+		{3, 0, false},
+		{4, 8, true},
+	} {
+		got, ok := checkpointAt(checkpoints, tc.count)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("checkpointAt(%v): %v, %v, want %v, %v", tc.count, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
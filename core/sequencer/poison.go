@@ -0,0 +1,146 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/google/keytransparency/core/transaction"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// Quarantine persistently records poison mutations, i.e. mutations that
+// Mutate failed to apply sequencer.PoisonAttempts times. Mutations are
+// identified by the SHA-256 hash of their serialization, since the sequence
+// number of a single mutation is not known to the signer.
+type Quarantine interface {
+	// Add records the mutation with hash id as poison.
+	Add(txn transaction.Txn, id []byte) error
+	// ReadAll returns the hashes of all the poison mutations.
+	ReadAll(txn transaction.Txn) ([][]byte, error)
+}
+
+// poisonTracker counts the failed attempts to apply every mutation and holds
+// the quarantined ones.
+type poisonTracker struct {
+	mu          sync.Mutex
+	failures    map[string]int
+	quarantined map[string]bool
+}
+
+// mutationID returns the identifier of m in the quarantine.
+func mutationID(m *tpb.SignedKV) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		// Mutations were unmarshaled from storage, so this cannot happen.
+		glog.Errorf("proto.Marshal(): %v", err)
+	}
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+// isPoison returns true if m has been quarantined.
+func (s *sequencer) isPoison(m *tpb.SignedKV) bool {
+	if s.PoisonAttempts <= 0 {
+		return false
+	}
+	s.poison.mu.Lock()
+	defer s.poison.mu.Unlock()
+	return s.poison.quarantined[string(mutationID(m))]
+}
+
+// filterPoison returns the mutations that have not been quarantined.
+func (s *sequencer) filterPoison(mutations []*tpb.SignedKV) []*tpb.SignedKV {
+	if s.PoisonAttempts <= 0 {
+		return mutations
+	}
+	kept := make([]*tpb.SignedKV, 0, len(mutations))
+	for _, m := range mutations {
+		if s.isPoison(m) {
+			glog.V(2).Infof("filterPoison: skipping quarantined mutation for index %x", m.GetKeyValue().GetKey())
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// mutateFailed records that Mutate failed on m, and quarantines m once it
+// has failed PoisonAttempts times.
+func (s *sequencer) mutateFailed(ctx context.Context, m *tpb.SignedKV) {
+	if s.PoisonAttempts <= 0 {
+		return
+	}
+	id := mutationID(m)
+	s.poison.mu.Lock()
+	if s.poison.failures == nil {
+		s.poison.failures = make(map[string]int)
+		s.poison.quarantined = make(map[string]bool)
+	}
+	s.poison.failures[string(id)]++
+	poisoned := s.poison.failures[string(id)] == s.PoisonAttempts
+	if poisoned {
+		delete(s.poison.failures, string(id))
+		s.poison.quarantined[string(id)] = true
+	}
+	s.poison.mu.Unlock()
+	if !poisoned {
+		return
+	}
+	glog.Warningf("Quarantining mutation %x for index %x after %v failed attempts",
+		id, m.GetKeyValue().GetKey(), s.PoisonAttempts)
+	poisonCtr.Inc()
+	if s.Quarantine == nil {
+		return
+	}
+	if err := s.walTxn(ctx, func(txn transaction.Txn) error {
+		return s.Quarantine.Add(txn, id)
+	}); err != nil {
+		// The mutation is still skipped until the signer restarts.
+		glog.Errorf("Quarantine.Add(%x): %v", id, err)
+	}
+}
+
+// loadQuarantine reads the poison mutations recorded in Quarantine, if set.
+func (s *sequencer) loadQuarantine(ctx context.Context) error {
+	if s.Quarantine == nil {
+		return nil
+	}
+	var ids [][]byte
+	if err := s.walTxn(ctx, func(txn transaction.Txn) error {
+		var err error
+		ids, err = s.Quarantine.ReadAll(txn)
+		return err
+	}); err != nil {
+		return fmt.Errorf("Quarantine.ReadAll(): %v", err)
+	}
+	s.poison.mu.Lock()
+	defer s.poison.mu.Unlock()
+	if s.poison.quarantined == nil {
+		s.poison.failures = make(map[string]int)
+		s.poison.quarantined = make(map[string]bool)
+	}
+	for _, id := range ids {
+		s.poison.quarantined[string(id)] = true
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/keytransparency/core/transaction"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// Quarantine fake.
+type fakeQuarantine struct {
+	ids [][]byte
+}
+
+func (q *fakeQuarantine) Add(txn transaction.Txn, id []byte) error {
+	q.ids = append(q.ids, id)
+	return nil
+}
+
+func (q *fakeQuarantine) ReadAll(txn transaction.Txn) ([][]byte, error) {
+	return q.ids, nil
+}
+
+// poisonMutator fails to apply mutations for poison, and counts the attempts.
+type poisonMutator struct {
+	poison []byte
+	calls  *int
+}
+
+func (m poisonMutator) Mutate(ctx context.Context, value, mutation proto.Message) ([]byte, error) {
+	if bytes.Equal(mutation.(*tpb.SignedKV).GetKeyValue().GetKey(), m.poison) {
+		*m.calls++
+		return nil, errors.New("poison")
+	}
+	return fakeMutator{}.Mutate(ctx, value, mutation)
+}
+
+func TestPoisonMutations(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(2, 3)...)
+	quarantine := &fakeQuarantine{}
+	newSigner := func(calls *int) *sequencer {
+		s := newTestSequencer(fakeMutations)
+		s.mutator = poisonMutator{poison: []byte("key_2"), calls: calls}
+		s.PoisonAttempts = 2
+		s.Quarantine = quarantine
+		return s
+	}
+
+	var calls int
+	s := newSigner(&calls)
+	// The mutation is read again by every epoch since none is committed.
+	s.tmap = &shardMap{fakeMap: newFakeMap(), failSet: true}
+	before := counterValue(t, poisonCtr)
+	for i := 0; i < 4; i++ {
+		if err := s.CreateEpoch(ctx, false); err == nil {
+			t.Fatalf("CreateEpoch(): nil, want error")
+		}
+	}
+	if got, want := calls, 2; got != want {
+		t.Errorf("Mutate called %v times on the poison mutation, want %v", got, want)
+	}
+	if got, want := counterValue(t, poisonCtr)-before, 1.0; got != want {
+		t.Errorf("poisonCtr: %v, want %v", got, want)
+	}
+	if got, want := len(quarantine.ids), 1; got != want {
+		t.Fatalf("len(quarantine): %v, want %v", got, want)
+	}
+
+	// The quarantine survives a restart.
+	calls = 0
+	s = newSigner(&calls)
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("Mutate called %v times on the quarantined mutation after a restart, want 0", calls)
+	}
+}
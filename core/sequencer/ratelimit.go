@@ -0,0 +1,82 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited occurs when CreateEpoch is called more than
+// MaxEpochsPerWindow times per EpochRateWindow.
+var ErrRateLimited = errors.New("sequencer: epoch creation rate limited")
+
+// tokenBucket holds up to burst tokens and refills at a rate of burst tokens
+// per window.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last call. b.mu must be held.
+func (b *tokenBucket) refill(now time.Time, burst int, window time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += float64(burst) * elapsed.Seconds() / window.Seconds()
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// available returns true if the bucket holds a token.
+func (b *tokenBucket) available(now time.Time, burst int, window time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now, burst, window)
+	return b.tokens >= 1
+}
+
+// take removes a token from the bucket, if one is available.
+func (b *tokenBucket) take(now time.Time, burst int, window time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now, burst, window)
+	if b.tokens >= 1 {
+		b.tokens--
+	}
+}
+
+// rateLimited returns true if MaxEpochsPerWindow epochs have been created in
+// the last EpochRateWindow.
+func (s *sequencer) rateLimited() bool {
+	if s.MaxEpochsPerWindow <= 0 || s.EpochRateWindow <= 0 {
+		return false
+	}
+	return !s.epochTokens.available(time.Now(), s.MaxEpochsPerWindow, s.EpochRateWindow)
+}
+
+// chargeEpoch counts a new map revision against MaxEpochsPerWindow, whether
+// it was requested by CreateEpoch or scheduled by StartSigning.
+func (s *sequencer) chargeEpoch() {
+	if s.MaxEpochsPerWindow <= 0 || s.EpochRateWindow <= 0 {
+		return
+	}
+	s.epochTokens.take(time.Now(), s.MaxEpochsPerWindow, s.EpochRateWindow)
+}
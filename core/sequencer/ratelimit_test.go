@@ -0,0 +1,115 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1000, 0)
+	window := 10 * time.Second
+	b := &tokenBucket{}
+	for _, tc := range []struct {
+		at   time.Duration
+		take bool
+		want bool
+	}{
+		{at: 0, want: true},
+		{at: 0, take: true, want: true},
+		{at: 0, take: true, want: true},
+		{at: time.Second, want: false},
+		{at: time.Second, take: true, want: false}, // Not below zero.
+		{at: 6 * time.Second, want: true},          // One token refilled.
+		{at: 6 * time.Second, take: true, want: true},
+		{at: 6 * time.Second, want: false}, // Refilled token used.
+		{at: time.Minute, take: true, want: true},
+		{at: time.Minute, take: true, want: true}, // Refill is capped at burst.
+		{at: time.Minute, want: false},
+	} {
+		got := b.available(start.Add(tc.at), 2, window)
+		if got != tc.want {
+			t.Errorf("available(%v): %v, want %v", tc.at, got, tc.want)
+		}
+		if tc.take {
+			b.take(start.Add(tc.at), 2, window)
+		}
+	}
+}
+
+func TestCreateEpochRateLimit(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	s.MaxEpochsPerWindow = 3
+	s.EpochRateWindow = time.Hour
+
+	before := counterValue(t, rateLimitedCtr)
+	var created, limited int
+	for i := 0; i < 10; i++ {
+		switch err := s.CreateEpoch(ctx, true); err {
+		case nil:
+			created++
+		case ErrRateLimited:
+			limited++
+		default:
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	if got, want := created, 3; got != want {
+		t.Errorf("created %v epochs, want %v", got, want)
+	}
+	if got, want := limited, 7; got != want {
+		t.Errorf("rate limited %v calls, want %v", got, want)
+	}
+	if got, want := counterValue(t, rateLimitedCtr)-before, 7.0; got != want {
+		t.Errorf("rateLimitedCtr: %v, want %v", got, want)
+	}
+
+	// Scheduled epochs are not throttled.
+	if err := s.signEpoch(ctx, time.Second, reasonMaxInterval); err != nil {
+		t.Errorf("signEpoch(): %v", err)
+	}
+	if got, want := len(s.tmap.(*fakeMap).roots), 5; got != want {
+		t.Errorf("len(map roots): %v, want %v", got, want)
+	}
+}
+
+func TestRateLimitChargesRevisions(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	s.MaxEpochsPerWindow = 1
+	s.EpochRateWindow = time.Hour
+
+	// Calls that write no map revision are free.
+	for i := 0; i < 3; i++ {
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		if err := s.signEpoch(ctx, time.Second, reasonMutations); err != nil {
+			t.Fatalf("signEpoch(): %v", err)
+		}
+	}
+	fakeMutations.write(signedKV(1, 1)...)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if err := s.CreateEpoch(ctx, false); err != ErrRateLimited {
+		t.Errorf("CreateEpoch(): %v, want %v", err, ErrRateLimited)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/keytransparency/core/mutator/entry"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// RebuildLeaf recomputes the leaf at index by applying the mutations for
+// index epoch by epoch, starting from an empty value, the same way as
+// CreateEpoch. The mutations of the epoch of every map revision are those with
+// sequence numbers between the highest fully completed sequence numbers of the
+// previous map root and of its own. The leaf is not written to the map, so
+// that it can be compared with the leaf actually stored there, and dropped
+// mutations are neither rejected, counted nor quarantined. index is a map
+// index, so if IndexFunc is set, it is compared with the result of IndexFunc
+// on the mutation keys. The returned leaf has no value if no mutation for
+// index was applied.
+func (s *sequencer) RebuildLeaf(ctx context.Context, index []byte) (*trillian.MapLeaf, error) {
+	rootResp, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
+	}
+	leaf := &trillian.MapLeaf{Index: index}
+	var startSequence int64
+	for revision := int64(1); revision <= rootResp.GetMapRoot().GetMapRevision(); revision++ {
+		smrResp, err := s.tmap.GetSignedMapRootByRevision(ctx, &trillian.GetSignedMapRootByRevisionRequest{
+			MapId:    s.mapID,
+			Revision: revision,
+		}, s.callOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("GetSignedMapRootByRevision(%v, %v): %v", s.mapID, revision, err)
+		}
+		endSequence := smrResp.GetMapRoot().GetMetadata().GetHighestFullyCompletedSeq()
+		if endSequence <= startSequence {
+			continue
+		}
+		mutations, _, err := s.rangeMutations(ctx, uint64(startSequence), uint64(endSequence))
+		if err != nil {
+			return nil, fmt.Errorf("rangeMutations(%v, %v): %v", startSequence, endSequence, err)
+		}
+		startSequence = endSequence
+		var indexMutations []*tpb.SignedKV
+		for _, m := range mutations {
+			if mIndex, err := s.leafIndex(m.GetKeyValue().GetKey()); err == nil && bytes.Equal(mIndex, index) {
+				indexMutations = append(indexMutations, m)
+			}
+		}
+		if len(indexMutations) == 0 {
+			continue
+		}
+		if leaf, err = s.foldMutations(ctx, indexMutations, leaf); err != nil {
+			return nil, fmt.Errorf("revision %v: %v", revision, err)
+		}
+		glog.V(2).Infof("RebuildLeaf: applied %v mutations for index %x in revision %v", len(indexMutations), index, revision)
+	}
+	return leaf, nil
+}
+
+// foldMutations applies mutations, all for the index of leaf, to leaf with the
+// same rules as applyMutations, but without any side effect. It returns leaf
+// if none of the mutations changes it.
+func (s *sequencer) foldMutations(ctx context.Context, mutations []*tpb.SignedKV, leaf *trillian.MapLeaf) (*trillian.MapLeaf, error) {
+	oldValue, err := entry.FromLeafValue(leaf.GetLeafValue())
+	if err != nil && !s.RepairCorruptLeaves {
+		return leaf, nil // Every mutation is dropped.
+	} else if err != nil {
+		oldValue = nil
+	}
+	dropped := s.resolveConflicts(mutations)
+	outranked := s.resolvePriorities(mutations, dropped)
+	ret := leaf
+	for _, m := range mutations {
+		if _, ok := outranked[m]; ok || dropped[m] {
+			continue
+		}
+		// Like in applyMutations, every mutation applies to the leaf of
+		// the previous epoch.
+		newValue, err := s.mutator.Mutate(ctx, oldValue, m)
+		if err != nil && ctx.Err() != nil {
+			return nil, fmt.Errorf("Mutate(): %v", err)
+		}
+		if err != nil || (s.MaxLeafValueBytes > 0 && len(newValue) > s.MaxLeafValueBytes) {
+			continue
+		}
+		if bytes.Equal(newValue, leaf.GetLeafValue()) {
+			ret = leaf
+			continue
+		}
+		ret = &trillian.MapLeaf{Index: leaf.GetIndex(), LeafValue: newValue}
+	}
+	return ret, nil
+}
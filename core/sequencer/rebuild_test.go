@@ -0,0 +1,127 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// appendingMutator appends the mutation value to the commitment of the
+// previous value, so that the result depends on every mutation applied.
+type appendingMutator struct{}
+
+func (appendingMutator) Mutate(ctx context.Context, value, mutation proto.Message) ([]byte, error) {
+	var commitment []byte
+	if e := value.(*tpb.Entry); e != nil {
+		commitment = e.GetCommitment()
+	}
+	kv := mutation.(*tpb.SignedKV).GetKeyValue()
+	return proto.Marshal(&tpb.Entry{Commitment: append(commitment, kv.GetValue()...)})
+}
+
+func TestRebuildLeaf(t *testing.T) {
+	ctx := context.Background()
+	index := []byte("key_1")
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	s.mutator = appendingMutator{}
+	tmap := s.tmap.(*fakeMap)
+	// Within an epoch, mutations apply to the leaf of the previous epoch,
+	// so that only the last one counts.
+	for _, epoch := range [][]string{{"a"}, {"b", "c"}, {"d"}} {
+		for _, v := range epoch {
+			fakeMutations.write(&tpb.SignedKV{KeyValue: &tpb.KeyValue{Key: index, Value: []byte(v)}})
+			fakeMutations.write(signedKV(2, 2)...)
+		}
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	// Not sequenced yet.
+	fakeMutations.write(&tpb.SignedKV{KeyValue: &tpb.KeyValue{Key: index, Value: []byte("e")}})
+
+	want, err := proto.Marshal(&tpb.Entry{Commitment: []byte("acd")})
+	if err != nil {
+		t.Fatalf("proto.Marshal(): %v", err)
+	}
+	if got := tmap.leaves[string(index)].GetLeafValue(); !bytes.Equal(got, want) {
+		t.Fatalf("map leaf: %x, want %x", got, want)
+	}
+	roots := len(tmap.roots)
+	for _, tc := range []struct {
+		index []byte
+		want  []byte
+	}{
+		{index, want},
+		{[]byte("missing"), nil},
+	} {
+		leaf, err := s.RebuildLeaf(ctx, tc.index)
+		if err != nil {
+			t.Fatalf("RebuildLeaf(%s): %v", tc.index, err)
+		}
+		if got := leaf.GetIndex(); !bytes.Equal(got, tc.index) {
+			t.Errorf("RebuildLeaf(%s).Index: %s, want %s", tc.index, got, tc.index)
+		}
+		if got := leaf.GetLeafValue(); !bytes.Equal(got, tc.want) {
+			t.Errorf("RebuildLeaf(%s).LeafValue: %x, want %x", tc.index, got, tc.want)
+		}
+	}
+	// Nothing is written to the map.
+	if got := len(tmap.roots); got != roots {
+		t.Errorf("len(roots): %v, want %v", got, roots)
+	}
+}
+
+func TestRebuildLeafNoSideEffects(t *testing.T) {
+	ctx := context.Background()
+	index := []byte("key_1")
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 2)...)
+	s := newTestSequencer(fakeMutations)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	// The mutation now fails to apply, e.g. after a change of the mutator.
+	var calls int
+	quarantine := &fakeQuarantine{}
+	s.mutator = poisonMutator{poison: index, calls: &calls}
+	s.PoisonAttempts = 1
+	s.Quarantine = quarantine
+
+	before := counterValue(t, mutationsByTypeCtr.WithLabelValues(mutationCreate))
+	for _, key := range []string{"key_1", "key_2"} {
+		if _, err := s.RebuildLeaf(ctx, []byte(key)); err != nil {
+			t.Fatalf("RebuildLeaf(%v): %v", key, err)
+		}
+	}
+	if calls == 0 {
+		t.Errorf("Mutate not called on the failing mutation")
+	}
+	if got := s.RecentRejections(); len(got) != 0 {
+		t.Errorf("RecentRejections(): %v, want none", got)
+	}
+	if got := len(quarantine.ids); got != 0 {
+		t.Errorf("len(quarantine): %v, want 0", got)
+	}
+	if got := counterValue(t, mutationsByTypeCtr.WithLabelValues(mutationCreate)) - before; got != 0 {
+		t.Errorf("mutationsByTypeCtr(%v): %v, want 0", mutationCreate, got)
+	}
+}
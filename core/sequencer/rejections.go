@@ -0,0 +1,106 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultRecentRejections is the number of rejections kept when
+// sequencer.RecentRejectionsSize is not set.
+const defaultRecentRejections = 100
+
+// Rejection describes a mutation that was dropped by the signer.
+type Rejection struct {
+	// Index is the map index the mutation was for.
+	Index []byte
+	// Reason explains why the mutation was dropped.
+	Reason string
+	// Time is when the mutation was dropped.
+	Time time.Time
+}
+
+// rejectionLog is a bounded ring buffer of rejections.
+type rejectionLog struct {
+	mu   sync.Mutex
+	buf  []Rejection
+	next int
+	full bool
+}
+
+// add records r, overwriting the oldest rejection once size rejections have
+// been recorded.
+func (l *rejectionLog) add(r Rejection, size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) != size {
+		// The size changed: keep the most recent rejections that still fit.
+		old := l.recent()
+		if len(old) > size {
+			old = old[:size]
+		}
+		l.buf = make([]Rejection, size)
+		for i := range old {
+			l.buf[i] = old[len(old)-1-i]
+		}
+		l.next = len(old) % size
+		l.full = len(old) == size
+	}
+	l.buf[l.next] = r
+	l.next = (l.next + 1) % size
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the recorded rejections, newest first.
+func (l *rejectionLog) list() []Rejection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.recent()
+}
+
+// recent returns the recorded rejections, newest first. l.mu must be held.
+func (l *rejectionLog) recent() []Rejection {
+	n := l.next
+	if l.full {
+		n = len(l.buf)
+	}
+	ret := make([]Rejection, 0, n)
+	for i := 1; i <= n; i++ {
+		ret = append(ret, l.buf[(l.next-i+len(l.buf))%len(l.buf)])
+	}
+	return ret
+}
+
+// reject records that the mutation for index was dropped for reason.
+func (s *sequencer) reject(index []byte, reason string) {
+	size := s.RecentRejectionsSize
+	if size <= 0 {
+		size = defaultRecentRejections
+	}
+	s.rejections.add(Rejection{
+		Index:  index,
+		Reason: reason,
+		Time:   s.clock().Now(),
+	}, size)
+}
+
+// RecentRejections returns the most recently dropped mutations, newest first.
+// At most RecentRejectionsSize rejections are kept.
+func (s *sequencer) RecentRejections() []Rejection {
+	return s.rejections.list()
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+func TestRecentRejections(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	var invalid []*tpb.SignedKV
+	for i := 0; i < 5; i++ {
+		invalid = append(invalid, signedMutation([]byte(fmt.Sprintf("key_%v", i)), []byte("value"), nil))
+	}
+	fakeMutations.write(invalid...)
+	s := newTestSequencer(fakeMutations)
+	s.ValidateMutations = true
+	s.RecentRejectionsSize = 3
+
+	if got := s.RecentRejections(); len(got) != 0 {
+		t.Errorf("RecentRejections(): %v, want none", got)
+	}
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	got := s.RecentRejections()
+	if len(got) != 3 {
+		t.Fatalf("len(RecentRejections()): %v, want 3", len(got))
+	}
+	for i, r := range got {
+		if want := fmt.Sprintf("key_%v", 4-i); string(r.Index) != want {
+			t.Errorf("RecentRejections()[%v].Index: %s, want %v", i, r.Index, want)
+		}
+		if r.Reason != ErrMissingSignature.Error() {
+			t.Errorf("RecentRejections()[%v].Reason: %v, want %v", i, r.Reason, ErrMissingSignature)
+		}
+	}
+}
+
+func TestRejectionLog(t *testing.T) {
+	for _, tc := range []struct {
+		adds []int
+		size int
+		want []int
+	}{
+		{nil, 3, nil},
+		{[]int{1, 2}, 3, []int{2, 1}},
+		{[]int{1, 2, 3}, 3, []int{3, 2, 1}},
+		{[]int{1, 2, 3, 4, 5, 6, 7}, 3, []int{7, 6, 5}},
+	} {
+		var l rejectionLog
+		for _, a := range tc.adds {
+			l.add(Rejection{Reason: fmt.Sprint(a)}, tc.size)
+		}
+		got := l.list()
+		if len(got) != len(tc.want) {
+			t.Errorf("list() after %v: %v, want %v", tc.adds, got, tc.want)
+			continue
+		}
+		for i, r := range got {
+			if want := fmt.Sprint(tc.want[i]); r.Reason != want {
+				t.Errorf("list() after %v: [%v]: %v, want %v", tc.adds, i, r.Reason, want)
+			}
+		}
+	}
+}
+
+func TestRejectionLogResize(t *testing.T) {
+	var l rejectionLog
+	for i := 1; i <= 4; i++ {
+		l.add(Rejection{Reason: fmt.Sprint(i)}, 4)
+	}
+	l.add(Rejection{Reason: "5"}, 2)
+	got := l.list()
+	if len(got) != 2 || got[0].Reason != "5" || got[1].Reason != "4" {
+		t.Errorf("list() after resize: %v, want [5 4]", got)
+	}
+}
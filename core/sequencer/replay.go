@@ -0,0 +1,275 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// ErrMissingConsistencyProof occurs when StrictLogConsistency is set and the
+// log consistency proof of an epoch is missing.
+var ErrMissingConsistencyProof = errors.New("sequencer: missing log consistency proof")
+
+// ReplayEpochs reconstructs the GetMutationsResponse of every epoch from
+// fromEpoch up to the current epoch and sends them to ch, in order. It allows
+// new subscribers to catch up before switching to live updates. Epochs without
+// mutations are sent with their map root and log proofs only.
+func (s *sequencer) ReplayEpochs(ctx context.Context, fromEpoch int64, ch chan *tpb.GetMutationsResponse) error {
+	from := s.revisionOf(fromEpoch)
+	if from < 0 {
+		return fmt.Errorf("invalid epoch %v", fromEpoch)
+	}
+	rootResp, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
+	if err != nil {
+		return fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
+	}
+	latest := rootResp.GetMapRoot().GetMapRevision()
+
+	for revision := from; revision <= latest; revision++ {
+		// Prove that the log holding the map root of the previous epoch
+		// is consistent with the current log root.
+		resp, err := s.epochResponse(ctx, revision, revision)
+		if err != nil {
+			return err
+		}
+		select {
+		case ch <- resp:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// epochResponse rebuilds the GetMutationsResponse of the epoch of a past map
+// revision from the map roots of revision and revision-1, the stored
+// mutations, and the log. The log consistency proof is from firstTreeSize. The
+// response is marked ProofsIncomplete if the map root has not been integrated
+// into the log yet.
+func (s *sequencer) epochResponse(ctx context.Context, revision, firstTreeSize int64) (*tpb.GetMutationsResponse, error) {
+	smr, err := s.mapRoot(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	mutations, err := s.mutationsForEpoch(ctx, revision, smr)
+	if err != nil {
+		return nil, err
+	}
+	logRoot, logConsistency, logInclusion, err := s.logProofs(ctx, firstTreeSize, revision)
+	if err != nil {
+		return nil, err
+	}
+	return &tpb.GetMutationsResponse{
+		Epoch:            s.epochOf(revision),
+		Smr:              smr,
+		LogRoot:          logRoot.GetSignedLogRoot(),
+		LogConsistency:   logConsistency.GetProof().GetHashes(),
+		LogInclusion:     logInclusion.GetProof().GetHashes(),
+		Mutations:        mutations,
+		ProofsIncomplete: logInclusion == nil && !s.MapOnly,
+	}, nil
+}
+
+// MutationsForEpoch reconstructs the mutations applied by a past epoch from the
+// mutation store, using the sequence numbers recorded in the map roots of
+// epoch and epoch-1. Every mutation carries the inclusion proof of its leaf
+// before the epoch, as in the response of CreateEpoch.
+func (s *sequencer) MutationsForEpoch(ctx context.Context, epoch int64) ([]*tpb.Mutation, error) {
+	revision := s.revisionOf(epoch)
+	if revision < 0 {
+		return nil, fmt.Errorf("invalid epoch %v", epoch)
+	}
+	smr, err := s.mapRoot(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+	return s.mutationsForEpoch(ctx, revision, smr)
+}
+
+// mutationsForEpoch returns the mutations of map revision revision, whose map
+// root is smr. Revision 0, the empty map, has none.
+func (s *sequencer) mutationsForEpoch(ctx context.Context, revision int64, smr *trillian.SignedMapRoot) ([]*tpb.Mutation, error) {
+	if revision == 0 {
+		return nil, nil
+	}
+	prev, err := s.mapRoot(ctx, revision-1)
+	if err != nil {
+		return nil, err
+	}
+	return s.epochMutations(ctx, revision-1,
+		prev.GetMetadata().GetHighestFullyCompletedSeq(),
+		smr.GetMetadata().GetHighestFullyCompletedSeq())
+}
+
+// mapRoot returns the signed map root at revision.
+func (s *sequencer) mapRoot(ctx context.Context, revision int64) (*trillian.SignedMapRoot, error) {
+	resp, err := s.tmap.GetSignedMapRootByRevision(ctx, &trillian.GetSignedMapRootByRevisionRequest{
+		MapId:    s.mapID,
+		Revision: revision,
+	}, s.callOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("GetSignedMapRootByRevision(%v, %v): %v", s.mapID, revision, err)
+	}
+	return resp.GetMapRoot(), nil
+}
+
+// epochMutations returns the mutations with sequence numbers in
+// (startSequence, endSequence] along with the inclusion proofs of their leaves
+// at map revision. Mutations without a valid index are skipped, as they were
+// when the epoch was created.
+func (s *sequencer) epochMutations(ctx context.Context, revision, startSequence, endSequence int64) ([]*tpb.Mutation, error) {
+	// Forced epochs without mutations do not advance the sequence number.
+	if endSequence <= startSequence {
+		return nil, nil
+	}
+	mRange, _, err := s.rangeMutations(ctx, uint64(startSequence), uint64(endSequence))
+	if err != nil {
+		return nil, fmt.Errorf("rangeMutations(%v, %v): %v", startSequence, endSequence, err)
+	}
+	if len(mRange) == 0 {
+		return nil, nil
+	}
+	size := s.indexSize()
+	indexes := make([][]byte, 0, len(mRange))
+	mutations := make([]*tpb.Mutation, 0, len(mRange))
+	for _, m := range mRange {
+		index, err := s.leafIndex(m.GetKeyValue().GetKey())
+		if err != nil || len(index) > size {
+			glog.V(2).Infof("epochMutations: skipping mutation for key %x without a valid index", m.GetKeyValue().GetKey())
+			continue
+		}
+		indexes = append(indexes, index)
+		mutations = append(mutations, &tpb.Mutation{
+			Update:   m,
+			Metadata: m.GetMetadata(),
+		})
+	}
+	if len(mutations) == 0 {
+		return nil, nil
+	}
+	getResp, err := s.getLeaves(ctx, indexes, revision)
+	if err != nil {
+		return nil, fmt.Errorf("GetLeaves(%v): %v", revision, err)
+	}
+	proofs := inclusionsByIndex(getResp.GetMapLeafInclusion(), size)
+	for i, m := range mutations {
+		m.Proof = proofs[indexKey(indexes[i], size)]
+	}
+	return mutations, nil
+}
+
+// LogProofs returns the latest log root, a consistency proof from
+// firstTreeSize if it is not zero, and the inclusion proof of the map root of
+// epoch, e.g. to re-verify a past epoch. The inclusion proof is nil if the
+// map root has not been integrated into the log yet. If StrictLogConsistency
+// is set, LogProofs returns ErrMissingConsistencyProof rather than omit the
+// consistency proof of an epoch after the first one.
+func (s *sequencer) LogProofs(ctx context.Context, firstTreeSize, epoch int64) (*trillian.GetLatestSignedLogRootResponse, *trillian.GetConsistencyProofResponse, *trillian.GetInclusionProofResponse, error) {
+	return s.logProofs(ctx, firstTreeSize, s.revisionOf(epoch))
+}
+
+// addLogProofs attaches to resp, the response of a committed epoch, the latest
+// log root and the log proofs of its map root from a log of firstTreeSize
+// leaves. Failing to fetch them does not undo the epoch, so resp is marked
+// ProofsIncomplete instead and the proofs can be fetched later with LogProofs.
+// So is resp if its map root has not been integrated into the log yet.
+func (s *sequencer) addLogProofs(ctx context.Context, resp *tpb.GetMutationsResponse, firstTreeSize int64) {
+	logRoot, logConsistency, logInclusion, err := s.logProofs(ctx, firstTreeSize, resp.GetSmr().GetMapRevision())
+	if err != nil {
+		glog.Errorf("addLogProofs(%v): %v", resp.Epoch, err)
+		proofsIncompleteCtr.Inc()
+		resp.ProofsIncomplete = true
+		return
+	}
+	resp.LogRoot = logRoot.GetSignedLogRoot()
+	resp.LogConsistency = logConsistency.GetProof().GetHashes()
+	resp.LogInclusion = logInclusion.GetProof().GetHashes()
+	if logInclusion == nil && !s.MapOnly {
+		glog.V(2).Infof("addLogProofs(%v): map root not integrated into the log yet", resp.Epoch)
+		proofsIncompleteCtr.Inc()
+		resp.ProofsIncomplete = true
+	}
+}
+
+// logProofs returns the latest log root, a consistency proof from
+// firstTreeSize if it is not zero, and the inclusion proof of the map root of
+// revision, which is at index revision in the log. The inclusion proof is
+// omitted if the map root has not been integrated into the log yet. Nothing is
+// returned in MapOnly mode.
+func (s *sequencer) logProofs(ctx context.Context, firstTreeSize int64, revision int64) (*trillian.GetLatestSignedLogRootResponse, *trillian.GetConsistencyProofResponse, *trillian.GetInclusionProofResponse, error) {
+	if revision < 0 {
+		return nil, nil, nil, fmt.Errorf("invalid revision %v", revision)
+	}
+	if s.MapOnly {
+		return nil, nil, nil, nil
+	}
+	start := time.Now()
+	defer func() { logProofsHist.Observe(time.Since(start).Seconds()) }()
+	logRoot, err := s.tlog.GetLatestSignedLogRoot(ctx,
+		&trillian.GetLatestSignedLogRootRequest{
+			LogId: s.logID,
+		}, s.callOpts...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("GetLatestSignedLogRoot(%v): %v", s.logID, err)
+	}
+	secondTreeSize := logRoot.GetSignedLogRoot().GetTreeSize()
+	// Consistency proof.
+	var logConsistency *trillian.GetConsistencyProofResponse
+	if firstTreeSize == 0 && revision > 1 && s.StrictLogConsistency {
+		return nil, nil, nil, ErrMissingConsistencyProof
+	}
+	if firstTreeSize != 0 {
+		logConsistency, err = s.tlog.GetConsistencyProof(ctx,
+			&trillian.GetConsistencyProofRequest{
+				LogId:          s.logID,
+				FirstTreeSize:  firstTreeSize,
+				SecondTreeSize: secondTreeSize,
+			}, s.callOpts...)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("GetConsistencyProof(%v, %v, %v): %v",
+				s.logID, firstTreeSize, secondTreeSize, err)
+		}
+		// Only a log is consistent with itself without proof.
+		if len(logConsistency.GetProof().GetHashes()) == 0 && firstTreeSize != secondTreeSize && s.StrictLogConsistency {
+			return nil, nil, nil, ErrMissingConsistencyProof
+		}
+	}
+	// Inclusion proof.
+	var logInclusion *trillian.GetInclusionProofResponse
+	if revision < secondTreeSize {
+		logInclusion, err = s.tlog.GetInclusionProof(ctx,
+			&trillian.GetInclusionProofRequest{
+				LogId: s.logID,
+				// SignedMapRoot must be in the log at MapRevision.
+				LeafIndex: revision,
+				TreeSize:  secondTreeSize,
+			}, s.callOpts...)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("GetInclusionProof(%v, %v, %v): %v",
+				s.logID, revision, secondTreeSize, err)
+		}
+	}
+	return logRoot, logConsistency, logInclusion, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+)
+
+// ErrNoMapHasher occurs when ComputeExpectedRoot is called without MapHasher.
+var ErrNoMapHasher = errors.New("sequencer: no map hasher")
+
+// MapHasher computes the node hashes of the sparse Merkle tree of the map.
+// It mirrors the map hashers of Trillian.
+type MapHasher interface {
+	// HashEmpty returns the hash of the empty subtree of height height
+	// whose leaves start at index.
+	HashEmpty(treeID int64, index []byte, height int) []byte
+	// HashLeaf returns the hash of the leaf at index.
+	HashLeaf(treeID int64, index []byte, height int, leaf []byte) []byte
+	// HashChildren returns the hash of the node with children l and r.
+	HashChildren(l, r []byte) []byte
+	// BitLen returns the number of bits of an index.
+	BitLen() int
+}
+
+// ComputeExpectedRoot computes the root hash of the map after writing leaves
+// to revision revision of the map, independently of the map server. The
+// hashes of the subtrees left untouched by leaves are taken from the
+// inclusion proofs of leaves at revision, with the leaf level sibling first
+// and nil for empty subtrees. Leaves with an empty value are deleted. The
+// last leaf wins when several leaves have the same index. Comparing the
+// result with the root returned by the map lets verifiers check that the
+// map applied leaves faithfully.
+func (s *sequencer) ComputeExpectedRoot(ctx context.Context, leaves []*trillian.MapLeaf, revision int64) ([]byte, error) {
+	if s.MapHasher == nil {
+		return nil, ErrNoMapHasher
+	}
+	bitLen := s.MapHasher.BitLen()
+	indexes := make([][]byte, 0, len(leaves))
+	for _, l := range leaves {
+		if got, want := len(l.Index)*8, bitLen; got != want {
+			return nil, fmt.Errorf("sequencer: index %x is %v bits, want %v", l.Index, got, want)
+		}
+		indexes = append(indexes, l.Index)
+	}
+	getResp, err := s.getLeaves(ctx, indexes, revision)
+	if err != nil {
+		return nil, err
+	}
+	r := &rootComputer{
+		hasher:   s.MapHasher,
+		treeID:   s.mapID,
+		bitLen:   bitLen,
+		siblings: make(map[string][]byte),
+	}
+	for _, inc := range getResp.GetMapLeafInclusion() {
+		r.addProof(inc.GetLeaf().GetIndex(), inc.GetInclusion())
+	}
+	root, _ := r.subtree(0, make([]byte, bitLen/8), leaves)
+	return root, nil
+}
+
+// rootComputer computes the hashes of the nodes of a sparse Merkle tree.
+type rootComputer struct {
+	hasher MapHasher
+	treeID int64
+	bitLen int
+	// siblings holds the hashes of the untouched subtrees, by nodeKey.
+	siblings map[string][]byte
+}
+
+// nodeKey identifies the node at depth whose leaves start at prefix.
+func nodeKey(depth int, prefix []byte) string {
+	return fmt.Sprintf("%d/%x", depth, prefix)
+}
+
+// indexBit returns bit i of index, starting from the most significant bit.
+func indexBit(index []byte, i int) byte {
+	return (index[i/8] >> uint(7-i%8)) & 1
+}
+
+// setIndexBit returns a copy of index with bit i set to b.
+func setIndexBit(index []byte, i int, b byte) []byte {
+	c := append([]byte(nil), index...)
+	mask := byte(1) << uint(7-i%8)
+	if b == 0 {
+		c[i/8] &^= mask
+	} else {
+		c[i/8] |= mask
+	}
+	return c
+}
+
+// addProof records the sibling hashes of the inclusion proof of index.
+func (r *rootComputer) addProof(index []byte, proof [][]byte) {
+	if len(index)*8 != r.bitLen {
+		return
+	}
+	for height, hash := range proof {
+		depth := r.bitLen - height
+		if depth < 1 || hash == nil {
+			continue
+		}
+		// The sibling shares the first depth-1 bits of index.
+		prefix := make([]byte, len(index))
+		for i := 0; i < depth-1; i++ {
+			prefix = setIndexBit(prefix, i, indexBit(index, i))
+		}
+		prefix = setIndexBit(prefix, depth-1, 1-indexBit(index, depth-1))
+		r.siblings[nodeKey(depth, prefix)] = hash
+	}
+}
+
+// subtree returns the hash of the node at depth whose leaves start at prefix
+// once leaves, which all belong to it, are written, and whether the node has
+// no leaf left.
+func (r *rootComputer) subtree(depth int, prefix []byte, leaves []*trillian.MapLeaf) ([]byte, bool) {
+	height := r.bitLen - depth
+	if len(leaves) == 0 {
+		if hash, ok := r.siblings[nodeKey(depth, prefix)]; ok {
+			return hash, false
+		}
+		return r.hasher.HashEmpty(r.treeID, prefix, height), true
+	}
+	if height == 0 {
+		leaf := leaves[len(leaves)-1]
+		if len(leaf.LeafValue) == 0 {
+			return r.hasher.HashEmpty(r.treeID, prefix, 0), true
+		}
+		return r.hasher.HashLeaf(r.treeID, leaf.Index, 0, leaf.LeafValue), false
+	}
+	var left, right []*trillian.MapLeaf
+	for _, l := range leaves {
+		if indexBit(l.Index, depth) == 0 {
+			left = append(left, l)
+		} else {
+			right = append(right, l)
+		}
+	}
+	l, lEmpty := r.subtree(depth+1, prefix, left)
+	rh, rEmpty := r.subtree(depth+1, setIndexBit(prefix, depth, 1), right)
+	if lEmpty && rEmpty {
+		// Subtrees emptied by deletions hash like any empty subtree.
+		return r.hasher.HashEmpty(r.treeID, prefix, height), true
+	}
+	return r.hasher.HashChildren(l, rh), false
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// testBitLen is small enough for referenceMap to hash every node.
+const testBitLen = 16
+
+// testHasher is a MapHasher for maps with testBitLen bit indexes.
+type testHasher struct{}
+
+func (testHasher) HashEmpty(treeID int64, index []byte, height int) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(index)
+	h.Write([]byte{byte(height)})
+	return h.Sum(nil)
+}
+
+func (testHasher) HashLeaf(treeID int64, index []byte, height int, leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(index)
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func (testHasher) HashChildren(l, r []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{2})
+	h.Write(l)
+	h.Write(r)
+	return h.Sum(nil)
+}
+
+func (testHasher) BitLen() int { return testBitLen }
+
+// referenceMap hashes every node of the tree, level by level.
+type referenceMap struct {
+	// levels[height][i] is the hash of the i-th node of height height.
+	levels [][][]byte
+	// empty[height][i] is whether that node has no leaf.
+	empty [][]bool
+}
+
+func testIndex(i int) []byte {
+	index := make([]byte, testBitLen/8)
+	binary.BigEndian.PutUint16(index, uint16(i))
+	return index
+}
+
+func newReferenceMap(leaves map[uint16][]byte) *referenceMap {
+	var h testHasher
+	r := &referenceMap{}
+	hashes := make([][]byte, 1<<testBitLen)
+	empty := make([]bool, 1<<testBitLen)
+	for i := range hashes {
+		if v, ok := leaves[uint16(i)]; ok {
+			hashes[i] = h.HashLeaf(mapID, testIndex(i), 0, v)
+		} else {
+			hashes[i] = h.HashEmpty(mapID, testIndex(i), 0)
+			empty[i] = true
+		}
+	}
+	for height := 0; ; height++ {
+		r.levels = append(r.levels, hashes)
+		r.empty = append(r.empty, empty)
+		if len(hashes) == 1 {
+			return r
+		}
+		next := make([][]byte, len(hashes)/2)
+		nextEmpty := make([]bool, len(hashes)/2)
+		for i := range next {
+			nextEmpty[i] = empty[2*i] && empty[2*i+1]
+			if nextEmpty[i] {
+				next[i] = h.HashEmpty(mapID, testIndex(i<<uint(height+1)), height+1)
+			} else {
+				next[i] = h.HashChildren(hashes[2*i], hashes[2*i+1])
+			}
+		}
+		hashes, empty = next, nextEmpty
+	}
+}
+
+func (r *referenceMap) root() []byte { return r.levels[testBitLen][0] }
+
+// proof returns the inclusion proof of index, leaf level first, with nil
+// for empty subtrees.
+func (r *referenceMap) proof(index []byte) [][]byte {
+	pos := int(binary.BigEndian.Uint16(index))
+	proof := make([][]byte, testBitLen)
+	for height := range proof {
+		sibling := (pos >> uint(height)) ^ 1
+		if !r.empty[height][sibling] {
+			proof[height] = r.levels[height][sibling]
+		}
+	}
+	return proof
+}
+
+// proofMap serves the inclusion proofs of a referenceMap.
+type proofMap struct {
+	*fakeMap
+	ref *referenceMap
+}
+
+func (m *proofMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	resp := &trillian.GetMapLeavesResponse{}
+	for _, index := range in.Index {
+		resp.MapLeafInclusion = append(resp.MapLeafInclusion, &trillian.MapLeafInclusion{
+			Leaf:      &trillian.MapLeaf{Index: index},
+			Inclusion: m.ref.proof(index),
+		})
+	}
+	return resp, nil
+}
+
+func TestComputeExpectedRoot(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		desc   string
+		before map[uint16][]byte
+		write  map[uint16][]byte
+		delete []uint16
+	}{
+		{desc: "empty map", write: map[uint16][]byte{0x0001: []byte("a"), 0x8000: []byte("b")}},
+		{desc: "single leaf", write: map[uint16][]byte{0xffff: []byte("a")}},
+		{
+			desc:   "untouched leaves",
+			before: map[uint16][]byte{0x0001: []byte("a"), 0x0002: []byte("b"), 0xf000: []byte("c")},
+			write:  map[uint16][]byte{0x0003: []byte("d"), 0x7777: []byte("e")},
+		},
+		{
+			desc:   "update and delete",
+			before: map[uint16][]byte{0x0001: []byte("a"), 0x0002: []byte("b"), 0xf000: []byte("c")},
+			write:  map[uint16][]byte{0x0001: []byte("new")},
+			delete: []uint16{0xf000},
+		},
+	} {
+		after := make(map[uint16][]byte)
+		for i, v := range tc.before {
+			after[i] = v
+		}
+		var leaves []*trillian.MapLeaf
+		for i, v := range tc.write {
+			after[i] = v
+			leaves = append(leaves, &trillian.MapLeaf{Index: testIndex(int(i)), LeafValue: v})
+		}
+		for _, i := range tc.delete {
+			delete(after, i)
+			leaves = append(leaves, &trillian.MapLeaf{Index: testIndex(int(i))})
+		}
+
+		s := newTestSequencer(&fakeMutation{})
+		s.tmap = &proofMap{fakeMap: newFakeMap(), ref: newReferenceMap(tc.before)}
+		s.MapHasher = testHasher{}
+		got, err := s.ComputeExpectedRoot(ctx, leaves, 1)
+		if err != nil {
+			t.Fatalf("%v: ComputeExpectedRoot(): %v", tc.desc, err)
+		}
+		if want := newReferenceMap(after).root(); !bytes.Equal(got, want) {
+			t.Errorf("%v: ComputeExpectedRoot(): %x, want %x", tc.desc, got, want)
+		}
+	}
+}
+
+func TestComputeExpectedRootErrors(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	leaves := []*trillian.MapLeaf{{Index: []byte{1}, LeafValue: []byte("a")}}
+	if _, err := s.ComputeExpectedRoot(ctx, leaves, 0); err != ErrNoMapHasher {
+		t.Errorf("ComputeExpectedRoot(): %v, want %v", err, ErrNoMapHasher)
+	}
+	s.MapHasher = testHasher{}
+	if _, err := s.ComputeExpectedRoot(ctx, leaves, 0); err == nil {
+		t.Errorf("ComputeExpectedRoot(short index): nil, want error")
+	}
+}
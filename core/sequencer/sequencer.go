@@ -15,10 +15,18 @@
 package sequencer
 
 import (
+	"bytes"
+	"crypto"
+	crand "crypto/rand"
 	"crypto/sha256"
-	"encoding/json"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/keytransparency/core/mutator"
@@ -26,14 +34,111 @@ import (
 	"github.com/google/keytransparency/core/transaction"
 
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/google/trillian"
 	"github.com/google/trillian/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
 )
 
+const (
+	// defaultReadPageSize is the default number of mutations read from
+	// storage per transaction.
+	defaultReadPageSize = 1000
+	// logPollInterval is the interval at which the log is polled while
+	// waiting for a map root to be integrated.
+	logPollInterval = 50 * time.Millisecond
+	// sinceLastEpochInterval is the interval at which StartSigning updates
+	// sinceLastEpochGauge.
+	sinceLastEpochInterval = time.Second
+	// startupRetries is the number of times StartSigning retries reading the
+	// map root when it times out.
+	startupRetries = 3
+	// getLeavesBackoff is the delay before the first retry of a failed
+	// GetLeaves. It doubles with every retry.
+	getLeavesBackoff = 100 * time.Millisecond
+	// maxGetLeavesSplits is the number of times a GetLeaves request that
+	// holds too many indexes is split in halves before giving up.
+	maxGetLeavesSplits = 8
+)
+
+var (
+	// ErrMapLogDesync occurs when the log and the map are in states that
+	// cannot be reconciled, e.g. the map has advanced but the log is empty.
+	ErrMapLogDesync = errors.New("sequencer: map and log are out of sync")
+	// ErrSequenceRegression occurs when the map reports a highest fully
+	// completed sequence number lower than one previously committed.
+	ErrSequenceRegression = errors.New("sequencer: map sequence number went backwards")
+	// ErrRevisionSkipped occurs when HaltOnRevisionSkip is set and the map
+	// has advanced past the last revision written by the signer.
+	ErrRevisionSkipped = errors.New("sequencer: map revision skipped")
+	// ErrMapRootMismatch occurs when the map root read back after an epoch
+	// differs from the one returned by SetLeaves.
+	ErrMapRootMismatch = errors.New("sequencer: map root read back does not match SetLeaves")
+	// ErrRevisionNotAdvanced occurs when SetLeaves returns a map revision
+	// that is not after the one the epoch was computed from.
+	ErrRevisionNotAdvanced = errors.New("sequencer: map revision did not advance")
+	// ErrEpochAborted occurs when an epoch is interrupted by
+	// AbortCurrentEpoch.
+	ErrEpochAborted = errors.New("sequencer: epoch aborted")
+)
+
+// epochReason is the reason why an epoch is created.
+type epochReason int
+
+const (
+	// reasonMutations epochs are created only if there are new mutations.
+	reasonMutations epochReason = iota
+	// reasonMaxInterval epochs are forced by StartSigning because
+	// maxInterval elapsed.
+	reasonMaxInterval
+	// reasonStartup epochs are forced by StartSigning if the map root
+	// cannot be read when it starts, or to warm up an empty map.
+	reasonStartup
+	// reasonForced epochs are forced by callers of CreateEpoch.
+	reasonForced
+)
+
+func (r epochReason) String() string {
+	switch r {
+	case reasonMutations:
+		return "mutations"
+	case reasonMaxInterval:
+		return "max_interval"
+	case reasonStartup:
+		return "startup"
+	case reasonForced:
+		return "forced"
+	default:
+		return "unknown"
+	}
+}
+
+// forced returns true if an epoch must be created even if there are no new
+// mutations.
+func (r epochReason) forced() bool {
+	return r != reasonMutations
+}
+
+// Outcomes of the attempts to create an epoch, as labeled by epochsCtr.
+const (
+	outcomeSuccess      = "success"
+	outcomeEmptySkipped = "empty_skipped"
+	outcomeError        = "error"
+)
+
+// Types of the mutations applied, as labeled by mutationsByTypeCtr.
+const (
+	// mutationCreate mutations are applied to an empty leaf.
+	mutationCreate = "create"
+	// mutationUpdate mutations replace the entry of a leaf.
+	mutationUpdate = "update"
+)
+
 var (
 	mutationsCtr = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "kt_signer_mutations",
@@ -43,27 +148,527 @@ var (
 		Name: "kt_signer_mutations_unique",
 		Help: "Number of mutations the signer has processed post per epoch dedupe.",
 	})
-	mapUpdateHist = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "kt_signer_map_update_seconds",
-		Help:    "Seconds waiting for map update",
+	readMutationsHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_read_mutations_seconds",
+		Help:    "Seconds spent reading mutations",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, math.Inf(1)},
+	})
+	getLeavesHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_get_leaves_seconds",
+		Help:    "Seconds waiting for map leaves",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, math.Inf(1)},
+	})
+	applyHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_apply_seconds",
+		Help:    "Seconds spent applying mutations to leaves",
 		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, math.Inf(1)},
 	})
-	createEpochHist = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "kt_signer_create_epoch_seconds",
-		Help:    "Seconds spent generating epoch",
+	logProofsHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_log_proofs_seconds",
+		Help:    "Seconds spent fetching log proofs",
 		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, math.Inf(1)},
 	})
+	mutateHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_mutate_seconds",
+		Help:    "Seconds spent applying a single mutation with the mutator",
+		Buckets: []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1, math.Inf(1)},
+	})
+	setLeavesBytesHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_setleaves_bytes",
+		Help:    "Size in bytes of SetLeaves requests",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+	mutationsPerEpochHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_mutations_per_epoch",
+		Help:    "Number of mutations processed per epoch",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 18),
+	})
+	partialEpochsCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_partial_epochs",
+		Help: "Number of epochs committed with part of the mutations to meet their deadline.",
+	})
+	tickDriftHist = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kt_signer_tick_drift_seconds",
+		Help:    "Seconds between an epoch tick and the start of its epoch",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, math.Inf(1)},
+	})
+	oversizedCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_mutations_oversized",
+		Help: "Number of mutations the signer has dropped because their leaf value exceeded MaxLeafValueBytes.",
+	})
+	conflictsCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_index_conflicts_total",
+		Help: "Number of indexes that received more than one mutation in the same epoch.",
+	})
+	emptyEpochsCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kt_signer_empty_epochs_total",
+		Help: "Number of epochs created without new mutations, by reason.",
+	}, []string{"reason"})
+	pendingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kt_signer_pending_mutations",
+		Help: "Number of mutations queued but not yet sequenced into the map.",
+	})
+	invalidCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_mutations_invalid",
+		Help: "Number of structurally invalid mutations the signer has dropped.",
+	})
+	noopCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_mutations_noop",
+		Help: "Number of mutations the signer has dropped because they did not change their leaf.",
+	})
+	channelsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kt_signer_registered_channels",
+		Help: "Number of channels registered to receive new epochs.",
+	})
+	droppedEpochsCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_epochs_dropped",
+		Help: "Number of epochs dropped because a subscriber buffer or the unclaimed epochs buffer was full.",
+	})
+	filteredCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_mutations_filtered",
+		Help: "Number of mutations the signer has dropped because of MutationFilter.",
+	})
+	haltedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kt_signer_halted",
+		Help: "Set to 1 if the signer stopped after repeated CreateEpoch failures.",
+	})
+	onEpochErrCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_on_epoch_errors",
+		Help: "Number of errors returned by the OnEpoch hook.",
+	})
+	mirrorBacklogGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kt_signer_mirror_backlog",
+		Help: "Number of map roots waiting to be added to the mirror log.",
+	})
+	logIntegrationTimeoutCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_log_integration_timeouts",
+		Help: "Number of map roots not integrated into the log within LogIntegrationTimeout.",
+	})
+	signMapRootErrCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_sign_map_root_errors",
+		Help: "Number of committed epochs whose map root EpochSigner failed to sign.",
+	})
+	rateLimitedCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_epochs_rate_limited",
+		Help: "Number of calls to CreateEpoch rejected by the rate limit.",
+	})
+	mirrorErrCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_mirror_log_errors",
+		Help: "Number of map roots that could not be added to the mirror log.",
+	})
+	corruptLeavesCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_corrupt_leaves",
+		Help: "Number of mutations applied to map leaves whose value could not be parsed.",
+	})
+	epochsCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kt_signer_epochs_total",
+		Help: "Number of epoch creation attempts, by outcome.",
+	}, []string{"outcome"})
+	mutationsByTypeCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kt_signer_mutations_by_type",
+		Help: "Number of mutations applied to the map, by type.",
+	}, []string{"type"})
+	poisonCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_poison_mutations",
+		Help: "Number of mutations quarantined after repeatedly failing to apply.",
+	})
+	sequenceGapCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_sequence_gap_detected_total",
+		Help: "Number of mutation pages read whose sequence numbers were not contiguous.",
+	})
+	auditErrCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_audit_errors",
+		Help: "Number of audit records that AuditSink failed to write.",
+	})
+	mapShardDriftCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_map_shard_drift",
+		Help: "Number of epochs that found a map shard past the revision committed for it.",
+	})
+	bestEffortEpochsCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_best_effort_epochs",
+		Help: "Number of epochs that applied mutations to empty leaves because the leaves could not be read.",
+	})
+	revisionSkipCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_revision_skip_total",
+		Help: "Number of epochs that found the map past the last revision written by the signer.",
+	})
+	proofsIncompleteCtr = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kt_signer_proofs_incomplete",
+		Help: "Number of committed epochs whose log proofs could not be fetched.",
+	})
+	sinceLastEpochGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kt_signer_seconds_since_last_epoch",
+		Help: "Seconds elapsed since the last epoch was created.",
+	})
 )
 
-func init() {
-	prometheus.MustRegister(mutationsCtr)
-	prometheus.MustRegister(indexCtr)
-	prometheus.MustRegister(mapUpdateHist)
-	prometheus.MustRegister(createEpochHist)
+// collectors are the metrics exported by the signer.
+var collectors = []prometheus.Collector{
+	mutationsCtr,
+	indexCtr,
+	readMutationsHist,
+	getLeavesHist,
+	applyHist,
+	logProofsHist,
+	mutateHist,
+	setLeavesBytesHist,
+	mutationsPerEpochHist,
+	tickDriftHist,
+	partialEpochsCtr,
+	emptyEpochsCtr,
+	pendingGauge,
+	invalidCtr,
+	noopCtr,
+	oversizedCtr,
+	conflictsCtr,
+	channelsGauge,
+	droppedEpochsCtr,
+	filteredCtr,
+	haltedGauge,
+	onEpochErrCtr,
+	rateLimitedCtr,
+	mirrorErrCtr,
+	sinceLastEpochGauge,
+	epochsCtr,
+	mutationsByTypeCtr,
+	poisonCtr,
+	sequenceGapCtr,
+	auditErrCtr,
+	bestEffortEpochsCtr,
+	corruptLeavesCtr,
+	proofsIncompleteCtr,
+	revisionSkipCtr,
+	mapShardDriftCtr,
+	signMapRootErrCtr,
+	logIntegrationTimeoutCtr,
+	mirrorBacklogGauge,
+}
+
+// defaultLatencyBuckets are the default buckets, in seconds, of the
+// histograms configured by HistogramBuckets.
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, math.Inf(1)}
+
+// HistogramBuckets holds the bucket boundaries, in seconds, of the epoch
+// latency histograms. Nil boundaries select defaultLatencyBuckets.
+type HistogramBuckets struct {
+	// MapUpdate are the buckets of kt_signer_map_update_seconds.
+	MapUpdate []float64
+	// CreateEpoch are the buckets of kt_signer_create_epoch_seconds.
+	CreateEpoch []float64
+}
+
+// registerHistogram creates a histogram with opts, defaulting to
+// defaultLatencyBuckets, and registers it with reg. If reg already holds the
+// histogram, e.g. because of another sequencer sharing reg, the existing one is
+// returned and opts.Buckets is ignored.
+func registerHistogram(reg prometheus.Registerer, opts prometheus.HistogramOpts) prometheus.Histogram {
+	if opts.Buckets == nil {
+		opts.Buckets = defaultLatencyBuckets
+	}
+	h := prometheus.NewHistogram(opts)
+	if err := reg.Register(h); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(prometheus.Histogram); ok {
+				return existing
+			}
+		}
+		glog.Errorf("Failed to register %v: %v", opts.Name, err)
+	}
+	return h
+}
+
+// registerMetrics registers collectors with reg. Collectors that are already
+// registered, e.g. by another sequencer sharing reg, are skipped.
+func registerMetrics(reg prometheus.Registerer) error {
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
 }
 
 // Sequencer processes mutations and sends them to the trillian map.
-type Sequencer struct {
+type Sequencer interface {
+	// Initialize adds the empty map root to the log if the log is empty.
+	Initialize(ctx context.Context) error
+	// CreateEpoch applies the mutations received since the last epoch to
+	// the map and adds the new map root to the log.
+	CreateEpoch(ctx context.Context, forceNewEpoch bool) error
+	// StartSigning creates epochs periodically until an error occurs.
+	StartSigning(ctx context.Context, minInterval, maxInterval time.Duration) error
+	// RegisterMutationsChannel subscribes ch to every new epoch, buffering
+	// up to bufferSize epochs.
+	RegisterMutationsChannel(ch chan<- *tpb.GetMutationsResponse, bufferSize int)
+	// UnregisterMutationsChannel unsubscribes ch.
+	UnregisterMutationsChannel(ch chan<- *tpb.GetMutationsResponse)
+	// LastEpoch returns the revision and creation time of the last epoch
+	// created.
+	LastEpoch() (revision int64, at time.Time)
+	// RecentRejections returns the most recently dropped mutations, newest
+	// first.
+	RecentRejections() []Rejection
+	// Saturated reports whether too many mutations are waiting to be
+	// sequenced, so that writers can be throttled.
+	Saturated() bool
+}
+
+// Options configure a sequencer. The zero value of every option disables it
+// or selects its default.
+type Options struct {
+	// EpochTimeout bounds the time spent creating a single epoch. If zero,
+	// the minimum interval between epochs passed to StartSigning is used.
+	EpochTimeout time.Duration
+	// MaxConsecutiveFailures, if positive, is the number of consecutive
+	// CreateEpoch failures after which StartSigning gives up and returns.
+	MaxConsecutiveFailures int
+	// WarmUpEpoch makes StartSigning create an epoch, even without
+	// mutations, before it starts scheduling epochs if the map has no
+	// revision after the initial one, so that clients always find an epoch
+	// to read.
+	WarmUpEpoch bool
+	// MinMutationsPerEpoch, if positive, is the number of pending mutations
+	// required for StartSigning to create an epoch before maxInterval
+	// elapses. Epochs forced by maxInterval are created regardless.
+	MinMutationsPerEpoch int
+	// MaxEpochJitter, if positive, delays every forced epoch by a random
+	// duration in [0, MaxEpochJitter) so that a fleet of signers restarting
+	// at the same time does not create epochs simultaneously.
+	MaxEpochJitter time.Duration
+	// JitterSource is the source of randomness for MaxEpochJitter. If nil, a
+	// source seeded with the current time is used.
+	JitterSource rand.Source
+	// ReadPageSize is the number of mutations read from storage per
+	// transaction. If zero, defaultReadPageSize is used.
+	ReadPageSize int32
+	// Shards, if set, are read instead of the mutation store passed to New.
+	// Their mutations are merged in sequence order, so the shards must
+	// assign sequence numbers from a shared space, e.g. with interleaved
+	// auto-increment offsets. Mutations are only sequenced up to the lowest
+	// of the highest sequence numbers of the shards, so that a lagging shard
+	// cannot write below sequenced mutations. An idle shard therefore holds
+	// back the others until it receives a mutation.
+	Shards []mutator.SequencedMutation
+	// ValidateMutations enables structural validation of mutations before
+	// they are applied. Invalid mutations are dropped, but the sequence
+	// number still advances past them.
+	ValidateMutations bool
+	// IndexSize is the size in bytes of the map indexes. If zero,
+	// defaultIndexSize is used. Mutations with longer indexes are dropped.
+	IndexSize int
+	// IndexFunc, if set, maps the key of every mutation to the index of the
+	// map leaf it updates, e.g. a hash of the key. It must be deterministic,
+	// since it is applied again wherever mutations are matched with map
+	// leaves. Mutations for which it fails are dropped. If nil, keys are
+	// used as indexes.
+	IndexFunc func(key []byte) ([]byte, error)
+	// MaxLeafValueBytes, if positive, is the maximum size of a leaf value.
+	// Mutations producing larger values are dropped, but the sequence
+	// number still advances past them.
+	MaxLeafValueBytes int
+	// MutationFilter, if set, is called on every mutation before it is
+	// applied. Mutations for which it returns false are dropped, e.g. to
+	// deny updates to specific indexes during an incident. The sequence
+	// number still advances past dropped mutations.
+	MutationFilter func(m *tpb.SignedKV) bool
+	// FetchNewLeafProofs makes CreateEpoch fetch the inclusion proof of
+	// every mutated leaf in the new map revision and include it in the
+	// response, so that subscribers can verify individual updates. It costs
+	// an additional GetLeaves call per epoch.
+	FetchNewLeafProofs bool
+	// VerifyMapRoot makes CreateEpoch read back the new map root after it
+	// has been added to the log, and fail with ErrMapRootMismatch if its
+	// revision or root hash differ from the ones returned by SetLeaves. It
+	// costs an additional GetSignedMapRootByRevision call per epoch.
+	VerifyMapRoot bool
+	// RepairCorruptLeaves makes mutations to a leaf whose value cannot be
+	// parsed apply to an empty leaf, replacing the corrupt value. Otherwise
+	// such mutations are dropped and the leaf cannot be updated.
+	RepairCorruptLeaves bool
+	// GetLeavesRetries is the number of times a failed read of the leaves
+	// updated by an epoch is retried, with an exponential backoff, before
+	// the epoch fails.
+	GetLeavesRetries int
+	// BestEffortLeaves makes epochs apply mutations to empty leaves, rather
+	// than fail, when the leaves cannot be read after GetLeavesRetries
+	// retries. This keeps the signer going while the map cannot serve
+	// reads, at the risk of overwriting leaves with entries that do not
+	// chain to their previous value, so it is meant for emergencies only.
+	BestEffortLeaves bool
+	// MapOnly disables the log: map roots are not added to it, Initialize
+	// does not seed it, and epoch responses carry no log proofs. It is meant
+	// for deployments that do not run a verifiable log.
+	MapOnly bool
+	// MapShardFunc, if set, partitions the map leaves by index across the
+	// map passed to New, which holds shard 0, and MapShards, which hold
+	// shards 1 and up. Leaves are read from and written to their shard. Every
+	// shard is written once per epoch, shard 0 last. Shard 0 commits the
+	// epoch: its leaf at ShardRootsIndex holds the new roots of the other
+	// shards, so that signing and logging the root of shard 0 covers them,
+	// and leaves are read at the shard revisions it records. Proofs are
+	// relative to the root of their shard.
+	MapShardFunc func(index []byte) int
+	// MapShards are the maps holding shards 1 and up of MapShardFunc.
+	MapShards []MapShard
+	// ConflictResolver, if set, is called with the mutations of an epoch
+	// that target the same index, in sequence order, and returns the ones to
+	// apply. The others are dropped. The selected mutations are applied in
+	// sequence order, so the last valid one wins. If nil, all the mutations
+	// are applied.
+	ConflictResolver func(index []byte, candidates []*tpb.SignedKV) []*tpb.SignedKV
+	// PriorityFunc, if set, ranks the mutations of an epoch that target the
+	// same index, e.g. to let key revocations win over concurrent updates.
+	// A mutation is dropped if a mutation with a higher priority targets
+	// the same index, regardless of their sequence numbers and even if the
+	// higher priority mutation turns out to be invalid. Among mutations of
+	// equal priority the last valid one wins, as when PriorityFunc is nil.
+	PriorityFunc func(m *tpb.SignedKV) int
+	// MutatorVersion identifies the version of the mutation rules applied
+	// by the mutator. It is included in the response of every epoch so that
+	// verifiers know which rules applied.
+	MutatorVersion string
+	// EpochSigner, if set, signs the map root of every epoch in addition to
+	// the signature of the map server, e.g. with a KMS-backed key.
+	EpochSigner crypto.Signer
+	// EpochSignerOpts are the options passed to EpochSigner. If nil, the
+	// SHA-256 digest of the map root is signed. If the hash function is
+	// zero, e.g. for ed25519 keys, the serialized map root is signed
+	// directly.
+	EpochSignerOpts crypto.SignerOpts
+	// LeafCodec serializes the map roots added to the log. It defaults to
+	// JSONLeafCodec. Clients built on core/client/kt only verify the log
+	// inclusion of map roots serialized with JSONLeafCodec.
+	LeafCodec LeafCodec
+	// CompressLeaves compresses the serialized map roots added to the log.
+	// Readers must pass leaf values through DecompressLeafValue. The map
+	// root signature is computed over the uncompressed serialization.
+	// Clients built on core/client/kt cannot verify the log inclusion of
+	// compressed map roots.
+	CompressLeaves bool
+	// LeafHasher, if set, computes the identity hash of the log leaf holding
+	// smr, serialized into leafValue, instead of leafIdentityHash. The log
+	// deduplicates leaves by identity hash: a leaf whose hash matches an
+	// earlier one is not added, so the log falls behind the map, which
+	// InSync and Initialize then report.
+	LeafHasher func(smr *trillian.SignedMapRoot, leafValue []byte) []byte
+	// WAL, if set, records every epoch before it is written to the map and
+	// until it has been added to the log. Initialize uses it to recover
+	// from a crash between the two writes.
+	WAL WAL
+	// PoisonAttempts, if positive, is the number of times Mutate may fail on
+	// a mutation before the mutation is quarantined. Quarantined mutations
+	// are skipped when mutations are read, so that a mutation that can
+	// never be applied is not retried every epoch.
+	PoisonAttempts int
+	// Quarantine, if set, persists the quarantined mutations so that they
+	// are still skipped after a restart. Initialize loads them.
+	Quarantine Quarantine
+	// LogIntegrationTimeout, if positive, makes CreateEpoch wait up to this
+	// long for the map root to be integrated into the log, rather than
+	// returning as soon as it has been queued. Since the epoch has been
+	// committed by then, timeouts are logged and counted rather than fail
+	// it.
+	LogIntegrationTimeout time.Duration
+	// MirrorLog, if set, is a second log that every map root is also added
+	// to, with MirrorLogID. Proofs are always read from the primary log.
+	MirrorLog   trillian.TrillianLogClient
+	MirrorLogID int64
+	// MirrorBestEffort drops the map roots that could not be added to
+	// MirrorLog. Otherwise, they are retried, in order, before the map root
+	// of the next epoch, until the signer restarts. Failures are logged and
+	// counted, and never fail the epoch.
+	MirrorBestEffort bool
+	// ShutdownGracePeriod, if positive, makes StartSigning create a final
+	// epoch for the pending mutations, within ShutdownGracePeriod, when its
+	// context is done. No epoch is created if there are no mutations.
+	ShutdownGracePeriod time.Duration
+	// DisseminationQueueSize, if positive, makes CreateEpoch queue new
+	// epochs for a background goroutine that delivers them to the
+	// registered channels, so that slow subscribers do not delay the next
+	// epoch. CreateEpoch only blocks when DisseminationQueueSize epochs are
+	// already queued. Use StopDissemination to stop the goroutine.
+	DisseminationQueueSize int
+	// DisseminationStopTimeout is the maximum time StartSigning waits for
+	// the queued epochs to be delivered when its context is done. If zero,
+	// the queued epochs that cannot be delivered right away are discarded.
+	DisseminationStopTimeout time.Duration
+	// UnclaimedEpochs, if positive, is the number of most recent epochs
+	// kept while no channel is registered, e.g. during startup. They are
+	// sent to the first channel registered afterwards.
+	UnclaimedEpochs int
+	// MaxEpochsPerWindow, if positive, is the maximum number of epochs
+	// that may be created per EpochRateWindow. Calls to CreateEpoch in
+	// excess return ErrRateLimited. Epochs scheduled by StartSigning are
+	// never rejected, but count against the limit. Only epochs that write
+	// a map revision count.
+	MaxEpochsPerWindow int
+	// EpochRateWindow is the window of MaxEpochsPerWindow.
+	EpochRateWindow time.Duration
+	// OnEpoch, if set, is called synchronously after each epoch has been
+	// successfully added to the log. Errors returned by OnEpoch are logged
+	// and counted but do not fail the epoch.
+	OnEpoch func(ctx context.Context, resp *tpb.GetMutationsResponse) error
+	// AuditSink, if set, receives an AuditRecord for every epoch that has
+	// been successfully added to the log.
+	AuditSink AuditSink
+	// PartialEpochMargin, if positive, lets CreateEpoch commit the mutations
+	// applied so far, rather than fail, once less than PartialEpochMargin is
+	// left before the deadline of its context. The epoch then ends after a
+	// page of ReadPageSize mutations, and the remaining mutations are left
+	// for the next epoch.
+	PartialEpochMargin time.Duration
+	// PendingHighWater, if positive, makes Saturated report true while more
+	// than PendingHighWater mutations are waiting to be sequenced.
+	PendingHighWater uint64
+	// StrictLogConsistency makes LogProofs fail rather than return no log
+	// consistency proof for an epoch after the first one.
+	StrictLogConsistency bool
+	// AttachLogProofs makes CreateEpoch attach the log root and the log
+	// proofs of the new map root to the response, once the epoch has been
+	// committed. If they cannot be fetched, or the map root has not been
+	// integrated into the log yet, the epoch is not failed: the response is
+	// marked ProofsIncomplete instead.
+	AttachLogProofs bool
+	// EpochOffset is added to map revisions to number epochs, e.g. to carry
+	// on the epoch numbers of a previous deployment. The log still holds the
+	// map root of revision r at index r.
+	EpochOffset int64
+	// HaltOnRevisionSkip makes epochs fail with ErrRevisionSkipped, rather
+	// than only be reported, when the map revision has advanced past the
+	// last one written by the signer, e.g. because another writer updated
+	// the map in between.
+	HaltOnRevisionSkip bool
+	// MapHasher, if set, is the hasher of the map, used by
+	// ComputeExpectedRoot.
+	MapHasher MapHasher
+	// LeafTransform, if set, is applied to the leaves computed by the
+	// mutator right before they are written to the map, e.g. to re-encode
+	// entries during a migration. An error fails the epoch.
+	LeafTransform func(leaves []*trillian.MapLeaf) ([]*trillian.MapLeaf, error)
+	// PreCommit, if set, is called with all the leaves of an epoch, after
+	// LeafTransform, before they are written to the map, e.g. to cap the
+	// number of leaves changed per epoch. An error vetoes the epoch: the map
+	// is left untouched and the mutations are read again by the next epoch.
+	PreCommit func(ctx context.Context, leaves []*trillian.MapLeaf) error
+	// MapperDataFunc, if set, builds the mapper metadata stored with the map
+	// revision that sequences mutations up to seq. HighestFullyCompletedSeq
+	// is always overwritten with seq since the signer resumes from it.
+	MapperDataFunc func(seq int64) *trillian.MapperMetadata
+	// Clock is the time source used by StartSigning to schedule epochs. If
+	// nil, the system clock is used. If Clock also has an
+	// After(time.Duration) <-chan time.Time method, it is used to back off
+	// instead of the system clock.
+	Clock util.TimeSource
+	// RecentRejectionsSize is the number of dropped mutations kept for
+	// RecentRejections. If zero, defaultRecentRejections are kept.
+	RecentRejectionsSize int
+}
+
+// sequencer implements Sequencer on top of a Trillian map and log.
+type sequencer struct {
 	mapID     int64
 	tmap      trillian.TrillianMapClient
 	logID     int64
@@ -71,17 +676,96 @@ type Sequencer struct {
 	mutator   mutator.Mutator
 	mutations mutator.Mutation
 	factory   transaction.Factory
+	callOpts  []grpc.CallOption
+	// Epoch latency histograms, which may have custom buckets.
+	mapUpdateHist   prometheus.Histogram
+	createEpochHist prometheus.Histogram
+	mMux            sync.Mutex
+	mChannels       []*subscriber
+	unclaimed       []*tpb.GetMutationsResponse // Guarded by mMux.
+	mirrorMu        sync.Mutex
+	mirrorBacklog   []*trillian.LogLeaf // Guarded by mirrorMu.
+	lastMu          sync.RWMutex
+	lastRev         int64
+	lastAt          time.Time
+	lastSeq         int64
+	// Mutations up to noOpSeq changed no leaf of map revision noOpRev.
+	noOpRev int64
+	noOpSeq int64
+	pending uint64
+	initMu  sync.Mutex
+	seeded  bool // The empty map root was queued by Initialize.
+
+	epochTokens   tokenBucket
+	rejections    rejectionLog
+	dissemination disseminationQueue
+	poison        poisonTracker
+	aborter       epochAborter
+
+	// Options are set by the constructors.
+	Options
 }
 
-// New creates a new instance of the signer.
+var _ Sequencer = &sequencer{}
+
+// New creates a new instance of the signer, configured with opts. Metrics are
+// registered with reg, or with prometheus.DefaultRegisterer if reg is nil. The
+// optional callOpts are applied to every call to the Trillian map and log,
+// e.g. to raise message size limits or to enable compression for large
+// batches of leaves. New panics if any of the Trillian clients, mutator,
+// mutations or factory is nil; use NewWithValidation to get an error instead.
 func New(mapID int64,
 	tmap trillian.TrillianMapClient,
 	logID int64,
 	tlog trillian.TrillianLogClient,
 	mutator mutator.Mutator,
 	mutations mutator.Mutation,
-	factory transaction.Factory) *Sequencer {
-	return &Sequencer{
+	factory transaction.Factory,
+	reg prometheus.Registerer,
+	opts Options,
+	callOpts ...grpc.CallOption) Sequencer {
+	return newSequencer(mapID, tmap, logID, tlog, mutator, mutations, factory, reg, HistogramBuckets{}, opts, callOpts...)
+}
+
+// NewWithBuckets creates a new instance of the signer, like New, with custom
+// buckets for the epoch latency histograms. The buckets are ignored if
+// another sequencer already registered the histograms with reg.
+func NewWithBuckets(mapID int64,
+	tmap trillian.TrillianMapClient,
+	logID int64,
+	tlog trillian.TrillianLogClient,
+	mutator mutator.Mutator,
+	mutations mutator.Mutation,
+	factory transaction.Factory,
+	reg prometheus.Registerer,
+	buckets HistogramBuckets,
+	opts Options,
+	callOpts ...grpc.CallOption) Sequencer {
+	return newSequencer(mapID, tmap, logID, tlog, mutator, mutations, factory, reg, buckets, opts, callOpts...)
+}
+
+// newSequencer implements NewWithBuckets.
+func newSequencer(mapID int64,
+	tmap trillian.TrillianMapClient,
+	logID int64,
+	tlog trillian.TrillianLogClient,
+	mutator mutator.Mutator,
+	mutations mutator.Mutation,
+	factory transaction.Factory,
+	reg prometheus.Registerer,
+	buckets HistogramBuckets,
+	opts Options,
+	callOpts ...grpc.CallOption) *sequencer {
+	if err := checkDependencies(tmap, tlog, mutator, mutations, factory); err != nil {
+		panic(err)
+	}
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if err := registerMetrics(reg); err != nil {
+		glog.Errorf("Failed to register signer metrics: %v", err)
+	}
+	return &sequencer{
 		mapID:     mapID,
 		tmap:      tmap,
 		logID:     logID,
@@ -89,287 +773,1743 @@ func New(mapID int64,
 		mutator:   mutator,
 		mutations: mutations,
 		factory:   factory,
+		callOpts:  callOpts,
+		mapUpdateHist: registerHistogram(reg, prometheus.HistogramOpts{
+			Name:    "kt_signer_map_update_seconds",
+			Help:    "Seconds waiting for map update",
+			Buckets: buckets.MapUpdate,
+		}),
+		createEpochHist: registerHistogram(reg, prometheus.HistogramOpts{
+			Name:    "kt_signer_create_epoch_seconds",
+			Help:    "Seconds spent generating epoch",
+			Buckets: buckets.CreateEpoch,
+		}),
+		Options: opts,
+	}
+}
+
+// NewWithValidation creates a new instance of the signer, like New, and
+// verifies that none of its dependencies is nil and that the map and the log
+// exist. Use New if the Trillian servers may not be available yet.
+func NewWithValidation(ctx context.Context,
+	mapID int64,
+	tmap trillian.TrillianMapClient,
+	logID int64,
+	tlog trillian.TrillianLogClient,
+	mutator mutator.Mutator,
+	mutations mutator.Mutation,
+	factory transaction.Factory,
+	reg prometheus.Registerer,
+	opts Options,
+	callOpts ...grpc.CallOption) (Sequencer, error) {
+	if err := checkDependencies(tmap, tlog, mutator, mutations, factory); err != nil {
+		return nil, err
+	}
+	s := newSequencer(mapID, tmap, logID, tlog, mutator, mutations, factory, reg, HistogramBuckets{}, opts, callOpts...)
+	if err := s.validateTrees(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkDependencies returns an error naming the first nil dependency of a
+// signer. Catching them here is easier to debug than a nil pointer
+// dereference in the first epoch.
+func checkDependencies(tmap trillian.TrillianMapClient,
+	tlog trillian.TrillianLogClient,
+	mutator mutator.Mutator,
+	mutations mutator.Mutation,
+	factory transaction.Factory) error {
+	for _, dep := range []struct {
+		name    string
+		missing bool
+	}{
+		{"map client", tmap == nil},
+		{"log client", tlog == nil},
+		{"mutator", mutator == nil},
+		{"mutations", mutations == nil},
+		{"transaction factory", factory == nil},
+	} {
+		if dep.missing {
+			return fmt.Errorf("sequencer: nil %v", dep.name)
+		}
+	}
+	return nil
+}
+
+// validateTrees returns an error if the map or the log of s cannot be read.
+func (s *sequencer) validateTrees(ctx context.Context) error {
+	mapRoot, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
+	if err != nil {
+		return fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
+	}
+	if got := mapRoot.GetMapRoot().GetMapId(); got != s.mapID {
+		return fmt.Errorf("GetSignedMapRoot(%v): returned the root of map %v", s.mapID, got)
+	}
+	if _, err := s.tlog.GetLatestSignedLogRoot(ctx, &trillian.GetLatestSignedLogRootRequest{
+		LogId: s.logID,
+	}, s.callOpts...); err != nil {
+		return fmt.Errorf("GetLatestSignedLogRoot(%v): %v", s.logID, err)
+	}
+	return nil
+}
+
+// InSync reports whether the log holds the root of every map revision, along
+// with the log tree size and the map revision. The log holds one leaf per map
+// revision starting at revision 0, so they are in sync when the tree size is
+// the map revision plus one. They may briefly be out of sync while a map root
+// is being integrated into the log.
+func (s *sequencer) InSync(ctx context.Context) (bool, int64, int64, error) {
+	logRoot, err := s.tlog.GetLatestSignedLogRoot(ctx, &trillian.GetLatestSignedLogRootRequest{
+		LogId: s.logID,
+	}, s.callOpts...)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("GetLatestSignedLogRoot(%v): %v", s.logID, err)
+	}
+	mapRoot, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
 	}
+	treeSize := logRoot.GetSignedLogRoot().GetTreeSize()
+	revision := mapRoot.GetMapRoot().GetMapRevision()
+	return treeSize == revision+1, treeSize, revision, nil
 }
 
 // Initialize inserts the object hash of an empty struct into the log if it is empty.
 // This keeps the log leaves in-sync with the map which starts off with an
-// empty log root at map revision 0.
-func (s *Sequencer) Initialize(ctx context.Context) error {
+// empty log root at map revision 0. Initialize returns ErrMapLogDesync if the
+// log and the map are inconsistent. Initialize is safe to call repeatedly and
+// concurrently: the empty map root is queued at most once by a sequencer, even
+// while it has not been integrated into the log yet. In MapOnly mode, the log
+// is left untouched.
+func (s *sequencer) Initialize(ctx context.Context) error {
+	s.initMu.Lock()
+	defer s.initMu.Unlock()
+	if err := s.loadQuarantine(ctx); err != nil {
+		return err
+	}
+	if s.MapOnly {
+		return s.recoverIntent(ctx)
+	}
 	logRoot, err := s.tlog.GetLatestSignedLogRoot(ctx, &trillian.GetLatestSignedLogRootRequest{
 		LogId: s.logID,
-	})
+	}, s.callOpts...)
 	if err != nil {
 		return fmt.Errorf("GetLatestSignedLogRoot(%v): %v", s.logID, err)
 	}
 	mapRoot, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
 		MapId: s.mapID,
-	})
+	}, s.callOpts...)
 	if err != nil {
 		return fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
 	}
 
-	// If the tree is empty and the map is empty,
-	// add the empty map root to the log.
-	if logRoot.GetSignedLogRoot().GetTreeSize() == 0 &&
-		mapRoot.GetMapRoot().GetMapRevision() == 0 {
+	treeSize := logRoot.GetSignedLogRoot().GetTreeSize()
+	revision := mapRoot.GetMapRoot().GetMapRevision()
+	switch {
+	case treeSize == 0 && revision == 0 && s.seeded:
+		glog.V(2).Infof("Initialize: empty map root already queued")
+	case treeSize == 0 && revision == 0:
+		// If the tree is empty and the map is empty,
+		// add the empty map root to the log.
 		glog.Infof("Initializing Trillian Log with empty map root")
-		if err := queueLogLeaf(ctx, s.tlog, s.logID, mapRoot.GetMapRoot()); err != nil {
+		if err := s.queueLogLeaf(ctx, mapRoot.GetMapRoot()); err != nil {
 			return err
 		}
+		s.seeded = true
+	case treeSize == 0, treeSize > revision+1:
+		// The log holds one leaf per map revision, starting at revision 0.
+		// It may lag behind the map while leaves are being integrated, but
+		// it cannot be empty once the map has advanced or be ahead of the map.
+		glog.Errorf("Initialize: log tree size %v is inconsistent with map revision %v", treeSize, revision)
+		return ErrMapLogDesync
 	}
-	return nil
+	return s.recoverIntent(ctx)
 }
 
 // StartSigning advance epochs once per minInterval, if there were mutations,
-// and at least once per maxElapsed minIntervals.
-func (s *Sequencer) StartSigning(ctx context.Context, minInterval, maxInterval time.Duration) {
-	if err := s.Initialize(ctx); err != nil {
+// and at least once per maxElapsed minIntervals. StartSigning returns an error
+// if the log and map are out of sync, or after MaxConsecutiveFailures
+// consecutive CreateEpoch failures. It returns nil once ctx is done.
+func (s *sequencer) StartSigning(ctx context.Context, minInterval, maxInterval time.Duration) error {
+	haltedGauge.Set(0)
+	if err := s.Initialize(ctx); err == ErrMapLogDesync {
+		return err
+	} else if err != nil {
 		glog.Errorf("Initialize() failed: %v", err)
 	}
-	var rootResp *trillian.GetSignedMapRootResponse
-	ctxTime, cancel := context.WithTimeout(ctx, minInterval)
-	rootResp, err := s.tmap.GetSignedMapRoot(ctxTime, &trillian.GetSignedMapRootRequest{
-		MapId: s.mapID,
-	})
+	rootResp, err := s.initialMapRoot(ctx, minInterval)
 	if err != nil {
-		glog.Infof("GetSignedMapRoot failed: %v", err)
-		// Immediately create new epoch and write new sth:
-		if err := s.CreateEpoch(ctxTime, true); err != nil {
-			glog.Errorf("CreateEpoch failed: %v", err)
-		}
-		// Request map head again to get the exact time it was created:
-		rootResp, err = s.tmap.GetSignedMapRoot(ctxTime, &trillian.GetSignedMapRootRequest{
-			MapId: s.mapID,
-		})
-		if err != nil {
-			glog.Errorf("GetSignedMapRoot failed after CreateEpoch: %v", err)
-		}
+		// ctx is done.
+		return nil
+	}
+	if s.WarmUpEpoch && rootResp != nil && rootResp.GetMapRoot().GetMapRevision() < 1 {
+		glog.Infof("StartSigning: creating a warm-up epoch")
+		ctxTime, cancel := s.epochContext(ctx, minInterval)
+		rootResp = s.startupEpoch(ctxTime)
+		cancel()
 	}
-	cancel()
+	clock := s.clock()
 	// Fetch last time from previous map head (as stored in the map server)
-	mapRoot := rootResp.GetMapRoot()
-	last := time.Unix(0, mapRoot.GetTimestampNanos())
+	last := lastEpochTime(rootResp, clock)
+	// Keep the gauge fresh even if epochs stop being created.
+	reportCtx, stopReport := context.WithCancel(ctx)
+	defer stopReport()
+	go s.reportSinceLastEpoch(reportCtx, last, sinceLastEpochInterval)
 	// Start issuing epochs:
-	clock := util.SystemTimeSource{}
-	tc := time.NewTicker(minInterval).C
-	for f := range genEpochTicks(clock, last, tc, minInterval, maxInterval) {
-		ctxTime, cancel := context.WithTimeout(ctx, minInterval)
-		if err := s.CreateEpoch(ctxTime, f); err != nil {
-			glog.Errorf("CreateEpoch failed: %v", err)
+	ticker := time.NewTicker(minInterval)
+	defer ticker.Stop()
+	var failures []string
+	ticks := genEpochTicks(ctx, clock, last, ticker.C, minInterval, maxInterval, s.jitter())
+	for {
+		var tick epochTrigger
+		select {
+		case <-ctx.Done():
+			s.drain()
+			s.stopDissemination()
+			return nil
+		case t, ok := <-ticks:
+			if !ok {
+				continue // ctx is done.
+			}
+			tick = t
+		}
+		glog.V(2).Infof("StartSigning: epoch triggered at %v (%v)", tick.at, tick.reason)
+		// The ticker drops ticks while an epoch is being created, so a
+		// drift above minInterval means epochs are falling behind.
+		drift := clock.Now().Sub(tick.sent)
+		tickDriftHist.Observe(drift.Seconds())
+		if drift > minInterval {
+			glog.Warningf("Epoch started %v after its tick, more than the %v interval", drift, minInterval)
+		}
+		err := s.signEpoch(ctx, minInterval, tick.reason)
+		if err == nil {
+			failures = failures[:0]
+			continue
+		}
+		glog.Errorf("CreateEpoch failed: %v", err)
+		if s.MaxConsecutiveFailures <= 0 {
+			continue
+		}
+		failures = append(failures, err.Error())
+		if len(failures) >= s.MaxConsecutiveFailures {
+			haltedGauge.Set(1)
+			return fmt.Errorf("CreateEpoch failed %v consecutive times: %v",
+				len(failures), strings.Join(failures, "; "))
 		}
-		cancel()
 	}
 }
 
-// genEpochTicks returns and sends to a bool channel every time an epoch should
-// be created. If the boolean value is true this indicates that the epoch should
-// be created regardless of whether mutations exist.
-func genEpochTicks(t util.TimeSource, last time.Time, minTick <-chan time.Time, minElapsed, maxElapsed time.Duration) <-chan bool {
-	enforce := make(chan bool)
-	go func() {
-		// Do not wait for the first minDuration to pass but directly resume from
-		// last
-		if (t.Now().Sub(last) + minElapsed) >= maxElapsed {
-			enforce <- true
-			last = t.Now()
-		}
-
-		for now := range minTick {
-			if (now.Sub(last) + minElapsed) >= maxElapsed {
-				enforce <- true
-				last = now
-			} else {
-				enforce <- false
+// initialMapRoot returns the map root StartSigning schedules epochs from, or
+// nil if it cannot be read. If reading it fails, an epoch is created right
+// away and the map root is read again. Timeouts are instead retried up to
+// startupRetries times, with an exponential backoff starting at minInterval,
+// since creating an epoch would most likely time out as well. initialMapRoot
+// returns an error only if ctx is done while backing off.
+func (s *sequencer) initialMapRoot(ctx context.Context, minInterval time.Duration) (*trillian.GetSignedMapRootResponse, error) {
+	backoff := minInterval
+	for retries := 0; ; retries++ {
+		ctxTime, cancel := s.epochContext(ctx, minInterval)
+		rootResp, err := s.tmap.GetSignedMapRoot(ctxTime, &trillian.GetSignedMapRootRequest{
+			MapId: s.mapID,
+		}, s.callOpts...)
+		if err == nil || !isTimeout(ctxTime, err) {
+			if err != nil {
+				glog.Infof("GetSignedMapRoot failed: %v", err)
+				rootResp = s.startupEpoch(ctxTime)
 			}
+			cancel()
+			return rootResp, nil
 		}
-	}()
-
-	return enforce
+		cancel()
+		if retries >= startupRetries {
+			glog.Errorf("GetSignedMapRoot timed out %v times: %v", retries+1, err)
+			return nil, nil
+		}
+		glog.Warningf("GetSignedMapRoot timed out: %v, retrying in %v", err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.after(backoff):
+		}
+		backoff *= 2
+	}
 }
 
-// newMutations returns a list of mutations to process and highest sequence
-// number returned.
-func (s *Sequencer) newMutations(ctx context.Context, startSequence int64) ([]*tpb.SignedKV, int64, error) {
-	txn, err := s.factory.NewTxn(ctx)
-	if err != nil {
-		return nil, 0, fmt.Errorf("NewDBTxn(): %v", err)
+// startupEpoch creates an epoch when StartSigning starts and returns the new
+// map root, or nil if it cannot be read.
+func (s *sequencer) startupEpoch(ctx context.Context) *trillian.GetSignedMapRootResponse {
+	// Immediately create new epoch and write new sth:
+	if err := s.sequenceEpoch(ctx, reasonStartup); err != nil {
+		glog.Errorf("CreateEpoch failed: %v", err)
 	}
-
-	maxSequence, mutations, err := s.mutations.ReadAll(txn, uint64(startSequence))
+	// Request map head again to get the exact time it was created:
+	rootResp, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
 	if err != nil {
-		if err := txn.Rollback(); err != nil {
-			glog.Errorf("Cannot rollback the transaction: %v", err)
-		}
-		return nil, 0, fmt.Errorf("ReadAll(%v): %v", startSequence, err)
-	}
-
-	if err := txn.Commit(); err != nil {
-		return nil, 0, fmt.Errorf("txn.Commit(): %v", err)
+		glog.Errorf("GetSignedMapRoot failed after CreateEpoch: %v", err)
+		return nil
 	}
-	return mutations, int64(maxSequence), nil
+	return rootResp
 }
 
-// toArray returns the first 32 bytes from b.
-// If b is less than 32 bytes long, the output is zero padded.
-func toArray(b []byte) [32]byte {
-	var i [32]byte
-	copy(i[:], b)
-	return i
+// isTimeout returns true if err was caused by ctx or the server timing out.
+func isTimeout(ctx context.Context, err error) bool {
+	return ctx.Err() == context.DeadlineExceeded || grpc.Code(err) == codes.DeadlineExceeded
 }
 
-// applyMutations takes the set of mutations and applies them to given leafs.
-// Multiple mutations for the same leaf will be applied to provided leaf.
-// The last valid mutation for each leaf is included in the output.
-// Returns a list of map leaves that should be updated.
-func (s *Sequencer) applyMutations(mutations []*tpb.SignedKV, leaves []*trillian.MapLeaf) ([]*trillian.MapLeaf, error) {
-	// Put leaves in a map from index to leaf value.
-	leafMap := make(map[[32]byte]*trillian.MapLeaf)
-	for _, l := range leaves {
-		leafMap[toArray(l.Index)] = l
+// drain creates a final epoch for the pending mutations if ShutdownGracePeriod
+// is set.
+func (s *sequencer) drain() {
+	if s.ShutdownGracePeriod <= 0 {
+		return
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownGracePeriod)
+	defer cancel()
+	glog.Infof("StartSigning: creating a final epoch before shutting down")
+	if err := s.sequenceEpoch(ctx, reasonMutations); err != nil {
+		glog.Errorf("StartSigning: final CreateEpoch failed: %v", err)
+	}
+}
 
-	retMap := make(map[[32]byte]*trillian.MapLeaf)
-	for _, m := range mutations {
-		index := m.GetKeyValue().GetKey()
-		var oldValue *tpb.Entry // If no map leaf was found, oldValue will be nil.
-		if leaf, ok := leafMap[toArray(index)]; ok {
-			var err error
-			oldValue, err = entry.FromLeafValue(leaf.GetLeafValue())
-			if err != nil {
-				glog.Warningf("entry.FromLeafValue(%v): %v", err)
-				continue
-			}
-		}
+// stopDissemination stops the dissemination goroutine after it delivers the
+// queued epochs, waiting for at most DisseminationStopTimeout.
+func (s *sequencer) stopDissemination() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.DisseminationStopTimeout)
+	defer cancel()
+	s.StopDissemination(ctx)
+}
 
-		newValue, err := s.mutator.Mutate(oldValue, m)
+// signEpoch creates an epoch if reason is forced or if at least
+// MinMutationsPerEpoch mutations are pending.
+func (s *sequencer) signEpoch(ctx context.Context, minInterval time.Duration, reason epochReason) error {
+	ctxTime, cancel := s.epochContext(ctx, minInterval)
+	defer cancel()
+	if !reason.forced() && s.MinMutationsPerEpoch > 0 {
+		pending, err := s.PendingMutations(ctxTime)
 		if err != nil {
-			glog.Warningf("Mutate(): %v", err)
-			continue // A bad mutation should not make the whole batch fail.
-		}
-
-		retMap[toArray(index)] = &trillian.MapLeaf{
-			Index:     index,
-			LeafValue: newValue,
+			// Let CreateEpoch decide.
+			glog.Warningf("PendingMutations(): %v", err)
+		} else if pending < uint64(s.MinMutationsPerEpoch) {
+			glog.V(2).Infof("signEpoch: %v pending mutations, deferring epoch", pending)
+			return nil
 		}
 	}
-	// Convert return map back into a list.
-	ret := make([]*trillian.MapLeaf, 0, len(retMap))
-	for _, v := range retMap {
-		ret = append(ret, v)
-	}
-	return ret, nil
+	return s.sequenceEpoch(ctxTime, reason)
 }
 
-// CreateEpoch signs the current map head.
-func (s *Sequencer) CreateEpoch(ctx context.Context, forceNewEpoch bool) error {
-	glog.V(2).Infof("CreateEpoch: starting sequencing run")
-	start := time.Now()
-	// Get the current root.
-	rootResp, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
-		MapId: s.mapID,
-	})
-	if err != nil {
-		return fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
-	}
-	startSequence := rootResp.GetMapRoot().GetMetadata().GetHighestFullyCompletedSeq()
-	revision := rootResp.GetMapRoot().GetMapRevision()
-	glog.V(3).Infof("CreateEpoch: Previous SignedMapRoot: {Revision: %v, HighestFullyCompletedSeq: %v}", revision, startSequence)
-
-	// Get the list of new mutations to process.
-	mutations, seq, err := s.newMutations(ctx, startSequence)
-	if err != nil {
-		return fmt.Errorf("newMutations(%v): %v", startSequence, err)
+// epochContext returns a context bounding the creation of a single epoch to
+// EpochTimeout, or to minInterval if EpochTimeout is not set.
+func (s *sequencer) epochContext(ctx context.Context, minInterval time.Duration) (context.Context, context.CancelFunc) {
+	timeout := s.EpochTimeout
+	if timeout <= 0 {
+		timeout = minInterval
 	}
+	return context.WithTimeout(ctx, timeout)
+}
 
-	// Don't create epoch if there is nothing to process unless explicitly
-	// specified by caller
-	if len(mutations) == 0 && !forceNewEpoch {
-		glog.Infof("CreateEpoch: No mutations found. Exiting.")
+// jitter returns a function producing random delays in [0, MaxEpochJitter),
+// or nil if MaxEpochJitter is not set.
+func (s *sequencer) jitter() func() time.Duration {
+	if s.MaxEpochJitter <= 0 {
 		return nil
 	}
-
-	// Get current leaf values.
-	indexes := make([][]byte, 0, len(mutations))
-	for _, m := range mutations {
-		indexes = append(indexes, m.KeyValue.Key)
+	src := s.JitterSource
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
 	}
-	glog.V(2).Infof("CreateEpoch: len(mutations): %v, len(indexes): %v",
-		len(mutations), len(indexes))
-	getResp, err := s.tmap.GetLeaves(ctx, &trillian.GetMapLeavesRequest{
-		MapId:    s.mapID,
-		Index:    indexes,
-		Revision: -1, // Get the latest version.
-	})
-	if err != nil {
-		return err
+	r := rand.New(src)
+	return func() time.Duration {
+		return time.Duration(r.Int63n(int64(s.MaxEpochJitter)))
 	}
-	glog.V(3).Infof("CreateEpoch: len(GetLeaves.MapLeafInclusions): %v",
-		len(getResp.MapLeafInclusion))
+}
 
-	// Trust the leaf values provided by the map server.
-	// If the map server is run by an untrusted entity, perform inclusion
-	// and signature verification here.
-	leaves := make([]*trillian.MapLeaf, 0, len(getResp.MapLeafInclusion))
-	for _, m := range getResp.MapLeafInclusion {
-		leaves = append(leaves, m.Leaf)
+// clock returns the time source of the signer.
+func (s *sequencer) clock() util.TimeSource {
+	if s.Clock == nil {
+		return util.SystemTimeSource{}
 	}
+	return s.Clock
+}
 
-	// Apply mutations to values.
-	newLeaves, err := s.applyMutations(mutations, leaves)
-	if err != nil {
-		return err
+// timer is implemented by the Clocks that can wait, e.g. fake clocks in
+// tests.
+type timer interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+// after returns a channel that receives the time once d has elapsed on Clock,
+// or on the system clock if Clock cannot wait.
+func (s *sequencer) after(d time.Duration) <-chan time.Time {
+	if t, ok := s.clock().(timer); ok {
+		return t.After(d)
 	}
-	glog.V(2).Infof("CreateEpoch: applied %v mutations to %v leaves",
-		len(mutations), len(leaves))
+	return time.After(d)
+}
 
-	// Set new leaf values.
-	mapSetStart := time.Now()
-	setResp, err := s.tmap.SetLeaves(ctx, &trillian.SetMapLeavesRequest{
-		MapId:  s.mapID,
-		Leaves: newLeaves,
-		MapperData: &trillian.MapperMetadata{
-			HighestFullyCompletedSeq: seq,
-		},
-	})
-	mapSetEnd := time.Now()
+// verifyMapRoot reads back the map root at the revision of want and checks
+// that it matches want.
+func (s *sequencer) verifyMapRoot(ctx context.Context, want *trillian.SignedMapRoot) error {
+	resp, err := s.tmap.GetSignedMapRootByRevision(ctx, &trillian.GetSignedMapRootByRevisionRequest{
+		MapId:    s.mapID,
+		Revision: want.GetMapRevision(),
+	}, s.callOpts...)
 	if err != nil {
-		return err
+		return fmt.Errorf("GetSignedMapRootByRevision(%v): %v", want.GetMapRevision(), err)
 	}
-	revision = setResp.GetMapRoot().GetMapRevision()
-	glog.V(2).Infof("CreateEpoch: SetLeaves:{Revision: %v, HighestFullyCompletedSeq: %v}", revision, seq)
-
-	// Put SignedMapHead in an append only log.
-	if err := queueLogLeaf(ctx, s.tlog, s.logID, setResp.GetMapRoot()); err != nil {
-		// TODO(gdbelvin): If the log doesn't do this, we need to generate an emergency alert.
-		return err
+	got := resp.GetMapRoot()
+	if got.GetMapRevision() != want.GetMapRevision() || !bytes.Equal(got.GetRootHash(), want.GetRootHash()) {
+		glog.Errorf("verifyMapRoot: read back revision %v, root hash %x; SetLeaves returned revision %v, root hash %x",
+			got.GetMapRevision(), got.GetRootHash(), want.GetMapRevision(), want.GetRootHash())
+		return ErrMapRootMismatch
 	}
-
-	mutationsCtr.Add(float64(len(mutations)))
-	indexCtr.Add(float64(len(indexes)))
-	mapUpdateHist.Observe(mapSetEnd.Sub(mapSetStart).Seconds())
-	createEpochHist.Observe(time.Since(start).Seconds())
-	glog.Infof("CreatedEpoch: rev: %v, root: %x", revision, setResp.GetMapRoot().GetRootHash())
 	return nil
 }
 
-// TODO(gdbelvin): Add leaf at a specific index. trillian#423
-func queueLogLeaf(ctx context.Context, tlog trillian.TrillianLogClient, logID int64, smr *trillian.SignedMapRoot) error {
-	smrJSON, err := json.Marshal(smr)
+// mapperData returns the mapper metadata of the map revision that sequences
+// mutations up to seq.
+func (s *sequencer) mapperData(seq int64) *trillian.MapperMetadata {
+	var md *trillian.MapperMetadata
+	if s.MapperDataFunc != nil {
+		md = s.MapperDataFunc(seq)
+	}
+	if md == nil {
+		md = &trillian.MapperMetadata{}
+	}
+	md.HighestFullyCompletedSeq = seq
+	return md
+}
+
+// sinceLastEpoch returns the time elapsed since the last epoch created by s,
+// or since start if s has not created any epoch yet.
+func (s *sequencer) sinceLastEpoch(start time.Time) time.Duration {
+	_, at := s.LastEpoch()
+	if at.IsZero() {
+		at = start
+	}
+	return s.clock().Now().Sub(at)
+}
+
+// reportSinceLastEpoch updates sinceLastEpochGauge every interval until ctx is
+// done.
+func (s *sequencer) reportSinceLastEpoch(ctx context.Context, start time.Time, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sinceLastEpochGauge.Set(s.sinceLastEpoch(start).Seconds())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lastEpochTime returns the time the map root in rootResp was created. If the
+// map root could not be read, the current time is returned instead so that
+// StartSigning does not force an epoch immediately.
+func lastEpochTime(rootResp *trillian.GetSignedMapRootResponse, clock util.TimeSource) time.Time {
+	if rootResp.GetMapRoot() == nil {
+		glog.Warningf("No map root available, assuming the last epoch was created now")
+		return clock.Now()
+	}
+	return time.Unix(0, rootResp.GetMapRoot().GetTimestampNanos())
+}
+
+// epochTrigger is sent by genEpochTicks every time an epoch should be created.
+type epochTrigger struct {
+	// reason is reasonMaxInterval if the epoch should be created regardless
+	// of whether mutations exist, or reasonMutations otherwise.
+	reason epochReason
+	// at is the time the epoch was scheduled for, including jitter.
+	at time.Time
+	// sent is the time on the clock passed to genEpochTicks at which the
+	// trigger was sent. Unlike at, it may be compared with that clock.
+	sent time.Time
+}
+
+// genEpochTicks returns and sends to a channel every time an epoch should be
+// created. If jitter is not nil, every forced epoch is delayed by the
+// duration it returns. The channel is closed once ctx is done or minTick is
+// closed.
+func genEpochTicks(ctx context.Context, t util.TimeSource, last time.Time, minTick <-chan time.Time, minElapsed, maxElapsed time.Duration, jitter func() time.Duration) <-chan epochTrigger {
+	enforce := make(chan epochTrigger)
+	send := func(tick epochTrigger) bool {
+		tick.sent = t.Now()
+		select {
+		case enforce <- tick:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	force := func(at time.Time) bool {
+		if jitter != nil {
+			d := jitter()
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return false
+			}
+			at = at.Add(d)
+		}
+		return send(epochTrigger{reason: reasonMaxInterval, at: at})
+	}
+	go func() {
+		defer close(enforce)
+		// Do not wait for the first minDuration to pass but directly resume from
+		// last
+		if now := t.Now(); (now.Sub(last) + minElapsed) >= maxElapsed {
+			if !force(now) {
+				return
+			}
+			last = t.Now()
+		}
+
+		for {
+			var now time.Time
+			select {
+			case tick, ok := <-minTick:
+				if !ok {
+					return
+				}
+				now = tick
+			case <-ctx.Done():
+				return
+			}
+			if (now.Sub(last) + minElapsed) >= maxElapsed {
+				if !force(now) {
+					return
+				}
+				last = now
+			} else if !send(epochTrigger{reason: reasonMutations, at: now}) {
+				return
+			}
+		}
+	}()
+
+	return enforce
+}
+
+// newMutations returns the mutations with sequence numbers greater than
+// startSequence, a checkpoint after every page, and the highest sequence
+// number read. Mutations are read in pages of ReadPageSize mutations, each in
+// its own transaction. If a page cannot be read, newMutations returns the
+// error along with the mutations of the previous pages and the highest
+// sequence number among them.
+func (s *sequencer) newMutations(ctx context.Context, startSequence int64) ([]*tpb.SignedKV, []checkpoint, int64, error) {
+	if len(s.Shards) > 0 {
+		return s.shardMutations(ctx, startSequence)
+	}
+	pageSize := s.pageSize()
+	var mutations []*tpb.SignedKV
+	var checkpoints []checkpoint
+	seq := startSequence
+	for {
+		maxSequence, page, err := s.readPage(ctx, seq, pageSize)
+		if err != nil {
+			return mutations, checkpoints, seq, err
+		}
+		full := len(page) == int(pageSize)
+		// An empty page reports a zero sequence number. Never move the
+		// highest fully completed sequence number backwards.
+		if len(page) > 0 {
+			checkSequenceGap(seq, int64(maxSequence), len(page))
+			seq = int64(maxSequence)
+			mutations = append(mutations, s.filterMutations(page)...)
+			checkpoints = append(checkpoints, checkpoint{count: len(mutations), seq: seq})
+		}
+		if !full {
+			return mutations, checkpoints, seq, nil
+		}
+	}
+}
+
+// checkSequenceGap reports a gap if fewer than one mutation per sequence
+// number were read in (startSequence, maxSequence], which may reveal a bug in
+// the mutation store.
+func checkSequenceGap(startSequence, maxSequence int64, count int) {
+	glog.V(2).Infof("newMutations: read %v mutations in (%v, %v]", count, startSequence, maxSequence)
+	if want := maxSequence - startSequence; int64(count) != want {
+		glog.Warningf("newMutations: sequence gap: read %v mutations in (%v, %v], want %v",
+			count, startSequence, maxSequence, want)
+		sequenceGapCtr.Inc()
+	}
+}
+
+// pageSize returns ReadPageSize, or defaultReadPageSize if it is not set.
+func (s *sequencer) pageSize() int32 {
+	if s.ReadPageSize <= 0 {
+		return defaultReadPageSize
+	}
+	return s.ReadPageSize
+}
+
+// readPage returns up to count mutations with sequence numbers greater than
+// startSequence and the highest sequence number read.
+func (s *sequencer) readPage(ctx context.Context, startSequence int64, count int32) (uint64, []*tpb.SignedKV, error) {
+	return s.readRange(ctx, uint64(startSequence), math.MaxInt64, count)
+}
+
+// readRange returns up to count mutations with sequence numbers in
+// (startSequence, endSequence] and the highest sequence number read. If the
+// mutation store implements mutator.SequencedMutation, the mutations are
+// sorted by sequence number rather than kept in the order of the store.
+func (s *sequencer) readRange(ctx context.Context, startSequence, endSequence uint64, count int32) (uint64, []*tpb.SignedKV, error) {
+	if sm, ok := s.mutations.(mutator.SequencedMutation); ok {
+		queued, err := s.readShard(ctx, sm, startSequence, endSequence, count)
+		if err != nil {
+			return 0, nil, err
+		}
+		var maxSequence uint64
+		for _, q := range queued {
+			if q.Sequence > maxSequence {
+				maxSequence = q.Sequence
+			}
+		}
+		return maxSequence, mergeQueued(queued, int64(maxSequence)), nil
+	}
+	txn, err := s.factory.NewTxn(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("NewDBTxn(): %v", err)
+	}
+
+	maxSequence, mutations, err := s.mutations.ReadRange(txn, startSequence, endSequence, count)
+	if err != nil {
+		if err := txn.Rollback(); err != nil {
+			glog.Errorf("Cannot rollback the transaction: %v", err)
+		}
+		return 0, nil, fmt.Errorf("ReadRange(%v, %v, %v): %v", startSequence, endSequence, count, err)
+	}
+
+	if err := txn.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("txn.Commit(): %v", err)
+	}
+	return maxSequence, mutations, nil
+}
+
+// PendingMutations returns the number of mutations that have been queued but
+// not yet sequenced into the map.
+func (s *sequencer) PendingMutations(ctx context.Context) (uint64, error) {
+	rootResp, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
+	if err != nil {
+		return 0, fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
+	}
+	return s.pendingMutations(ctx, rootResp.GetMapRoot().GetMetadata().GetHighestFullyCompletedSeq())
+}
+
+// pendingMutations returns the number of mutations with a sequence number
+// higher than completedSequence.
+func (s *sequencer) pendingMutations(ctx context.Context, completedSequence int64) (uint64, error) {
+	if len(s.Shards) > 0 {
+		highest, err := s.shardsHighestSequence(ctx)
+		if err != nil || highest < uint64(completedSequence) {
+			return 0, err
+		}
+		return highest - uint64(completedSequence), nil
+	}
+	txn, err := s.factory.NewTxn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("NewDBTxn(): %v", err)
+	}
+	highest, err := s.mutations.HighestSequence(txn)
+	if err != nil {
+		if err := txn.Rollback(); err != nil {
+			glog.Errorf("Cannot rollback the transaction: %v", err)
+		}
+		return 0, fmt.Errorf("HighestSequence(): %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("txn.Commit(): %v", err)
+	}
+	if highest < uint64(completedSequence) {
+		return 0, nil
+	}
+	return highest - uint64(completedSequence), nil
+}
+
+// rangeMutations returns the list of mutations with sequence numbers in
+// (startSequence, endSequence] and the highest sequence number returned.
+func (s *sequencer) rangeMutations(ctx context.Context, startSequence, endSequence uint64) ([]*tpb.SignedKV, int64, error) {
+	if len(s.Shards) > 0 {
+		return s.shardRange(ctx, startSequence, endSequence)
+	}
+	maxSequence, mutations, err := s.readRange(ctx, startSequence, endSequence, math.MaxInt32)
+	if err != nil {
+		return nil, 0, err
+	}
+	mutations = s.filterMutations(mutations)
+	return mutations, int64(maxSequence), nil
+}
+
+// indexSize returns IndexSize, or defaultIndexSize if it is not set.
+func (s *sequencer) indexSize() int {
+	if s.IndexSize <= 0 {
+		return defaultIndexSize
+	}
+	return s.IndexSize
+}
+
+// indexKey returns the key of index in Go maps. If b is less than size bytes
+// long, it is zero padded. Longer indexes are kept whole rather than truncated
+// so that they cannot collide with valid indexes.
+func indexKey(b []byte, size int) string {
+	if len(b) >= size {
+		return string(b)
+	}
+	i := make([]byte, size)
+	copy(i, b)
+	return string(i)
+}
+
+// inclusionsByIndex returns a map from leaf index to inclusion.
+func inclusionsByIndex(inclusions []*trillian.MapLeafInclusion, size int) map[string]*trillian.MapLeafInclusion {
+	ret := make(map[string]*trillian.MapLeafInclusion)
+	for _, p := range inclusions {
+		if p.GetLeaf() == nil {
+			continue
+		}
+		ret[indexKey(p.GetLeaf().GetIndex(), size)] = p
+	}
+	return ret
+}
+
+// leafIndex returns the index of the map leaf that a mutation for key
+// updates.
+func (s *sequencer) leafIndex(key []byte) ([]byte, error) {
+	if s.IndexFunc == nil {
+		return key, nil
+	}
+	return s.IndexFunc(key)
+}
+
+// leafIndexes returns the leaf index of every mutation, in order.
+func (s *sequencer) leafIndexes(mutations []*tpb.SignedKV) ([][]byte, error) {
+	indexes := make([][]byte, 0, len(mutations))
+	for _, m := range mutations {
+		index, err := s.leafIndex(m.GetKeyValue().GetKey())
+		if err != nil {
+			return nil, fmt.Errorf("IndexFunc(%x): %v", m.GetKeyValue().GetKey(), err)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// resolveConflicts returns the mutations that ConflictResolver did not select
+// among the mutations for the same index. It returns nil if ConflictResolver
+// is not set.
+func (s *sequencer) resolveConflicts(mutations []*tpb.SignedKV) map[*tpb.SignedKV]bool {
+	if s.ConflictResolver == nil {
+		return nil
+	}
+	size := s.indexSize()
+	byIndex := make(map[string][]*tpb.SignedKV)
+	indexes := make(map[string][]byte)
+	for _, m := range mutations {
+		index, err := s.leafIndex(m.GetKeyValue().GetKey())
+		if err != nil {
+			continue // The mutation is rejected when applied.
+		}
+		key := indexKey(index, size)
+		byIndex[key] = append(byIndex[key], m)
+		indexes[key] = index
+	}
+	dropped := make(map[*tpb.SignedKV]bool)
+	for key, candidates := range byIndex {
+		if len(candidates) < 2 {
+			continue
+		}
+		selected := make(map[*tpb.SignedKV]bool)
+		for _, m := range s.ConflictResolver(indexes[key], candidates) {
+			selected[m] = true
+		}
+		for _, m := range candidates {
+			if !selected[m] {
+				dropped[m] = true
+			}
+		}
+	}
+	return dropped
+}
+
+// resolvePriorities returns the mutations that are outranked by a mutation
+// with a higher PriorityFunc for the same index, mapped to the highest
+// priority for that index. Mutations in skip are ignored. It returns nil if
+// PriorityFunc is not set.
+func (s *sequencer) resolvePriorities(mutations []*tpb.SignedKV, skip map[*tpb.SignedKV]bool) map[*tpb.SignedKV]int {
+	if s.PriorityFunc == nil {
+		return nil
+	}
+	size := s.indexSize()
+	highest := make(map[string]int)
+	keys := make(map[*tpb.SignedKV]string)
+	for _, m := range mutations {
+		if skip[m] {
+			continue
+		}
+		index, err := s.leafIndex(m.GetKeyValue().GetKey())
+		if err != nil {
+			continue // The mutation is rejected when applied.
+		}
+		key := indexKey(index, size)
+		keys[m] = key
+		if p, ok := highest[key]; !ok || s.PriorityFunc(m) > p {
+			highest[key] = s.PriorityFunc(m)
+		}
+	}
+	outranked := make(map[*tpb.SignedKV]int)
+	for m, key := range keys {
+		if s.PriorityFunc(m) < highest[key] {
+			outranked[m] = highest[key]
+		}
+	}
+	return outranked
+}
+
+// indexConflicts returns the indexes that occur more than once in indexes, in
+// index order.
+func indexConflicts(indexes [][]byte, size int) [][]byte {
+	counts := make(map[string]int)
+	var conflicts [][]byte
+	for _, index := range indexes {
+		counts[indexKey(index, size)]++
+		if counts[indexKey(index, size)] == 2 {
+			conflicts = append(conflicts, index)
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return bytes.Compare(conflicts[i], conflicts[j]) < 0
+	})
+	return conflicts
+}
+
+// applyMutations takes the set of mutations and applies them to given leafs.
+// Mutations that leave the value of their leaf unchanged are dropped, and
+// revert the earlier mutations of the epoch for that leaf.
+// Multiple mutations for the same leaf will be applied to provided leaf.
+// The last valid mutation for each leaf is included in the output, so
+// mutations must be in ascending sequence order, as returned by readRange. If
+// ConflictResolver is set, it selects which of the mutations for the same
+// leaf are applied. If PriorityFunc is set, only the mutations of the highest
+// priority for each leaf are applied.
+// Returns a list of map leaves that should be updated.
+func (s *sequencer) applyMutations(ctx context.Context, mutations []*tpb.SignedKV, leaves []*trillian.MapLeaf) ([]*trillian.MapLeaf, error) {
+	ret, _, err := s.applyMutationsUntil(ctx, mutations, leaves, nil)
+	return ret, err
+}
+
+// applyMutationsUntil applies mutations like applyMutations. If stop is not
+// nil, it is called before applying every mutation with the number of
+// mutations applied so far, and the remaining mutations are not applied once
+// it returns true. applyMutationsUntil also returns the number of mutations
+// applied.
+func (s *sequencer) applyMutationsUntil(ctx context.Context, mutations []*tpb.SignedKV, leaves []*trillian.MapLeaf, stop func(applied int) bool) ([]*trillian.MapLeaf, int, error) {
+	// Put leaves in a map from index to leaf value.
+	size := s.indexSize()
+	leafMap := make(map[string]*trillian.MapLeaf)
+	for _, l := range leaves {
+		leafMap[indexKey(l.Index, size)] = l
+	}
+
+	dropped := s.resolveConflicts(mutations)
+	outranked := s.resolvePriorities(mutations, dropped)
+	retMap := make(map[string]*trillian.MapLeaf)
+	applied := len(mutations)
+	for i, m := range mutations {
+		if stop != nil && stop(i) {
+			applied = i
+			break
+		}
+		index, err := s.leafIndex(m.GetKeyValue().GetKey())
+		if err != nil {
+			glog.Warningf("IndexFunc(%x): %v", m.GetKeyValue().GetKey(), err)
+			s.reject(m.GetKeyValue().GetKey(), err.Error())
+			continue
+		}
+		if dropped[m] {
+			glog.V(2).Infof("applyMutations: dropping mutation for index %x not selected by ConflictResolver", index)
+			s.reject(index, "not selected by ConflictResolver")
+			continue
+		}
+		if p, ok := outranked[m]; ok {
+			priority := s.PriorityFunc(m)
+			glog.V(2).Infof("applyMutations: dropping mutation for index %x with priority %v lower than %v", index, priority, p)
+			s.reject(index, fmt.Sprintf("priority %v lower than %v", priority, p))
+			continue
+		}
+		var oldValue *tpb.Entry // If no map leaf was found, oldValue will be nil.
+		leaf, ok := leafMap[indexKey(index, size)]
+		if ok {
+			var err error
+			oldValue, err = entry.FromLeafValue(leaf.GetLeafValue())
+			if err != nil && s.RepairCorruptLeaves {
+				glog.Errorf("applyMutations: corrupt leaf at index %x, applying mutation to an empty leaf: %v", index, err)
+				corruptLeavesCtr.Inc()
+				oldValue = nil
+			} else if err != nil {
+				glog.Warningf("entry.FromLeafValue(%v): %v", leaf.GetLeafValue(), err)
+				corruptLeavesCtr.Inc()
+				s.reject(index, err.Error())
+				continue
+			}
+		}
+
+		mutateStart := time.Now()
+		newValue, err := s.mutator.Mutate(ctx, oldValue, m)
+		mutateHist.Observe(time.Since(mutateStart).Seconds())
+		if err != nil && ctx.Err() != nil {
+			// The epoch ran out of time rather than the mutation being bad.
+			return nil, 0, fmt.Errorf("Mutate(): %v", err)
+		}
+		if err != nil {
+			glog.Warningf("Mutate(): %v", err)
+			s.mutateFailed(ctx, m)
+			s.reject(index, err.Error())
+			continue // A bad mutation should not make the whole batch fail.
+		}
+		if s.MaxLeafValueBytes > 0 && len(newValue) > s.MaxLeafValueBytes {
+			glog.Warningf("applyMutations: dropping mutation for index %x: leaf value of %v bytes exceeds %v",
+				index, len(newValue), s.MaxLeafValueBytes)
+			oversizedCtr.Inc()
+			s.reject(index, fmt.Sprintf("leaf value of %v bytes exceeds %v", len(newValue), s.MaxLeafValueBytes))
+			continue
+		}
+		if ok && bytes.Equal(newValue, leaf.GetLeafValue()) {
+			glog.V(2).Infof("applyMutations: dropping no-op mutation for index %x", index)
+			noopCtr.Inc()
+			// The leaf keeps its value even if an earlier mutation changed it.
+			delete(retMap, indexKey(index, size))
+			continue
+		}
+
+		if oldValue == nil {
+			mutationsByTypeCtr.WithLabelValues(mutationCreate).Inc()
+		} else {
+			mutationsByTypeCtr.WithLabelValues(mutationUpdate).Inc()
+		}
+		retMap[indexKey(index, size)] = &trillian.MapLeaf{
+			Index:     index,
+			LeafValue: newValue,
+		}
+	}
+	// Convert return map back into a list, sorted by index so that the
+	// resulting SetLeaves request is deterministic.
+	ret := make([]*trillian.MapLeaf, 0, len(retMap))
+	for _, v := range retMap {
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i].Index, ret[j].Index) < 0
+	})
+	return ret, applied, nil
+}
+
+// CreateEpoch signs the current map head. It returns ErrRateLimited if
+// MaxEpochsPerWindow epochs have already been created in the last
+// EpochRateWindow.
+func (s *sequencer) CreateEpoch(ctx context.Context, forceNewEpoch bool) error {
+	if s.rateLimited() {
+		rateLimitedCtr.Inc()
+		return ErrRateLimited
+	}
+	reason := reasonMutations
+	if forceNewEpoch {
+		reason = reasonForced
+	}
+	return s.sequenceEpoch(ctx, reason)
+}
+
+// sequenceEpoch applies the new mutations to the map and adds the new map root
+// to the log, regardless of the rate limit. An epoch is created without new
+// mutations only if reason is forced.
+func (s *sequencer) sequenceEpoch(ctx context.Context, reason epochReason) error {
+	return s.trackEpoch(ctx, func(ctx context.Context) (bool, error) {
+		return s.runEpoch(ctx, reason)
+	})
+}
+
+// trackEpoch calls run, which reports whether an epoch was created, such that
+// the epoch can be aborted with AbortCurrentEpoch. It counts the outcome in
+// epochsCtr and charges the rate limit for created epochs.
+func (s *sequencer) trackEpoch(ctx context.Context, run func(ctx context.Context) (bool, error)) error {
+	ctx, done := s.beginEpoch(ctx)
+	created, err := run(ctx)
+	if aborted := done(); aborted && err != nil {
+		glog.Warningf("CreateEpoch aborted: %v", err)
+		err = ErrEpochAborted
+	}
+	switch {
+	case err != nil:
+		epochsCtr.WithLabelValues(outcomeError).Inc()
+	case !created:
+		epochsCtr.WithLabelValues(outcomeEmptySkipped).Inc()
+	default:
+		epochsCtr.WithLabelValues(outcomeSuccess).Inc()
+		s.chargeEpoch()
+	}
+	return err
+}
+
+// runEpoch implements sequenceEpoch. It also reports whether an epoch was
+// created.
+func (s *sequencer) runEpoch(ctx context.Context, reason epochReason) (bool, error) {
+	ctx, id := withTraceID(ctx)
+	glog.V(2).Infof("CreateEpoch[%v]: starting sequencing run", id)
+	start := time.Now()
+	revision, startSequence, err := s.mapHead(ctx)
+	if err != nil {
+		return false, err
+	}
+	glog.V(3).Infof("CreateEpoch[%v]: Previous SignedMapRoot: {Revision: %v, HighestFullyCompletedSeq: %v}", id, revision, startSequence)
+	pending, pendingErr := s.pendingMutations(ctx, startSequence)
+	if pendingErr != nil {
+		glog.Warningf("CreateEpoch: pendingMutations(%v): %v", startSequence, pendingErr)
+	} else {
+		pendingGauge.Set(float64(pending))
+		s.setPending(pending)
+	}
+
+	// Get the list of new mutations to process, skipping those known to
+	// change no leaf of the current map revision.
+	readFrom := s.noOpSequence(revision, startSequence)
+	readStart := time.Now()
+	mutations, checkpoints, seq, err := s.newMutations(ctx, readFrom)
+	if err != nil && seq == readFrom {
+		return false, fmt.Errorf("newMutations(%v): %v", readFrom, err)
+	} else if err != nil {
+		// Sequence the mutations that were read successfully.
+		glog.Warningf("CreateEpoch: newMutations(%v): %v, sequencing up to %v", readFrom, err, seq)
+	}
+	readMutationsHist.Observe(time.Since(readStart).Seconds())
+
+	// Don't create epoch if there is nothing to process unless explicitly
+	// specified by caller
+	if len(mutations) == 0 && !reason.forced() {
+		glog.Infof("CreateEpoch: No mutations found. Exiting.")
+		s.setNoOpSequence(revision, seq)
+		return false, nil
+	}
+
+	resp, err := s.createEpochAt(ctx, mutations, checkpoints, revision, startSequence, readFrom, seq, reason.forced())
+	if err != nil {
+		return false, err
+	}
+	if resp == nil {
+		s.setNoOpSequence(revision, seq)
+	}
+	if resp != nil && len(mutations) == 0 {
+		glog.V(2).Infof("CreateEpoch[%v]: created empty epoch %v (%v)", id, resp.GetEpoch(), reason)
+		emptyEpochsCtr.WithLabelValues(reason.String()).Inc()
+	}
+	if resp != nil && pendingErr == nil {
+		// The mutations up to the new HighestFullyCompletedSeq are no
+		// longer pending.
+		sequenced := uint64(resp.GetSmr().GetMetadata().GetHighestFullyCompletedSeq() - startSequence)
+		if sequenced > pending {
+			sequenced = pending
+		}
+		s.setPending(pending - sequenced)
+	}
+	s.createEpochHist.Observe(time.Since(start).Seconds())
+	return resp != nil, nil
+}
+
+// getLeaves reads the leaves at indexes in map revision revision, from their
+// shard if MapShardFunc is set.
+func (s *sequencer) getLeaves(ctx context.Context, indexes [][]byte, revision int64) (*trillian.GetMapLeavesResponse, error) {
+	if s.MapShardFunc != nil {
+		return s.getShardedLeaves(ctx, indexes, revision)
+	}
+	return s.getMapLeaves(ctx, MapShard{MapID: s.mapID, Client: s.tmap}, indexes, revision)
+}
+
+// getMapLeaves reads the leaves at indexes in revision revision of shard. If
+// the map rejects the request because it has too many indexes, getMapLeaves
+// splits indexes in halves and reads them separately, up to
+// maxGetLeavesSplits times.
+func (s *sequencer) getMapLeaves(ctx context.Context, shard MapShard, indexes [][]byte, revision int64) (*trillian.GetMapLeavesResponse, error) {
+	return s.splitMapLeaves(ctx, shard, indexes, revision, maxGetLeavesSplits)
+}
+
+// splitMapLeaves implements getMapLeaves, splitting the request at most
+// splits more times.
+func (s *sequencer) splitMapLeaves(ctx context.Context, shard MapShard, indexes [][]byte, revision int64, splits int) (*trillian.GetMapLeavesResponse, error) {
+	resp, err := shard.Client.GetLeaves(ctx, &trillian.GetMapLeavesRequest{
+		MapId:    shard.MapID,
+		Index:    indexes,
+		Revision: revision,
+	}, s.callOpts...)
+	if err == nil || len(indexes) < 2 || splits == 0 || !tooManyIndexes(err) {
+		return resp, err
+	}
+	glog.V(2).Infof("GetLeaves(%v indexes): %v, splitting the request", len(indexes), err)
+	half := len(indexes) / 2
+	first, err := s.splitMapLeaves(ctx, shard, indexes[:half], revision, splits-1)
+	if err != nil {
+		return nil, err
+	}
+	second, err := s.splitMapLeaves(ctx, shard, indexes[half:], revision, splits-1)
+	if err != nil {
+		return nil, err
+	}
+	first.MapLeafInclusion = append(first.MapLeafInclusion, second.GetMapLeafInclusion()...)
+	return first, nil
+}
+
+// currentLeaves reads the leaves at indexes in map revision revision, which
+// an epoch applies its mutations to. Failures are retried GetLeavesRetries
+// times. If the leaves still cannot be read and BestEffortLeaves is set,
+// currentLeaves returns no leaves, so that mutations are applied to empty
+// leaves.
+func (s *sequencer) currentLeaves(ctx context.Context, indexes [][]byte, revision int64) (*trillian.GetMapLeavesResponse, error) {
+	resp, err := s.getLeaves(ctx, indexes, revision)
+	backoff := getLeavesBackoff
+	for retries := 0; err != nil && retries < s.GetLeavesRetries; retries++ {
+		glog.Warningf("GetLeaves(%v): %v, retrying in %v", revision, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		resp, err = s.getLeaves(ctx, indexes, revision)
+	}
+	if err == nil {
+		return resp, nil
+	}
+	if !s.BestEffortLeaves || ctx.Err() != nil {
+		return nil, err
+	}
+	glog.Errorf("GetLeaves(%v): %v, applying %v mutations to empty leaves", revision, err, len(indexes))
+	bestEffortEpochsCtr.Inc()
+	return &trillian.GetMapLeavesResponse{}, nil
+}
+
+// tooManyIndexes returns true if err may be caused by a GetLeaves request
+// holding more indexes than the map accepts. Other invalid requests would
+// fail the same way after splitting, so only ResourceExhausted qualifies.
+func tooManyIndexes(err error) bool {
+	return grpc.Code(err) == codes.ResourceExhausted
+}
+
+// addNewProofs sets the inclusion proof of every mutation in map revision
+// revision.
+func (s *sequencer) addNewProofs(ctx context.Context, revision int64, indexes [][]byte, mutations []*tpb.Mutation) error {
+	getResp, err := s.getLeaves(ctx, indexes, revision)
 	if err != nil {
 		return err
 	}
-	idHash := sha256.Sum256(smrJSON)
+	proofs := inclusionsByIndex(getResp.GetMapLeafInclusion(), s.indexSize())
+	for i, m := range mutations {
+		m.NewProof = proofs[indexKey(indexes[i], s.indexSize())]
+	}
+	return nil
+}
+
+// mapHead returns the revision and the highest fully completed sequence number
+// of the current map root, after checking that neither moved backwards.
+func (s *sequencer) mapHead(ctx context.Context) (revision, startSequence int64, err error) {
+	rootResp, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
+	}
+	startSequence = rootResp.GetMapRoot().GetMetadata().GetHighestFullyCompletedSeq()
+	revision = rootResp.GetMapRoot().GetMapRevision()
+	if err := s.checkSequence(startSequence); err != nil {
+		return 0, 0, err
+	}
+	if err := s.checkRevision(revision); err != nil {
+		return 0, 0, err
+	}
+	return revision, startSequence, nil
+}
 
-	if _, err := tlog.QueueLeaf(ctx, &trillian.QueueLeafRequest{
-		LogId: logID,
-		Leaf: &trillian.LogLeaf{
-			LeafValue:        smrJSON,
-			LeafIdentityHash: idHash[:],
-		},
+// createEpochWithMutations applies mutations, read up to sequence number seq
+// by the caller, on top of the current map root. Unlike CreateEpoch it does not
+// read mutations from storage, nor does it filter them.
+func (s *sequencer) createEpochWithMutations(ctx context.Context, mutations []*tpb.SignedKV, seq int64, forceNewEpoch bool) (*tpb.GetMutationsResponse, error) {
+	revision, startSequence, err := s.mapHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.createEpochAt(ctx, mutations, nil, revision, startSequence, startSequence, seq, forceNewEpoch)
+}
+
+// createEpochAt implements CreateEpoch and createEpochWithMutations once the
+// map head, at revision and startSequence, has been read. The mutations were
+// read from readFrom up to seq.
+func (s *sequencer) createEpochAt(ctx context.Context, mutations []*tpb.SignedKV, checkpoints []checkpoint, revision, startSequence, readFrom, seq int64, forceNewEpoch bool) (*tpb.GetMutationsResponse, error) {
+	if seq < startSequence {
+		return nil, fmt.Errorf("sequence number %v is behind the map (%v)", seq, startSequence)
+	}
+	return s.createEpoch(ctx, mutations, checkpoints, revision, readFrom, seq, forceNewEpoch)
+}
+
+// CreateEpochFromRange creates a new epoch by re-applying the mutations with
+// sequence numbers in (startSequence, endSequence]. It is intended for disaster
+// recovery. Like CreateEpoch, it returns ErrRateLimited if MaxEpochsPerWindow
+// epochs have already been created in the last EpochRateWindow, and the epoch
+// may be aborted with AbortCurrentEpoch.
+//
+// The highest fully completed sequence number stored in the map never moves
+// backwards, and only advances if startSequence is not above it, so mutations
+// that have not been sequenced yet are still picked up by the next call to
+// CreateEpoch. Since the map only records that sequence number,
+// MutationsForEpoch and ReplayEpochs do not return the mutations re-applied
+// at or below it.
+func (s *sequencer) CreateEpochFromRange(ctx context.Context, startSequence, endSequence uint64) (*tpb.GetMutationsResponse, error) {
+	if endSequence < startSequence {
+		return nil, fmt.Errorf("invalid sequence range (%v, %v]", startSequence, endSequence)
+	}
+	if s.rateLimited() {
+		rateLimitedCtr.Inc()
+		return nil, ErrRateLimited
+	}
+	var resp *tpb.GetMutationsResponse
+	err := s.trackEpoch(ctx, func(ctx context.Context) (bool, error) {
+		var err error
+		resp, err = s.replayRange(ctx, startSequence, endSequence)
+		return resp != nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// replayRange implements CreateEpochFromRange.
+func (s *sequencer) replayRange(ctx context.Context, startSequence, endSequence uint64) (*tpb.GetMutationsResponse, error) {
+	revision, seq, err := s.mapHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mutations, maxSequence, err := s.rangeMutations(ctx, startSequence, endSequence)
+	if err != nil {
+		return nil, fmt.Errorf("rangeMutations(%v, %v): %v", startSequence, endSequence, err)
+	}
+	// Skipping over (seq, startSequence] would never apply those mutations.
+	if int64(startSequence) <= seq && maxSequence > seq {
+		seq = maxSequence
+	}
+	glog.Infof("CreateEpochFromRange: replaying %v mutations in (%v, %v]",
+		len(mutations), startSequence, endSequence)
+	return s.createEpoch(ctx, mutations, nil, revision, int64(startSequence), seq, true)
+}
+
+// createEpoch applies mutations to the leaves of map revision rootRevision,
+// records seq as the highest fully completed sequence number of the new map
+// revision, and adds the new map root to the log. startSequence is the first
+// sequence number, exclusive, of mutations. If none of the mutations change
+// the map and forceNewEpoch is false, no epoch is created and createEpoch
+// returns a nil response. If PartialEpochMargin is set, the epoch may only
+// hold the mutations up to one of checkpoints.
+func (s *sequencer) createEpoch(ctx context.Context, mutations []*tpb.SignedKV, checkpoints []checkpoint, rootRevision, startSequence, seq int64, forceNewEpoch bool) (*tpb.GetMutationsResponse, error) {
+	ctx, id := withTraceID(ctx)
+	// Get current leaf values.
+	indexes, err := s.leafIndexes(mutations)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(2).Infof("CreateEpoch[%v]: len(mutations): %v, len(indexes): %v",
+		id, len(mutations), len(indexes))
+	if s.MapShardFunc != nil {
+		if err := s.checkMapShards(ctx, rootRevision); err != nil {
+			return nil, err
+		}
+	}
+	getLeavesStart := time.Now()
+	// Read the revision that seq is relative to.
+	getResp, err := s.currentLeaves(ctx, indexes, rootRevision)
+	if err != nil {
+		return nil, err
+	}
+	getLeavesHist.Observe(time.Since(getLeavesStart).Seconds())
+	glog.V(3).Infof("CreateEpoch[%v]: len(GetLeaves.MapLeafInclusions): %v",
+		id, len(getResp.MapLeafInclusion))
+	// The map server may return the inclusions in any order and omit some.
+	// Mutations without an inclusion are applied to an empty leaf.
+	proofs := inclusionsByIndex(getResp.GetMapLeafInclusion(), s.indexSize())
+	mutationsResp := make([]*tpb.Mutation, 0, len(mutations))
+	for i, m := range mutations {
+		mutationsResp = append(mutationsResp, &tpb.Mutation{
+			Update:   m,
+			Proof:    proofs[indexKey(indexes[i], s.indexSize())],
+			Metadata: m.GetMetadata(),
+		})
+	}
+
+	// Trust the leaf values provided by the map server.
+	// If the map server is run by an untrusted entity, perform inclusion
+	// and signature verification here.
+	leaves := make([]*trillian.MapLeaf, 0, len(proofs))
+	for _, p := range proofs {
+		leaves = append(leaves, p.GetLeaf())
+	}
+
+	// Apply mutations to values.
+	applyStart := time.Now()
+	newLeaves, applied, err := s.applyMutationsUntil(ctx, mutations, leaves, s.partialStop(ctx, checkpoints))
+	if err != nil {
+		return nil, err
+	}
+	if applied < len(mutations) {
+		// Only the mutations up to the checkpoint are in the epoch.
+		seq, _ = checkpointAt(checkpoints, applied)
+		glog.Warningf("CreateEpoch[%v]: deadline approaching, committing %v of %v mutations up to sequence %v",
+			id, applied, len(mutations), seq)
+		partialEpochsCtr.Inc()
+		mutations = mutations[:applied]
+		indexes = indexes[:applied]
+		mutationsResp = mutationsResp[:applied]
+	}
+	applyHist.Observe(time.Since(applyStart).Seconds())
+	glog.V(2).Infof("CreateEpoch[%v]: applied %v mutations to %v leaves",
+		id, len(mutations), len(leaves))
+	if len(newLeaves) == 0 && !forceNewEpoch {
+		// Advancing the map and the log would only advance the highest
+		// fully completed sequence number, which the caller may record
+		// with setNoOpSequence instead.
+		glog.Infof("CreateEpoch: No leaves changed. Exiting.")
+		return nil, nil
+	}
+	if s.LeafTransform != nil {
+		newLeaves, err = s.LeafTransform(newLeaves)
+		if err != nil {
+			return nil, fmt.Errorf("LeafTransform(): %v", err)
+		}
+	}
+	if s.PreCommit != nil {
+		if err := s.PreCommit(ctx, newLeaves); err != nil {
+			return nil, fmt.Errorf("PreCommit(): %v", err)
+		}
+	}
+	// Once SetLeaves succeeds, the map root must be added to the log.
+	if err := s.checkLeafCodec(); err != nil {
+		return nil, err
+	}
+	if err := s.checkEpochSigner(); err != nil {
+		return nil, err
+	}
+
+	if err := s.writeIntent(ctx, &Intent{
+		StartSequence: startSequence,
+		MaxSequence:   seq,
+		Revision:      rootRevision + 1,
 	}); err != nil {
+		return nil, fmt.Errorf("writeIntent(): %v", err)
+	}
+
+	// Set new leaf values.
+	mapSetStart := time.Now()
+	shardLeaves := newLeaves
+	if s.MapShardFunc != nil {
+		byShard, err := s.shardLeaves(newLeaves)
+		if err != nil {
+			return nil, err
+		}
+		if shardLeaves, err = s.setShardLeaves(ctx, byShard, seq); err != nil {
+			return nil, err
+		}
+	}
+	setReq := &trillian.SetMapLeavesRequest{
+		MapId:      s.mapID,
+		Leaves:     shardLeaves,
+		MapperData: s.mapperData(seq),
+	}
+	setLeavesBytesHist.Observe(float64(proto.Size(setReq)))
+	// The epoch can no longer be aborted once the map revision is written.
+	if ctx, err = s.commitEpoch(ctx); err != nil {
+		return nil, err
+	}
+	setResp, err := s.tmap.SetLeaves(ctx, setReq, s.callOpts...)
+	mapSetEnd := time.Now()
+	if err != nil {
+		return nil, err
+	}
+	revision := setResp.GetMapRoot().GetMapRevision()
+	glog.V(2).Infof("CreateEpoch[%v]: SetLeaves:{Revision: %v, HighestFullyCompletedSeq: %v}", id, revision, seq)
+	if revision < 1 {
+		// Revision 0 is the empty map, whose root Initialize adds to the log.
+		return nil, fmt.Errorf("SetLeaves(%v): invalid map revision %v", s.mapID, revision)
+	}
+	if revision <= rootRevision {
+		// Adding the map root to the log again would break the one leaf
+		// per revision invariant.
+		glog.Errorf("CreateEpoch[%v]: SetLeaves returned revision %v, not after %v", id, revision, rootRevision)
+		return nil, ErrRevisionNotAdvanced
+	}
+
+	// Put SignedMapHead in an append only log.
+	if err := s.queueLogLeaf(ctx, setResp.GetMapRoot()); err != nil {
+		// TODO(gdbelvin): If the log doesn't do this, we need to generate an emergency alert.
+		return nil, err
+	}
+	if err := s.waitForLogLeaf(ctx, revision); err != nil {
+		// The epoch has been committed and its map root queued, which
+		// the log eventually integrates.
+		glog.Errorf("CreateEpoch[%v]: %v", id, err)
+		logIntegrationTimeoutCtr.Inc()
+	}
+	if s.VerifyMapRoot {
+		if err := s.verifyMapRoot(ctx, setResp.GetMapRoot()); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.clearIntent(ctx); err != nil {
+		// The epoch has been committed. A stale intent is harmless since
+		// replaying it is idempotent.
+		glog.Errorf("CreateEpoch: clearIntent(): %v", err)
+	}
+	if s.FetchNewLeafProofs {
+		if err := s.addNewProofs(ctx, revision, indexes, mutationsResp); err != nil {
+			// The epoch has been committed. Subscribers can still
+			// query the map for the proofs.
+			glog.Errorf("CreateEpoch[%v]: addNewProofs(%v): %v", id, revision, err)
+		}
+	}
+
+	resp := &tpb.GetMutationsResponse{
+		Epoch:          s.epochOf(revision),
+		Smr:            setResp.GetMapRoot(),
+		Mutations:      mutationsResp,
+		MutatorVersion: s.MutatorVersion,
+		ContentHash:    contentHash(newLeaves, seq),
+		LeavesRead:     int64(len(getResp.GetMapLeafInclusion())),
+		LeavesWritten:  int64(len(newLeaves)),
+	}
+	if resp.SmrSignature, err = s.signMapRoot(setResp.GetMapRoot()); err != nil {
+		// The epoch has been committed. The map root is still signed by
+		// the map server.
+		glog.Errorf("CreateEpoch[%v]: signMapRoot(%v): %v", id, revision, err)
+		signMapRootErrCtr.Inc()
+	}
+	if s.AttachLogProofs {
+		// The log held a leaf per revision before this one.
+		s.addLogProofs(ctx, resp, revision)
+	}
+	if s.OnEpoch != nil {
+		if err := s.OnEpoch(ctx, resp); err != nil {
+			glog.Errorf("CreateEpoch: OnEpoch(%v): %v", revision, err)
+			onEpochErrCtr.Inc()
+		}
+	}
+	s.audit(ctx, setResp.GetMapRoot(), startSequence, seq, len(mutations), newLeaves)
+	s.publish(ctx, resp)
+
+	s.setLastEpoch(revision, seq, time.Unix(0, setResp.GetMapRoot().GetTimestampNanos()))
+	if conflicts := indexConflicts(indexes, s.indexSize()); len(conflicts) > 0 {
+		glog.Infof("CreateEpoch: rev: %v, indexes with conflicting mutations: %x", revision, conflicts)
+		conflictsCtr.Add(float64(len(conflicts)))
+	}
+	mutationsCtr.Add(float64(len(mutations)))
+	mutationsPerEpochHist.Observe(float64(len(mutations)))
+	indexCtr.Add(float64(len(indexes)))
+	s.mapUpdateHist.Observe(mapSetEnd.Sub(mapSetStart).Seconds())
+	glog.Infof("CreatedEpoch: rev: %v, root: %x", revision, setResp.GetMapRoot().GetRootHash())
+	return resp, nil
+}
+
+// epochOf returns the number of the epoch of map revision revision.
+func (s *sequencer) epochOf(revision int64) int64 {
+	return revision + s.EpochOffset
+}
+
+// revisionOf returns the map revision of epoch.
+func (s *sequencer) revisionOf(epoch int64) int64 {
+	return epoch - s.EpochOffset
+}
+
+// signMapRoot returns the signature of EpochSigner over the serialized smr, or
+// nil if EpochSigner is not set.
+func (s *sequencer) signMapRoot(smr *trillian.SignedMapRoot) ([]byte, error) {
+	if s.EpochSigner == nil {
+		return nil, nil
+	}
+	msg, opts, err := s.mapRootDigest(smr)
+	if err != nil {
+		return nil, err
+	}
+	return s.EpochSigner.Sign(crand.Reader, msg, opts)
+}
+
+// checkEpochSigner returns an error if EpochSigner fails to sign a map root.
+// It is called before writing to the map, like checkLeafCodec, so that an
+// unavailable signer fails the epoch rather than leaving it unsigned.
+func (s *sequencer) checkEpochSigner() error {
+	if _, err := s.signMapRoot(&trillian.SignedMapRoot{MapId: s.mapID}); err != nil {
+		return fmt.Errorf("signMapRoot(): %v", err)
+	}
+	return nil
+}
+
+// mapRootDigest returns the message signed by EpochSigner for smr, and the
+// options to sign it with.
+func (s *sequencer) mapRootDigest(smr *trillian.SignedMapRoot) ([]byte, crypto.SignerOpts, error) {
+	return mapRootDigest(smr, s.LeafCodec, s.EpochSignerOpts)
+}
+
+// mapRootDigest returns the message signed for smr serialized with codec, and
+// opts, defaulting to SHA-256.
+func mapRootDigest(smr *trillian.SignedMapRoot, codec LeafCodec, opts crypto.SignerOpts) ([]byte, crypto.SignerOpts, error) {
+	msg, err := codec.Marshal(smr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts == nil {
+		opts = crypto.SHA256
+	}
+	if hash := opts.HashFunc(); hash != 0 {
+		h := hash.New()
+		h.Write(msg)
+		msg = h.Sum(nil)
+	}
+	return msg, opts, nil
+}
+
+// LastEpoch returns the revision and the map root timestamp of the last epoch
+// created by s. It returns a zero time if s has not created any epoch yet.
+func (s *sequencer) LastEpoch() (revision int64, at time.Time) {
+	s.lastMu.RLock()
+	defer s.lastMu.RUnlock()
+	return s.lastRev, s.lastAt
+}
+
+func (s *sequencer) setLastEpoch(revision, seq int64, at time.Time) {
+	s.lastMu.Lock()
+	defer s.lastMu.Unlock()
+	s.lastRev = revision
+	s.lastAt = at
+	if seq > s.lastSeq {
+		s.lastSeq = seq
+	}
+}
+
+// Saturated reports whether more than PendingHighWater mutations were pending
+// after the last epoch. It is always false if PendingHighWater is not set.
+func (s *sequencer) Saturated() bool {
+	s.lastMu.RLock()
+	defer s.lastMu.RUnlock()
+	return s.PendingHighWater > 0 && s.pending > s.PendingHighWater
+}
+
+func (s *sequencer) setPending(pending uint64) {
+	s.lastMu.Lock()
+	defer s.lastMu.Unlock()
+	s.pending = pending
+}
+
+// noOpSequence returns the sequence number to read new mutations from for an
+// epoch applied to map revision revision, whose highest fully completed
+// sequence number is startSequence. Mutations recorded by setNoOpSequence
+// for the same revision are skipped.
+func (s *sequencer) noOpSequence(revision, startSequence int64) int64 {
+	s.lastMu.RLock()
+	defer s.lastMu.RUnlock()
+	if revision == s.noOpRev && s.noOpSeq > startSequence {
+		return s.noOpSeq
+	}
+	return startSequence
+}
+
+// setNoOpSequence records that the mutations up to seq change no leaf of map
+// revision revision, so that epochs do not read them again until the map
+// advances. It is only kept in memory: after a restart, they are read again.
+func (s *sequencer) setNoOpSequence(revision, seq int64) {
+	s.lastMu.Lock()
+	defer s.lastMu.Unlock()
+	s.noOpRev = revision
+	s.noOpSeq = seq
+}
+
+// checkSequence returns ErrSequenceRegression if startSequence is lower than
+// the highest sequence number committed by s. Starting an epoch from it would
+// apply mutations again, possibly reverting later ones.
+func (s *sequencer) checkSequence(startSequence int64) error {
+	s.lastMu.RLock()
+	defer s.lastMu.RUnlock()
+	if startSequence < s.lastSeq {
+		glog.Errorf("CreateEpoch: map HighestFullyCompletedSeq %v is lower than committed sequence %v", startSequence, s.lastSeq)
+		return ErrSequenceRegression
+	}
+	return nil
+}
+
+// checkRevision reports a skip if revision, the current map revision, is past
+// the last revision written by s. The sequence numbers of the revisions in
+// between were not recorded by s, so that its view of the committed mutations
+// may be stale. It returns ErrRevisionSkipped if HaltOnRevisionSkip is set.
+func (s *sequencer) checkRevision(revision int64) error {
+	s.lastMu.RLock()
+	defer s.lastMu.RUnlock()
+	if s.lastRev == 0 || revision <= s.lastRev {
+		return nil
+	}
+	glog.Errorf("CreateEpoch: map revision %v is past the last revision %v written by the signer", revision, s.lastRev)
+	revisionSkipCtr.Inc()
+	if s.HaltOnRevisionSkip {
+		return ErrRevisionSkipped
+	}
+	return nil
+}
+
+// waitForLogLeaf polls the log until the map root of revision has been
+// integrated at index revision, or until LogIntegrationTimeout elapses.
+func (s *sequencer) waitForLogLeaf(ctx context.Context, revision int64) error {
+	if s.LogIntegrationTimeout <= 0 || s.MapOnly {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.LogIntegrationTimeout)
+	defer cancel()
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+	for {
+		logRoot, err := s.tlog.GetLatestSignedLogRoot(ctx,
+			&trillian.GetLatestSignedLogRootRequest{
+				LogId: s.logID,
+			}, s.callOpts...)
+		if err != nil {
+			glog.Warningf("waitForLogLeaf(%v): GetLatestSignedLogRoot(%v): %v", revision, s.logID, err)
+		} else if logRoot.GetSignedLogRoot().GetTreeSize() > revision {
+			// The map root of revision is the leaf at index revision.
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waitForLogLeaf(%v): map root not integrated: %v", revision, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// TODO(gdbelvin): Add leaf at a specific index. trillian#423
+func (s *sequencer) queueLogLeaf(ctx context.Context, smr *trillian.SignedMapRoot) error {
+	if s.MapOnly {
+		return nil
+	}
+	leaf, err := mapRootLeaf(smr, s.LeafCodec, s.CompressLeaves)
+	if err != nil {
+		return err
+	}
+	if s.LeafHasher != nil {
+		leaf.LeafIdentityHash = s.LeafHasher(smr, leaf.LeafValue)
+	}
+	resp, err := s.tlog.QueueLeaf(ctx, &trillian.QueueLeafRequest{
+		LogId: s.logID,
+		Leaf:  leaf,
+	}, s.callOpts...)
+	if err != nil {
 		return fmt.Errorf("trillianLog.QueueLeaf(logID: %v, leaf: %v): %v",
-			logID, smrJSON, err)
+			s.logID, leaf.LeafValue, err)
+	}
+	if queued := resp.GetQueuedLeaf().GetLeaf(); queued != nil && !bytes.Equal(queued.LeafValue, leaf.LeafValue) {
+		// The log returned the earlier leaf with the same identity hash.
+		glog.Warningf("QueueLeaf(revision: %v): deduplicated with leaf %x", smr.GetMapRevision(), queued.LeafIdentityHash)
+	}
+	if s.MirrorLog != nil {
+		s.queueMirrorLeaf(ctx, leaf)
+	}
+	return nil
+}
+
+// queueMirrorLeaf adds leaf to MirrorLog. Since the epoch of leaf has been
+// committed, failures do not fail it. Unless MirrorBestEffort is set, the
+// leaves that could not be added are retried, in order, before the next one.
+func (s *sequencer) queueMirrorLeaf(ctx context.Context, leaf *trillian.LogLeaf) {
+	s.mirrorMu.Lock()
+	defer s.mirrorMu.Unlock()
+	s.mirrorBacklog = append(s.mirrorBacklog, leaf)
+	for len(s.mirrorBacklog) > 0 {
+		next := s.mirrorBacklog[0]
+		if _, err := s.MirrorLog.QueueLeaf(ctx, &trillian.QueueLeafRequest{
+			LogId: s.MirrorLogID,
+			Leaf:  next,
+		}, s.callOpts...); err != nil {
+			mirrorErrCtr.Inc()
+			glog.Warningf("mirrorLog.QueueLeaf(logID: %v, leaf: %v): %v",
+				s.MirrorLogID, next.LeafValue, err)
+			if s.MirrorBestEffort {
+				s.mirrorBacklog = nil
+			}
+			break
+		}
+		s.mirrorBacklog = s.mirrorBacklog[1:]
+	}
+	mirrorBacklogGauge.Set(float64(len(s.mirrorBacklog)))
+}
+
+// mapRootLeaf returns the log leaf holding smr serialized with codec, and
+// compressed if compress is true. It is the only place that defines how map
+// roots are serialized into the log, so that the leaves added by Initialize
+// and CreateEpoch can be verified the same way. The identity hash covers the
+// leaf value as stored in the log.
+func mapRootLeaf(smr *trillian.SignedMapRoot, codec LeafCodec, compress bool) (*trillian.LogLeaf, error) {
+	leafValue, err := codec.Marshal(smr)
+	if err != nil {
+		return nil, err
+	}
+	if compress {
+		if leafValue, err = compressLeafValue(leafValue); err != nil {
+			return nil, err
+		}
+	}
+	return &trillian.LogLeaf{
+		LeafValue:        leafValue,
+		LeafIdentityHash: leafIdentityHash(smr, leafValue),
+	}, nil
+}
+
+// checkLeafCodec returns an error if map roots cannot be turned into log
+// leaves with LeafCodec and CompressLeaves. It is called before writing to
+// the map, so that such errors do not leave the map ahead of the log. Marshal
+// errors depend on the codec rather than on the map root, so an empty root is
+// used. Failures to add the leaf to the log after the map was written are
+// recovered by Initialize from the WAL, if set.
+func (s *sequencer) checkLeafCodec() error {
+	if _, err := mapRootLeaf(&trillian.SignedMapRoot{MapId: s.mapID}, s.LeafCodec, s.CompressLeaves); err != nil {
+		return fmt.Errorf("mapRootLeaf(): %v", err)
 	}
 	return nil
 }
+
+// contentHash returns a hash of leaves, in index order, and seq. Unlike the
+// map root hash, it does not depend on the previous map revision, so that
+// sequencers can check that they applied the same mutations.
+func contentHash(leaves []*trillian.MapLeaf, seq int64) []byte {
+	sorted := make([]*trillian.MapLeaf, len(leaves))
+	copy(sorted, leaves)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Index, sorted[j].Index) < 0
+	})
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, seq)
+	for _, l := range sorted {
+		// Length prefixes keep the encoding unambiguous.
+		binary.Write(h, binary.BigEndian, uint32(len(l.Index)))
+		h.Write(l.Index)
+		binary.Write(h, binary.BigEndian, uint32(len(l.LeafValue)))
+		h.Write(l.LeafValue)
+	}
+	return h.Sum(nil)
+}
+
+// leafIdentityHash returns the identity hash of the log leaf holding smr.
+// The map revision and timestamp are hashed explicitly so that every epoch
+// produces a distinct leaf that the log will not deduplicate, regardless of
+// how smr is serialized into leafValue.
+func leafIdentityHash(smr *trillian.SignedMapRoot, leafValue []byte) []byte {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, smr.GetMapRevision())
+	binary.Write(h, binary.BigEndian, smr.GetTimestampNanos())
+	h.Write(leafValue)
+	return h.Sum(nil)
+}
@@ -17,10 +17,45 @@
 package sequencer
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/keytransparency/core/mutator"
+	"github.com/google/keytransparency/core/mutator/entry"
+	"github.com/google/keytransparency/core/transaction"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian"
+	tcrypto "github.com/google/trillian/crypto"
 	"github.com/google/trillian/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	logID = 0
+	mapID = 0
 )
 
 var (
@@ -67,11 +102,10 @@ func TestEpochCreation(t *testing.T) {
 		// "after 10, 16, and 22 hours"
 		{4, now, twoOff, minInMax * 4, minDurationS, maxDurationH},
 	} {
-		enforce := genEpochTicks(clock, tc.lastForced, genFakeTicker(now, tc.min, tc.nTicks), tc.min, tc.max)
+		enforce := genEpochTicks(context.Background(), clock, tc.lastForced, genFakeTicker(now, tc.min, tc.nTicks), tc.min, tc.max, nil)
 		forcedTicks := 0
 		for i := 0; i < tc.nTicks; i++ {
-			force := <-enforce
-			if force {
+			if tick := <-enforce; tick.reason.forced() {
 				forcedTicks++
 			}
 		}
@@ -84,6 +118,3553 @@ func TestEpochCreation(t *testing.T) {
 	}
 }
 
+func TestEpochTriggers(t *testing.T) {
+	clock := util.NewFakeTimeSource(fakeNow)
+	now := clock.Now()
+	min, max := time.Second, 3*time.Second
+	enforce := genEpochTicks(context.Background(), clock, now, genFakeTicker(now, min, 6), min, max, nil)
+	// An epoch is forced once max is at most one tick away.
+	for i, want := range []epochReason{
+		reasonMutations, reasonMaxInterval,
+		reasonMutations, reasonMaxInterval,
+		reasonMutations, reasonMaxInterval,
+	} {
+		tick := <-enforce
+		if tick.reason != want {
+			t.Errorf("tick %v: reason %v, want %v", i+1, tick.reason, want)
+		}
+		if got, want := tick.at, now.Add(time.Duration(i+1)*min); !got.Equal(want) {
+			t.Errorf("tick %v: at %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestEpochTicksStop(t *testing.T) {
+	clock := util.NewFakeTimeSource(fakeNow)
+	ctx, cancel := context.WithCancel(context.Background())
+	// Nobody reads the forced epoch due immediately.
+	enforce := genEpochTicks(ctx, clock, sixOff, make(chan time.Time), minDurationS, maxDurationH, nil)
+	cancel()
+	select {
+	case tick, ok := <-enforce:
+		// The goroutine may win the race against cancel once.
+		if ok {
+			if _, ok := <-enforce; ok {
+				t.Errorf("genEpochTicks() sent %v after ctx was done", tick)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("genEpochTicks() did not stop when ctx was done")
+	}
+}
+
+func TestEpochJitter(t *testing.T) {
+	maxJitter := 100 * time.Millisecond
+	seed := int64(1)
+	want := time.Duration(rand.New(rand.NewSource(seed)).Int63n(int64(maxJitter)))
+	s := &sequencer{Options: Options{
+		MaxEpochJitter: maxJitter,
+		JitterSource:   rand.NewSource(seed),
+	}}
+
+	clock := util.NewFakeTimeSource(fakeNow)
+	ticks := make(chan time.Time)
+	close(ticks)
+	start := time.Now()
+	// The last epoch is old enough to force an epoch immediately.
+	enforce := genEpochTicks(context.Background(), clock, sixOff, ticks, minDurationS, maxDurationH, s.jitter())
+	if tick := <-enforce; tick.reason != reasonMaxInterval {
+		t.Fatalf("first epoch reason: %v, want %v", tick.reason, reasonMaxInterval)
+	}
+	if got := time.Since(start); got < want || got >= want+maxJitter {
+		t.Errorf("first epoch delayed by %v, want %v (jitter < %v)", got, want, maxJitter)
+	}
+}
+
+func TestMinMutationsPerEpoch(t *testing.T) {
+	ctx := context.Background()
+	clock := util.NewFakeTimeSource(fakeNow)
+	now := clock.Now()
+	minInterval, maxInterval := time.Minute, 5*time.Minute
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 2)...)
+	s := newTestSequencer(fakeMutations)
+	s.MinMutationsPerEpoch = 3
+	tmap := s.tmap.(*fakeMap)
+
+	// Ticks 1 to 3 are not forced and the 4th tick is forced.
+	enforce := genEpochTicks(context.Background(), clock, now, genFakeTicker(now, minInterval, 4), minInterval, maxInterval, nil)
+	for i, wantRoots := range []int{1, 1, 1, 2} {
+		if err := s.signEpoch(ctx, minInterval, (<-enforce).reason); err != nil {
+			t.Fatalf("signEpoch(): %v", err)
+		}
+		if got := len(tmap.roots); got != wantRoots {
+			t.Errorf("tick %v: len(map roots): %v, want %v", i+1, got, wantRoots)
+		}
+	}
+	if got, want := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(), int64(2); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+
+	// Reaching the threshold creates an epoch without waiting.
+	fakeMutations.write(signedKV(3, 5)...)
+	if err := s.signEpoch(ctx, minInterval, reasonMutations); err != nil {
+		t.Fatalf("signEpoch(): %v", err)
+	}
+	if got, want := len(tmap.roots), 3; got != want {
+		t.Errorf("len(map roots): %v, want %v", got, want)
+	}
+}
+
+func TestNewWithValidation(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		mapID, logID int64
+		wantErr      bool
+	}{
+		{mapID: mapID, logID: logID},
+		{mapID: 42, logID: logID, wantErr: true},
+		{mapID: mapID, logID: 42, wantErr: true},
+	} {
+		tmap := &treeIDMap{fakeMap: newFakeMap()}
+		tlog := &treeIDLog{fakeLog: &fakeLog{}}
+		_, err := NewWithValidation(ctx, tc.mapID, tmap, tc.logID, tlog, fakeMutator{}, &fakeMutation{}, fakeFactory{}, nil, Options{})
+		if got, want := err != nil, tc.wantErr; got != want {
+			t.Errorf("NewWithValidation(mapID: %v, logID: %v): %v, want err %v", tc.mapID, tc.logID, err, want)
+		}
+	}
+}
+
+func TestNilDependencies(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewWithValidation(ctx, mapID, newFakeMap(), logID, &fakeLog{}, nil, &fakeMutation{}, fakeFactory{}, nil, Options{})
+	if err == nil || !strings.Contains(err.Error(), "nil mutator") {
+		t.Errorf("NewWithValidation(nil mutator): %v, want a nil mutator error", err)
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("New(nil mutator) did not panic")
+		}
+	}()
+	New(mapID, newFakeMap(), logID, &fakeLog{}, nil, &fakeMutation{}, fakeFactory{}, nil, Options{})
+}
+
+func TestInSync(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		treeSize int
+		revision int
+		want     bool
+	}{
+		{1, 0, true},
+		{3, 2, true},
+		{0, 0, false},
+		{2, 3, false},
+		{3, 1, false},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		tmap := s.tmap.(*fakeMap)
+		for i := 1; i <= tc.revision; i++ {
+			tmap.roots = append(tmap.roots, &trillian.SignedMapRoot{MapRevision: int64(i)})
+		}
+		s.tlog.(*fakeLog).leaves = make([]*trillian.LogLeaf, tc.treeSize)
+
+		got, treeSize, revision, err := s.InSync(ctx)
+		if err != nil {
+			t.Fatalf("InSync(): %v", err)
+		}
+		if got != tc.want || treeSize != int64(tc.treeSize) || revision != int64(tc.revision) {
+			t.Errorf("InSync(tree size %v, revision %v): %v, %v, %v, want %v, %v, %v",
+				tc.treeSize, tc.revision, got, treeSize, revision, tc.want, tc.treeSize, tc.revision)
+		}
+	}
+}
+
+func TestInitialize(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		treeSize   int
+		revision   int
+		wantLeaves int
+		wantErr    error
+	}{
+		{0, 0, 1, nil},
+		{1, 0, 1, nil},
+		{3, 3, 3, nil},
+		{2, 3, 2, nil}, // Log leaves may still be integrating.
+		{3, 0, 3, ErrMapLogDesync},
+		{0, 2, 0, ErrMapLogDesync},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		tmap := s.tmap.(*fakeMap)
+		for i := 1; i <= tc.revision; i++ {
+			tmap.roots = append(tmap.roots, &trillian.SignedMapRoot{MapRevision: int64(i)})
+		}
+		tlog := s.tlog.(*fakeLog)
+		tlog.leaves = make([]*trillian.LogLeaf, tc.treeSize)
+
+		if got, want := s.Initialize(ctx), tc.wantErr; got != want {
+			t.Errorf("Initialize(log size: %v, map revision: %v): %v, want %v", tc.treeSize, tc.revision, got, want)
+		}
+		if got, want := len(tlog.leaves), tc.wantLeaves; got != want {
+			t.Errorf("Initialize(log size: %v, map revision: %v): log size %v, want %v", tc.treeSize, tc.revision, got, want)
+		}
+	}
+}
+
+// unintegratedLog is a trillian.TrillianLogClient fake that never integrates
+// the leaves it queues, so its tree stays empty.
+type unintegratedLog struct {
+	trillian.TrillianLogClient
+	mu     sync.Mutex
+	queued int
+}
+
+func (l *unintegratedLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queued++
+	return &trillian.QueueLeafResponse{}, nil
+}
+
+func (l *unintegratedLog) GetLatestSignedLogRoot(ctx context.Context, in *trillian.GetLatestSignedLogRootRequest, opts ...grpc.CallOption) (*trillian.GetLatestSignedLogRootResponse, error) {
+	// Widen the window between reading the tree size and queueing a leaf.
+	time.Sleep(time.Millisecond)
+	return &trillian.GetLatestSignedLogRootResponse{
+		SignedLogRoot: &trillian.SignedLogRoot{},
+	}, nil
+}
+
+func TestInitializeConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	tlog := &unintegratedLog{}
+	s.tlog = tlog
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Initialize(ctx); err != nil {
+				t.Errorf("Initialize(): %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	// Calling Initialize again before the seed leaf is integrated is a no-op.
+	if err := s.Initialize(ctx); err != nil {
+		t.Errorf("Initialize(): %v", err)
+	}
+	if got, want := tlog.queued, 1; got != want {
+		t.Errorf("Initialize(): %v leaves queued, want %v", got, want)
+	}
+}
+
+func TestStartSigningHalts(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	s.tmap = &failingMap{fakeMap: newFakeMap()}
+	s.MaxConsecutiveFailures = 5
+
+	done := make(chan error)
+	go func() {
+		done <- s.StartSigning(ctx, time.Millisecond, time.Millisecond)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("StartSigning(): nil, want error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("StartSigning() did not halt")
+	}
+	if got, want := gaugeValue(t, haltedGauge), 1.0; got != want {
+		t.Errorf("haltedGauge: %v, want %v", got, want)
+	}
+}
+
+func TestStartSigningNoMapRoot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newTestSequencer(&fakeMutation{})
+	tmap := &failingMap{fakeMap: newFakeMap()}
+	s.tmap = tmap
+	s.Clock = util.NewFakeTimeSource(time.Unix(1000, 0))
+
+	done := make(chan error)
+	go func() {
+		done <- s.StartSigning(ctx, time.Hour, 2*time.Hour)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("StartSigning(): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("StartSigning() did not return")
+	}
+	// Initialize, the initial read, the startup epoch and the second read.
+	// A zero last epoch time would force another epoch immediately.
+	if got, want := tmap.calls, 4; got != want {
+		t.Errorf("GetSignedMapRoot calls: %v, want %v", got, want)
+	}
+}
+
+func TestTickDrift(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	minInterval := 10 * time.Millisecond
+	s := newTestSequencer(&fakeMutation{})
+	// Every epoch is forced and takes three intervals.
+	s.tmap = &slowMap{fakeMap: newFakeMap(), setLeavesDelay: 3 * minInterval}
+	s.EpochTimeout = time.Second
+
+	beforeCount, beforeSum := histogramValue(t, tickDriftHist)
+	done := make(chan error)
+	go func() {
+		done <- s.StartSigning(ctx, minInterval, minInterval)
+	}()
+	time.Sleep(20 * minInterval)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("StartSigning(): %v", err)
+	}
+	count, sum := histogramValue(t, tickDriftHist)
+	if count-beforeCount < 2 {
+		t.Fatalf("tickDriftHist: %v observations, want at least 2", count-beforeCount)
+	}
+	if got := sum - beforeSum; got < minInterval.Seconds() {
+		t.Errorf("tickDriftHist: total drift %vs, want at least %vs", got, minInterval.Seconds())
+	}
+}
+
+func TestTickDriftClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	minInterval := 10 * time.Millisecond
+	s := newTestSequencer(&fakeMutation{})
+	// The injected clock never advances, unlike the ticker.
+	s.Clock = util.NewFakeTimeSource(time.Unix(1000, 0))
+
+	beforeCount, beforeSum := histogramValue(t, tickDriftHist)
+	done := make(chan error)
+	go func() {
+		done <- s.StartSigning(ctx, minInterval, minInterval)
+	}()
+	time.Sleep(5 * minInterval)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("StartSigning(): %v", err)
+	}
+	count, sum := histogramValue(t, tickDriftHist)
+	if count == beforeCount {
+		t.Fatalf("tickDriftHist: no observations")
+	}
+	if got := sum - beforeSum; got != 0 {
+		t.Errorf("tickDriftHist: total drift %vs, want 0s", got)
+	}
+}
+
+func TestSinceLastEpoch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Unix(1000, 0)
+	clock := util.NewFakeTimeSource(start)
+	s := newTestSequencer(&fakeMutation{})
+	s.Clock = clock
+
+	done := make(chan struct{})
+	go func() {
+		s.reportSinceLastEpoch(ctx, start, time.Millisecond)
+		close(done)
+	}()
+	// waitFor polls the gauge until it reports want seconds.
+	waitFor := func(want float64) {
+		for i := 0; i < 1000; i++ {
+			if gaugeValue(t, sinceLastEpochGauge) == want {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Errorf("sinceLastEpochGauge: %v, want %v", gaugeValue(t, sinceLastEpochGauge), want)
+	}
+	// Before any epoch, the time is measured from start.
+	clock.Set(start.Add(10 * time.Second))
+	waitFor(10)
+	clock.Set(start.Add(20 * time.Second))
+	waitFor(20)
+	// Creating an epoch resets the gauge.
+	s.setLastEpoch(1, 0, start.Add(15*time.Second))
+	waitFor(5)
+	cancel()
+	<-done
+}
+
+// timeoutMap is a fakeMap whose GetSignedMapRoot always times out.
+type timeoutMap struct {
+	*fakeMap
+	calls int
+}
+
+func (m *timeoutMap) GetSignedMapRoot(ctx context.Context, in *trillian.GetSignedMapRootRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	m.calls++
+	return nil, grpc.Errorf(codes.DeadlineExceeded, "map root timed out")
+}
+
+// sleeplessClock is a fake clock whose timers fire immediately, moving the
+// time forward by their duration. It records the durations waited for.
+type sleeplessClock struct {
+	*util.FakeTimeSource
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (c *sleeplessClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	now := c.Now().Add(d)
+	c.Set(now)
+	ch := make(chan time.Time, 1)
+	ch <- now
+	return ch
+}
+
+func (c *sleeplessClock) waited() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+func TestStartSigningMapRootTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	minInterval := time.Hour
+	s := newTestSequencer(&fakeMutation{})
+	tmap := &timeoutMap{fakeMap: newFakeMap()}
+	s.tmap = tmap
+	clock := &sleeplessClock{FakeTimeSource: util.NewFakeTimeSource(time.Unix(1000, 0))}
+	s.Clock = clock
+
+	done := make(chan error)
+	go func() {
+		done <- s.StartSigning(ctx, minInterval, 2*minInterval)
+	}()
+	// The first read is retried after 1, 2 and 4 intervals.
+	wantWaits := []time.Duration{minInterval, 2 * minInterval, 4 * minInterval}
+	for i := 0; i < 5000 && len(clock.waited()) < len(wantWaits); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("StartSigning(): %v", err)
+	}
+	if got := clock.waited(); !reflect.DeepEqual(got, wantWaits) {
+		t.Errorf("Backed off for %v, want %v", got, wantWaits)
+	}
+	// Initialize, then the first read and its retries. No epoch is
+	// attempted while backing off.
+	if got, want := tmap.calls, 5; got != want {
+		t.Errorf("GetSignedMapRoot called %v times, want %v", got, want)
+	}
+	if got := len(tmap.roots); got != 1 {
+		t.Errorf("len(roots): %v, want 1", got)
+	}
+}
+
+func TestWarmUpEpoch(t *testing.T) {
+	for _, tc := range []struct {
+		warmUp    bool
+		wantRoots int
+	}{
+		{false, 1},
+		{true, 2},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := newTestSequencer(&fakeMutation{})
+		s.WarmUpEpoch = tc.warmUp
+		tmap := s.tmap.(*fakeMap)
+		// Prevent StartSigning from forcing an epoch because maxInterval
+		// elapsed.
+		tmap.roots[0].TimestampNanos = time.Now().UnixNano()
+
+		done := make(chan error)
+		go func() {
+			done <- s.StartSigning(ctx, time.Hour, 2*time.Hour)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("StartSigning(): %v", err)
+		}
+		if got := len(tmap.roots); got != tc.wantRoots {
+			t.Errorf("WarmUpEpoch %v: len(roots): %v, want %v", tc.warmUp, got, tc.wantRoots)
+		}
+	}
+}
+
+func TestLastEpochTime(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := util.NewFakeTimeSource(now)
+	for _, tc := range []struct {
+		resp *trillian.GetSignedMapRootResponse
+		want time.Time
+	}{
+		{nil, now},
+		{&trillian.GetSignedMapRootResponse{}, now},
+		{&trillian.GetSignedMapRootResponse{MapRoot: &trillian.SignedMapRoot{TimestampNanos: 500}}, time.Unix(0, 500)},
+	} {
+		if got := lastEpochTime(tc.resp, clock); !got.Equal(tc.want) {
+			t.Errorf("lastEpochTime(%v): %v, want %v", tc.resp, got, tc.want)
+		}
+	}
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	for _, tc := range []struct {
+		gracePeriod time.Duration
+		wantRoots   int
+	}{
+		{0, 1},
+		{time.Second, 2},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		s.ShutdownGracePeriod = tc.gracePeriod
+		tmap := s.tmap.(*fakeMap)
+		// Prevent StartSigning from forcing an epoch when it starts.
+		tmap.roots[0].TimestampNanos = time.Now().UnixNano()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := s.StartSigning(ctx, time.Hour, 2*time.Hour); err != nil {
+			t.Fatalf("StartSigning(): %v", err)
+		}
+		if got, want := len(tmap.roots), tc.wantRoots; got != want {
+			t.Fatalf("ShutdownGracePeriod %v: len(map roots): %v, want %v", tc.gracePeriod, got, want)
+		}
+		if tc.wantRoots > 1 {
+			if got, want := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(), int64(3); got != want {
+				t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestSequenceRegression(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+
+	// The map server forgets the last sequenced mutations.
+	tmap.roots[1].Metadata.HighestFullyCompletedSeq = 1
+	fakeMutations.write(signedKV(4, 4)...)
+	if got, want := s.CreateEpoch(ctx, false), ErrSequenceRegression; got != want {
+		t.Errorf("CreateEpoch(): %v, want %v", got, want)
+	}
+	if _, err := s.createEpochWithMutations(ctx, signedKV(5, 5), 5, false); err != ErrSequenceRegression {
+		t.Errorf("createEpochWithMutations(): %v, want %v", err, ErrSequenceRegression)
+	}
+	if got, want := len(tmap.roots), 2; got != want {
+		t.Errorf("len(map roots): %v, want %v", got, want)
+	}
+}
+
+func TestRevisionSkip(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		skip      int
+		halt      bool
+		wantErr   error
+		wantRoots int
+		wantSkips float64
+	}{
+		{skip: 0, wantRoots: 3},
+		{skip: 3, wantRoots: 6, wantSkips: 1},
+		{skip: 3, halt: true, wantErr: ErrRevisionSkipped, wantRoots: 5, wantSkips: 1},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		s.HaltOnRevisionSkip = tc.halt
+		tmap := s.tmap.(*fakeMap)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		// Another writer advances the map behind the signer's back.
+		for i := 0; i < tc.skip; i++ {
+			if _, err := tmap.SetLeaves(ctx, &trillian.SetMapLeavesRequest{
+				MapId:      mapID,
+				MapperData: tmap.roots[1].GetMetadata(),
+			}); err != nil {
+				t.Fatalf("SetLeaves(): %v", err)
+			}
+		}
+
+		before := counterValue(t, revisionSkipCtr)
+		fakeMutations.write(signedKV(4, 4)...)
+		if got := s.CreateEpoch(ctx, false); got != tc.wantErr {
+			t.Errorf("skip %v, halt %v: CreateEpoch(): %v, want %v", tc.skip, tc.halt, got, tc.wantErr)
+		}
+		if got, want := len(tmap.roots), tc.wantRoots; got != want {
+			t.Errorf("skip %v, halt %v: len(map roots): %v, want %v", tc.skip, tc.halt, got, want)
+		}
+		if got := counterValue(t, revisionSkipCtr) - before; got != tc.wantSkips {
+			t.Errorf("skip %v, halt %v: revisionSkipCtr: %v, want %v", tc.skip, tc.halt, got, tc.wantSkips)
+		}
+	}
+}
+
+func TestEmptyEpochReason(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	for _, tc := range []struct {
+		desc   string
+		create func() error
+		reason epochReason
+	}{
+		{"ticker", func() error { return s.signEpoch(ctx, time.Second, reasonMaxInterval) }, reasonMaxInterval},
+		{"CreateEpoch", func() error { return s.CreateEpoch(ctx, true) }, reasonForced},
+	} {
+		counter := emptyEpochsCtr.WithLabelValues(tc.reason.String())
+		before := counterValue(t, counter)
+		if err := tc.create(); err != nil {
+			t.Fatalf("%v: %v", tc.desc, err)
+		}
+		if got, want := counterValue(t, counter)-before, 1.0; got != want {
+			t.Errorf("%v: kt_signer_empty_epochs_total{reason=%q}: %v, want %v", tc.desc, tc.reason, got, want)
+		}
+	}
+	if got, want := reasonMaxInterval.String(), "max_interval"; got != want {
+		t.Errorf("reasonMaxInterval: %v, want %v", got, want)
+	}
+}
+
+func TestOnEpoch(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	var epochs []int64
+	s.OnEpoch = func(ctx context.Context, resp *tpb.GetMutationsResponse) error {
+		epochs = append(epochs, resp.GetEpoch())
+		return nil
+	}
+	for i, kvs := range [][]*tpb.SignedKV{
+		signedKV(1, 3),
+		signedKV(4, 5),
+	} {
+		fakeMutations.write(kvs...)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		if got, want := len(epochs), i+1; got != want {
+			t.Fatalf("OnEpoch called %v times, want %v", got, want)
+		}
+		if got, want := epochs[i], int64(i+1); got != want {
+			t.Errorf("OnEpoch epoch: %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMutationMetadata(t *testing.T) {
+	ctx := context.Background()
+	kvs := signedKV(1, 2)
+	metadata := map[string]string{"client": "test", "submitted": "2017-01-01T00:00:00Z"}
+	kvs[0].Metadata = metadata
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(kvs...)
+	s := newTestSequencer(fakeMutations)
+	var resp *tpb.GetMutationsResponse
+	s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+		resp = r
+		return nil
+	}
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := len(resp.GetMutations()), 2; got != want {
+		t.Fatalf("len(Mutations): %v, want %v", got, want)
+	}
+	if got, want := resp.Mutations[0].GetMetadata(), metadata; !reflect.DeepEqual(got, want) {
+		t.Errorf("Mutations[0].Metadata: %v, want %v", got, want)
+	}
+	if got := resp.Mutations[1].GetMetadata(); len(got) != 0 {
+		t.Errorf("Mutations[1].Metadata: %v, want none", got)
+	}
+}
+
+func TestMutatorVersion(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	s.MutatorVersion = "entry/v2"
+	var resp *tpb.GetMutationsResponse
+	s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+		resp = r
+		return nil
+	}
+
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := resp.GetMutatorVersion(), "entry/v2"; got != want {
+		t.Errorf("MutatorVersion: %v, want %v", got, want)
+	}
+}
+
+func TestShuffledInclusions(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 4)...)
+	s := newTestSequencer(fakeMutations)
+	s.tmap = &shuffledMap{fakeMap: newFakeMap()}
+	var resp *tpb.GetMutationsResponse
+	s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+		resp = r
+		return nil
+	}
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := len(resp.GetMutations()), 4; got != want {
+		t.Fatalf("len(Mutations): %v, want %v", got, want)
+	}
+	// The mutation without an inclusion is applied to an empty leaf.
+	if p := resp.Mutations[0].GetProof(); p != nil {
+		t.Errorf("Mutations[0].Proof: %v, want nil", p)
+	}
+	if _, ok := s.tmap.(*shuffledMap).leaves["key_1"]; !ok {
+		t.Errorf("Leaf key_1 not written")
+	}
+	for i, m := range resp.Mutations[1:] {
+		if got, want := m.GetProof().GetLeaf().GetIndex(), m.GetUpdate().GetKeyValue().GetKey(); !bytes.Equal(got, want) {
+			t.Errorf("Mutations[%v].Proof index: %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestEpochSigner(t *testing.T) {
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	for _, signer := range []crypto.Signer{nil, key} {
+		s := newTestSequencer(&fakeMutation{})
+		s.EpochSigner = signer
+		var resp *tpb.GetMutationsResponse
+		s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+			resp = r
+			return nil
+		}
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+
+		if signer == nil {
+			if sig := resp.GetSmrSignature(); sig != nil {
+				t.Errorf("SmrSignature: %x, want nil", sig)
+			}
+			continue
+		}
+		leaf, err := mapRootLeaf(resp.GetSmr(), JSONLeafCodec, false)
+		if err != nil {
+			t.Fatalf("mapRootLeaf(): %v", err)
+		}
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(resp.GetSmrSignature(), &sig); err != nil {
+			t.Fatalf("asn1.Unmarshal(): %v", err)
+		}
+		digest := sha256.Sum256(leaf.LeafValue)
+		if !ecdsa.Verify(&key.PublicKey, digest[:], sig.R, sig.S) {
+			t.Errorf("SmrSignature does not verify")
+		}
+	}
+}
+
+// flakySigner fails every Sign call after the first ok calls.
+type flakySigner struct {
+	crypto.Signer
+	ok int
+}
+
+func (f *flakySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if f.ok == 0 {
+		return nil, errors.New("signer unavailable")
+	}
+	f.ok--
+	return f.Signer.Sign(rand, digest, opts)
+}
+
+func TestEpochSignerErrors(t *testing.T) {
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	for _, tc := range []struct {
+		desc      string
+		ok        int
+		wantErr   bool
+		wantRoots int
+		wantErrs  float64
+	}{
+		// The signer is checked before writing to the map.
+		{desc: "unavailable", ok: 0, wantErr: true, wantRoots: 1},
+		// The epoch has been committed when signing fails.
+		{desc: "fails after commit", ok: 1, wantRoots: 2, wantErrs: 1},
+		{desc: "available", ok: 2, wantRoots: 2},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		s.EpochSigner = &flakySigner{Signer: key, ok: tc.ok}
+		var resp *tpb.GetMutationsResponse
+		s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+			resp = r
+			return nil
+		}
+		before := counterValue(t, signMapRootErrCtr)
+		err := s.CreateEpoch(ctx, true)
+		if got := err != nil; got != tc.wantErr {
+			t.Errorf("%v: CreateEpoch(): %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if got := len(s.tmap.(*fakeMap).roots); got != tc.wantRoots {
+			t.Errorf("%v: len(roots): %v, want %v", tc.desc, got, tc.wantRoots)
+		}
+		if got := counterValue(t, signMapRootErrCtr) - before; got != tc.wantErrs {
+			t.Errorf("%v: sign errors: %v, want %v", tc.desc, got, tc.wantErrs)
+		}
+		if got, want := len(resp.GetSmrSignature()) > 0, tc.ok == 2; got != want {
+			t.Errorf("%v: SmrSignature set: %v, want %v", tc.desc, got, want)
+		}
+	}
+}
+
+func TestLastEpoch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	if rev, at := s.LastEpoch(); rev != 0 || !at.IsZero() {
+		t.Errorf("LastEpoch(): %v, %v, want 0, zero time", rev, at)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	smr := s.tmap.(*fakeMap).roots[2]
+	rev, at := s.LastEpoch()
+	if got, want := rev, int64(2); got != want {
+		t.Errorf("LastEpoch(): revision %v, want %v", got, want)
+	}
+	if got, want := at.UnixNano(), smr.GetTimestampNanos(); got != want {
+		t.Errorf("LastEpoch(): time %v, want %v", got, want)
+	}
+}
+
+func TestLeafIdentityHash(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	tlog := s.tlog.(*fakeLog)
+	// Two consecutive empty epochs.
+	for i := 0; i < 2; i++ {
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	if got, want := len(tlog.leaves), 2; got != want {
+		t.Fatalf("len(log leaves): %v, want %v", got, want)
+	}
+	if bytes.Equal(tlog.leaves[0].LeafIdentityHash, tlog.leaves[1].LeafIdentityHash) {
+		t.Errorf("LeafIdentityHash: %x, want distinct hashes", tlog.leaves[0].LeafIdentityHash)
+	}
+
+	// Roots that only differ in revision or timestamp.
+	smr := &trillian.SignedMapRoot{MapRevision: 1, TimestampNanos: 1}
+	for _, other := range []*trillian.SignedMapRoot{
+		{MapRevision: 2, TimestampNanos: 1},
+		{MapRevision: 1, TimestampNanos: 2},
+	} {
+		if bytes.Equal(leafIdentityHash(smr, nil), leafIdentityHash(other, nil)) {
+			t.Errorf("leafIdentityHash(%v) == leafIdentityHash(%v), want distinct hashes", smr, other)
+		}
+	}
+}
+
+// dedupLog deduplicates leaves by identity hash, like the Trillian log.
+type dedupLog struct {
+	*fakeLog
+}
+
+func (l dedupLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	for _, leaf := range l.leaves {
+		if bytes.Equal(leaf.LeafIdentityHash, in.Leaf.LeafIdentityHash) {
+			return &trillian.QueueLeafResponse{QueuedLeaf: &trillian.QueuedLogLeaf{Leaf: leaf}}, nil
+		}
+	}
+	l.leaves = append(l.leaves, in.Leaf)
+	return &trillian.QueueLeafResponse{QueuedLeaf: &trillian.QueuedLogLeaf{Leaf: in.Leaf}}, nil
+}
+
+func TestLeafHasherCollision(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		collide    bool
+		wantLeaves int
+		wantInSync bool
+	}{
+		{false, 3, true},
+		{true, 2, false},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		tlog := &fakeLog{}
+		s.tlog = dedupLog{tlog}
+		if tc.collide {
+			// Every epoch after the empty map root has the same hash.
+			s.LeafHasher = func(smr *trillian.SignedMapRoot, leafValue []byte) []byte {
+				if smr.GetMapRevision() == 0 {
+					return leafIdentityHash(smr, leafValue)
+				}
+				return []byte("epoch")
+			}
+		}
+		if err := s.Initialize(ctx); err != nil {
+			t.Fatalf("Initialize(): %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := s.CreateEpoch(ctx, true); err != nil {
+				t.Fatalf("CreateEpoch(): %v", err)
+			}
+		}
+		// The second epoch is dropped by the log rather than rejected.
+		if got, want := len(tlog.leaves), tc.wantLeaves; got != want {
+			t.Errorf("collide %v: len(log leaves): %v, want %v", tc.collide, got, want)
+		}
+		inSync, _, _, err := s.InSync(ctx)
+		if err != nil {
+			t.Fatalf("InSync(): %v", err)
+		}
+		if inSync != tc.wantInSync {
+			t.Errorf("collide %v: InSync(): %v, want %v", tc.collide, inSync, tc.wantInSync)
+		}
+	}
+}
+
+func TestMapRootLeaf(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	tmap := s.tmap.(*fakeMap)
+	tlog := s.tlog.(*fakeLog)
+	if got, want := len(tlog.leaves), 2; got != want {
+		t.Fatalf("len(log leaves): %v, want %v", got, want)
+	}
+	// Leaf 0 was added by Initialize and leaf 1 by CreateEpoch.
+	for i, smr := range tmap.roots {
+		want, err := mapRootLeaf(smr, JSONLeafCodec, false)
+		if err != nil {
+			t.Fatalf("mapRootLeaf(): %v", err)
+		}
+		if got := tlog.leaves[i]; !proto.Equal(got, want) {
+			t.Errorf("log leaf %v: %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestGetLeavesHist(t *testing.T) {
+	ctx := context.Background()
+	delay := 50 * time.Millisecond
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.tmap = &slowMap{fakeMap: newFakeMap(), getLeavesDelay: delay}
+
+	count, sum := histogramValue(t, getLeavesHist)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	newCount, newSum := histogramValue(t, getLeavesHist)
+	if got, want := newCount-count, uint64(1); got != want {
+		t.Errorf("getLeavesHist observations: %v, want %v", got, want)
+	}
+	if got, want := newSum-sum, delay.Seconds(); got < want {
+		t.Errorf("getLeavesHist observed %v seconds, want >= %v", got, want)
+	}
+}
+
+func TestLogProofsHist(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.AttachLogProofs = true
+
+	count, _ := histogramValue(t, logProofsHist)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	newCount, _ := histogramValue(t, logProofsHist)
+	if got, want := newCount-count, uint64(1); got != want {
+		t.Errorf("logProofsHist observations: %v, want %v", got, want)
+	}
+}
+
+func TestNewMutationsPaging(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 10)...)
+	for _, tc := range []struct {
+		failAt  int
+		wantLen int
+		wantSeq int64
+		wantErr bool
+	}{
+		{failAt: 0, wantLen: 10, wantSeq: 10},
+		{failAt: 1, wantLen: 0, wantSeq: 0, wantErr: true},
+		{failAt: 3, wantLen: 6, wantSeq: 6, wantErr: true},
+		{failAt: 4, wantLen: 9, wantSeq: 9, wantErr: true},
+	} {
+		s := newTestSequencer(fakeMutations)
+		s.mutations = &pagingMutation{fakeMutation: fakeMutations, failAt: tc.failAt}
+		s.ReadPageSize = 3
+		mutations, _, seq, err := s.newMutations(ctx, 0)
+		if got, want := err != nil, tc.wantErr; got != want {
+			t.Errorf("newMutations(failAt: %v): %v, want error %v", tc.failAt, err, want)
+		}
+		if got, want := len(mutations), tc.wantLen; got != want {
+			t.Errorf("newMutations(failAt: %v): len %v, want %v", tc.failAt, got, want)
+		}
+		if got, want := seq, tc.wantSeq; got != want {
+			t.Errorf("newMutations(failAt: %v): seq %v, want %v", tc.failAt, got, want)
+		}
+	}
+}
+
+func TestCreateEpochPartialRead(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 10)...)
+	s := newTestSequencer(fakeMutations)
+	s.mutations = &pagingMutation{fakeMutation: fakeMutations, failAt: 3}
+	s.ReadPageSize = 3
+	tmap := s.tmap.(*fakeMap)
+
+	// The first epoch contains the mutations read before the failure and
+	// the second epoch the remaining ones.
+	for i, wantSeq := range []int64{6, 10} {
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		if got := tmap.roots[i+1].GetMetadata().GetHighestFullyCompletedSeq(); got != wantSeq {
+			t.Errorf("Epoch %v: HighestFullyCompletedSeq: %v, want %v", i+1, got, wantSeq)
+		}
+	}
+}
+
+func TestEmptyEpochKeepsSequence(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+
+	// The second epoch is forced without new mutations.
+	for i := 1; i <= 2; i++ {
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		if got, want := tmap.roots[int64(i)].GetMetadata().GetHighestFullyCompletedSeq(), int64(3); got != want {
+			t.Errorf("Epoch %v: HighestFullyCompletedSeq: %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSetLeavesBytesHist(t *testing.T) {
+	ctx := context.Background()
+	valueSize := 1000
+	fakeMutations := &fakeMutation{}
+	for i := 0; i < 4; i++ {
+		fakeMutations.write(&tpb.SignedKV{
+			KeyValue: &tpb.KeyValue{
+				Key:   []byte(fmt.Sprintf("key_%v", i)),
+				Value: bytes.Repeat([]byte{byte(i)}, valueSize),
+			}})
+	}
+	s := newTestSequencer(fakeMutations)
+
+	countBefore, sumBefore := histogramValue(t, setLeavesBytesHist)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	count, sum := histogramValue(t, setLeavesBytesHist)
+	if got, want := count-countBefore, uint64(1); got != want {
+		t.Errorf("setLeavesBytesHist count: %v, want %v", got, want)
+	}
+	// Each leaf holds its value plus some framing.
+	min, max := float64(4*valueSize), float64(4*(valueSize+100))
+	if got := sum - sumBefore; got < min || got > max {
+		t.Errorf("setLeavesBytesHist sum: %v, want in [%v, %v]", got, min, max)
+	}
+}
+
+func TestPendingMutations(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 10)...)
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+	tmap.roots = append(tmap.roots, &trillian.SignedMapRoot{
+		MapRevision: 1,
+		Metadata: &trillian.MapperMetadata{
+			HighestFullyCompletedSeq: 3,
+		},
+	})
+
+	pending, err := s.PendingMutations(ctx)
+	if err != nil {
+		t.Fatalf("PendingMutations(): %v", err)
+	}
+	if got, want := pending, uint64(7); got != want {
+		t.Errorf("PendingMutations(): %v, want %v", got, want)
+	}
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := gaugeValue(t, pendingGauge), 7.0; got != want {
+		t.Errorf("pendingGauge: %v, want %v", got, want)
+	}
+	pending, err = s.PendingMutations(ctx)
+	if err != nil {
+		t.Fatalf("PendingMutations(): %v", err)
+	}
+	if got, want := pending, uint64(0); got != want {
+		t.Errorf("PendingMutations() after CreateEpoch: %v, want %v", got, want)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	ctx := context.Background()
+	var epochs []int64
+	s := New(mapID, newFakeMap(), logID, &fakeLog{}, fakeMutator{}, &fakeMutation{}, fakeFactory{}, nil, Options{
+		EpochOffset: 10,
+		OnEpoch: func(ctx context.Context, resp *tpb.GetMutationsResponse) error {
+			epochs = append(epochs, resp.GetEpoch())
+			return nil
+		},
+	})
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := epochs, []int64{11}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnEpoch epochs: %v, want %v", got, want)
+	}
+}
+
+func TestCallOptions(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	tmap := &optsMap{fakeMap: newFakeMap()}
+	tlog := &optsLog{fakeLog: &fakeLog{}}
+	callOpts := []grpc.CallOption{grpc.MaxCallRecvMsgSize(1 << 24), grpc.MaxCallSendMsgSize(1 << 24)}
+	s := New(mapID, tmap, logID, tlog, fakeMutator{}, fakeMutations, fakeFactory{}, nil, Options{}, callOpts...)
+
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	for _, calls := range []map[string][]int{tmap.calls, tlog.calls} {
+		for method, nOpts := range calls {
+			for _, n := range nOpts {
+				if got, want := n, len(callOpts); got != want {
+					t.Errorf("%v called with %v options, want %v", method, got, want)
+				}
+			}
+		}
+	}
+	for _, method := range []string{"GetSignedMapRoot", "GetLeaves", "SetLeaves"} {
+		if _, ok := tmap.calls[method]; !ok {
+			t.Errorf("%v not called", method)
+		}
+	}
+	for _, method := range []string{"GetLatestSignedLogRoot", "QueueLeaf"} {
+		if _, ok := tlog.calls[method]; !ok {
+			t.Errorf("%v not called", method)
+		}
+	}
+}
+
+func TestGetLeavesRevision(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	tmap := &advancingMap{fakeMap: newFakeMap()}
+	s.tmap = tmap
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	// GetSignedMapRoot returned revision 0 and then advanced the map.
+	if got, want := tmap.getLeavesRevisions, []int64{0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetLeaves revisions: %v, want %v", got, want)
+	}
+}
+
+func TestFetchNewLeafProofs(t *testing.T) {
+	ctx := context.Background()
+	for _, fetch := range []bool{false, true} {
+		s := newTestSequencer(&fakeMutation{})
+		tmap := &revisionMap{fakeMap: newFakeMap()}
+		s.tmap = tmap
+		s.FetchNewLeafProofs = fetch
+
+		resp, err := s.createEpochWithMutations(ctx, signedKV(1, 3), 3, false)
+		if err != nil {
+			t.Fatalf("createEpochWithMutations(): %v", err)
+		}
+		wantRevisions := []int64{0}
+		if fetch {
+			wantRevisions = append(wantRevisions, resp.GetEpoch())
+		}
+		if got := tmap.getLeavesRevisions; !reflect.DeepEqual(got, wantRevisions) {
+			t.Errorf("FetchNewLeafProofs %v: GetLeaves revisions: %v, want %v", fetch, got, wantRevisions)
+		}
+		for _, m := range resp.GetMutations() {
+			index := m.GetUpdate().GetKeyValue().GetKey()
+			if !fetch {
+				if m.GetNewProof() != nil {
+					t.Errorf("FetchNewLeafProofs %v: mutation %s has a new proof", fetch, index)
+				}
+				continue
+			}
+			leaf := m.GetNewProof().GetLeaf()
+			if got, want := leaf.GetLeafValue(), tmap.leaves[string(index)].GetLeafValue(); got == nil || !bytes.Equal(got, want) {
+				t.Errorf("FetchNewLeafProofs %v: mutation %s proves leaf %x, want %x", fetch, index, got, want)
+			}
+		}
+	}
+}
+
+func TestReplayEpochs(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	// Replayed epochs carry consistency proofs.
+	s.StrictLogConsistency = true
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	// Epoch 1 and 3 contain mutations, epoch 2 is empty.
+	wantMutations := make(map[int64]int)
+	for i, kvs := range [][]*tpb.SignedKV{signedKV(1, 2), nil, signedKV(3, 5)} {
+		fakeMutations.write(kvs...)
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		wantMutations[int64(i+1)] = len(kvs)
+	}
+
+	ch := make(chan *tpb.GetMutationsResponse, 3)
+	if err := s.ReplayEpochs(ctx, 1, ch); err != nil {
+		t.Fatalf("ReplayEpochs(): %v", err)
+	}
+	close(ch)
+	tmap := s.tmap.(*fakeMap)
+	tlog := s.tlog.(*fakeLog)
+	var epochs []int64
+	for resp := range ch {
+		epochs = append(epochs, resp.Epoch)
+		if got, want := resp.GetSmr(), tmap.roots[resp.Epoch]; !proto.Equal(got, want) {
+			t.Errorf("Epoch %v: Smr: %v, want %v", resp.Epoch, got, want)
+		}
+		if got, want := len(resp.GetMutations()), wantMutations[resp.Epoch]; got != want {
+			t.Errorf("Epoch %v: len(Mutations): %v, want %v", resp.Epoch, got, want)
+		}
+		if got, want := resp.GetLogInclusion(), [][]byte{tlog.leaves[resp.Epoch].LeafIdentityHash}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Epoch %v: LogInclusion: %x, want %x", resp.Epoch, got, want)
+		}
+		if got, want := resp.GetLogConsistency(), [][]byte{tlog.leaves[3].LeafIdentityHash}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Epoch %v: LogConsistency: %x, want %x", resp.Epoch, got, want)
+		}
+	}
+	if got, want := epochs, []int64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReplayEpochs(): epochs %v, want %v", got, want)
+	}
+}
+
+func TestMutationsForEpochInvalidIndex(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	s.IndexFunc = func(key []byte) ([]byte, error) {
+		if bytes.Equal(key, []byte("bad")) {
+			return nil, fmt.Errorf("bad key")
+		}
+		return key, nil
+	}
+	fakeMutations.write(signedKV(1, 1)...)
+	fakeMutations.write(&tpb.SignedKV{KeyValue: &tpb.KeyValue{Key: []byte("bad"), Value: []byte("value")}})
+	fakeMutations.write(signedKV(2, 2)...)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+
+	got, err := s.MutationsForEpoch(ctx, 1)
+	if err != nil {
+		t.Fatalf("MutationsForEpoch(1): %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("MutationsForEpoch(1): %v mutations, want 2", len(got))
+	}
+	for _, m := range got {
+		if m.GetProof() == nil {
+			t.Errorf("MutationsForEpoch(1): mutation for key %s has no proof", m.GetUpdate().GetKeyValue().GetKey())
+		}
+	}
+}
+
+func TestMutationsForEpoch(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	ch := make(chan *tpb.GetMutationsResponse, 2)
+	s.RegisterMutationsChannel(ch, 0)
+	// Epoch 2 updates a leaf written by epoch 1.
+	for _, kvs := range [][]*tpb.SignedKV{signedKV(1, 2), signedKV(2, 4)} {
+		fakeMutations.write(kvs...)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	<-ch
+	created := <-ch
+
+	got, err := s.MutationsForEpoch(ctx, 2)
+	if err != nil {
+		t.Fatalf("MutationsForEpoch(2): %v", err)
+	}
+	want := created.GetMutations()
+	if len(got) != len(want) {
+		t.Fatalf("MutationsForEpoch(2): %v mutations, want %v", len(got), len(want))
+	}
+	// fakeMap does not keep old revisions of leaves, so the proofs differ.
+	for i := range got {
+		if !proto.Equal(got[i].GetUpdate(), want[i].GetUpdate()) {
+			t.Errorf("MutationsForEpoch(2)[%v]: %v, want %v", i, got[i].GetUpdate(), want[i].GetUpdate())
+		}
+	}
+	if got, err := s.MutationsForEpoch(ctx, 0); err != nil || len(got) != 0 {
+		t.Errorf("MutationsForEpoch(0): %v, %v, want no mutations", got, err)
+	}
+	if _, err := s.MutationsForEpoch(ctx, 3); err == nil {
+		t.Errorf("MutationsForEpoch(3): nil, want error")
+	}
+}
+
+// gaugeValue returns the current value of g.
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+// counterValue returns the current value of c.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestAttachLogProofs(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		failProofs     bool
+		wantIncomplete bool
+	}{
+		{false, false},
+		{true, true},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		tlog := &fakeLog{}
+		s.tlog = tlog
+		if tc.failProofs {
+			s.tlog = &noProofLog{tlog}
+		}
+		s.AttachLogProofs = true
+		var resp *tpb.GetMutationsResponse
+		s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+			resp = r
+			return nil
+		}
+		if err := s.Initialize(ctx); err != nil {
+			t.Fatalf("Initialize(): %v", err)
+		}
+		before := counterValue(t, proofsIncompleteCtr)
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("failProofs %v: CreateEpoch(): %v", tc.failProofs, err)
+		}
+		// The epoch is committed either way.
+		if got, want := len(tlog.leaves), 2; got != want {
+			t.Errorf("failProofs %v: len(log leaves): %v, want %v", tc.failProofs, got, want)
+		}
+		if got, want := len(s.tmap.(*fakeMap).roots), 2; got != want {
+			t.Errorf("failProofs %v: len(map roots): %v, want %v", tc.failProofs, got, want)
+		}
+		if got := resp.GetProofsIncomplete(); got != tc.wantIncomplete {
+			t.Errorf("failProofs %v: ProofsIncomplete: %v, want %v", tc.failProofs, got, tc.wantIncomplete)
+		}
+		if got, want := resp.GetLogRoot() == nil, tc.wantIncomplete; got != want {
+			t.Errorf("failProofs %v: LogRoot: %v, want nil: %v", tc.failProofs, resp.GetLogRoot(), want)
+		}
+		if got, want := len(resp.GetLogInclusion()) == 0, tc.wantIncomplete; got != want {
+			t.Errorf("failProofs %v: LogInclusion: %x, want empty: %v", tc.failProofs, resp.GetLogInclusion(), want)
+		}
+		wantCtr := 0.0
+		if tc.wantIncomplete {
+			wantCtr = 1
+		}
+		if got := counterValue(t, proofsIncompleteCtr) - before; got != wantCtr {
+			t.Errorf("failProofs %v: proofsIncompleteCtr: %v, want %v", tc.failProofs, got, wantCtr)
+		}
+	}
+}
+
+func TestAttachLogProofsNotIntegrated(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	tlog := &fakeLog{}
+	s.tlog = tlog
+	s.AttachLogProofs = true
+	var resp *tpb.GetMutationsResponse
+	s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+		resp = r
+		return nil
+	}
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	s.tlog = &laggingLog{tlog}
+	before := counterValue(t, proofsIncompleteCtr)
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got := resp.GetLogInclusion(); len(got) != 0 {
+		t.Errorf("LogInclusion: %x, want empty", got)
+	}
+	if !resp.GetProofsIncomplete() {
+		t.Errorf("ProofsIncomplete: false, want true")
+	}
+	if got, want := counterValue(t, proofsIncompleteCtr)-before, 1.0; got != want {
+		t.Errorf("proofsIncompleteCtr: %v, want %v", got, want)
+	}
+
+	// Replayed epochs are marked as well.
+	ch := make(chan *tpb.GetMutationsResponse, 1)
+	if err := s.ReplayEpochs(ctx, 1, ch); err != nil {
+		t.Fatalf("ReplayEpochs(): %v", err)
+	}
+	if got := <-ch; !got.GetProofsIncomplete() {
+		t.Errorf("ReplayEpochs(): ProofsIncomplete: false, want true")
+	}
+}
+
+func TestEpochOffset(t *testing.T) {
+	ctx := context.Background()
+	mapKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	s := newTestSequencer(&fakeMutation{})
+	s.tmap.(*fakeMap).signer = tcrypto.NewSHA256Signer(mapKey)
+	tlog := s.tlog.(*fakeLog)
+	s.EpochOffset = 1000
+	s.AttachLogProofs = true
+	var resps []*tpb.GetMutationsResponse
+	s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+		resps = append(resps, r)
+		return nil
+	}
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	for i, resp := range resps {
+		revision := int64(i + 1)
+		if got, want := resp.GetEpoch(), 1000+revision; got != want {
+			t.Errorf("resp[%v].Epoch: %v, want %v", i, got, want)
+		}
+		if got, want := resp.GetSmr().GetMapRevision(), revision; got != want {
+			t.Errorf("resp[%v].Smr.MapRevision: %v, want %v", i, got, want)
+		}
+		// The log is still indexed by map revision.
+		if got, want := resp.GetLogInclusion(), [][]byte{tlog.leaves[revision].LeafIdentityHash}; !reflect.DeepEqual(got, want) {
+			t.Errorf("resp[%v].LogInclusion: %x, want %x", i, got, want)
+		}
+		if err := VerifyResponse(resp, mapKey.Public(), nil, nil, nil, VerifyOptions{EpochOffset: s.EpochOffset}); err != nil {
+			t.Errorf("VerifyResponse(resp[%v]): %v", i, err)
+		}
+	}
+
+	ch := make(chan *tpb.GetMutationsResponse, 2)
+	if err := s.ReplayEpochs(ctx, 1001, ch); err != nil {
+		t.Fatalf("ReplayEpochs(1001): %v", err)
+	}
+	close(ch)
+	var replayed []int64
+	for resp := range ch {
+		replayed = append(replayed, resp.GetEpoch())
+	}
+	if want := []int64{1001, 1002}; !reflect.DeepEqual(replayed, want) {
+		t.Errorf("ReplayEpochs(1001): epochs %v, want %v", replayed, want)
+	}
+	if err := s.ReplayEpochs(ctx, 999, ch); err == nil {
+		t.Errorf("ReplayEpochs(999): nil, want error")
+	}
+	_, _, inclusion, err := s.LogProofs(ctx, 0, 1002)
+	if err != nil {
+		t.Fatalf("LogProofs(1002): %v", err)
+	}
+	if got, want := inclusion.GetProof().GetLeafIndex(), int64(2); got != want {
+		t.Errorf("LogProofs(1002): LeafIndex %v, want %v", got, want)
+	}
+}
+
+func TestLogProofs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	tlog := &proofLog{fakeLog: &fakeLog{}}
+	s.tlog = tlog
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := s.CreateEpoch(ctx, true); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+
+	_, consistency, inclusion, err := s.LogProofs(ctx, 3, 2)
+	if err != nil {
+		t.Fatalf("LogProofs(): %v", err)
+	}
+	if consistency == nil || inclusion == nil {
+		t.Fatalf("LogProofs(): consistency: %v, inclusion: %v, want both", consistency, inclusion)
+	}
+	if got, want := len(tlog.inclusionReqs), 1; got != want {
+		t.Fatalf("GetInclusionProof calls: %v, want %v", got, want)
+	}
+	if got, want := tlog.inclusionReqs[0].GetLeafIndex(), int64(2); got != want {
+		t.Errorf("GetInclusionProof LeafIndex: %v, want %v", got, want)
+	}
+	if got, want := tlog.inclusionReqs[0].GetTreeSize(), int64(5); got != want {
+		t.Errorf("GetInclusionProof TreeSize: %v, want %v", got, want)
+	}
+	if got, want := len(tlog.consistencyReqs), 1; got != want {
+		t.Fatalf("GetConsistencyProof calls: %v, want %v", got, want)
+	}
+	if got, want := tlog.consistencyReqs[0].GetFirstTreeSize(), int64(3); got != want {
+		t.Errorf("GetConsistencyProof FirstTreeSize: %v, want %v", got, want)
+	}
+	if got, want := tlog.consistencyReqs[0].GetSecondTreeSize(), int64(5); got != want {
+		t.Errorf("GetConsistencyProof SecondTreeSize: %v, want %v", got, want)
+	}
+}
+
+func TestSaturated(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 10)...)
+	s := newTestSequencer(fakeMutations)
+	s.PendingHighWater = 5
+	tmap := &slowMap{fakeMap: newFakeMap(), setLeavesDelay: time.Second}
+	s.tmap = tmap
+
+	if s.Saturated() {
+		t.Errorf("Saturated() before the first epoch: true, want false")
+	}
+	// The epoch times out while writing the map, leaving all 10 mutations
+	// pending.
+	ctxTime, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	err := s.CreateEpoch(ctxTime, false)
+	cancel()
+	if err == nil {
+		t.Fatalf("CreateEpoch(): nil, want error")
+	}
+	if !s.Saturated() {
+		t.Errorf("Saturated() with 10 pending mutations: false, want true")
+	}
+
+	// Draining the queue clears saturation.
+	s.tmap = tmap.fakeMap
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if s.Saturated() {
+		t.Errorf("Saturated() after draining: true, want false")
+	}
+}
+
+func TestStrictLogConsistency(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		strict        bool
+		emptyProofs   bool
+		firstTreeSize int64
+		epoch         int64
+		want          error
+	}{
+		{strict: false, firstTreeSize: 0, epoch: 2},
+		{strict: true, firstTreeSize: 0, epoch: 2, want: ErrMissingConsistencyProof},
+		{strict: true, firstTreeSize: 0, epoch: 1},
+		{strict: true, firstTreeSize: 2, epoch: 2},
+		{strict: true, firstTreeSize: 4, epoch: 3},
+		{strict: false, emptyProofs: true, firstTreeSize: 2, epoch: 2},
+		{strict: true, emptyProofs: true, firstTreeSize: 2, epoch: 2, want: ErrMissingConsistencyProof},
+		// A log is consistent with itself without proof.
+		{strict: true, emptyProofs: true, firstTreeSize: 4, epoch: 3},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		s.StrictLogConsistency = tc.strict
+		if tc.emptyProofs {
+			s.tlog = &emptyConsistencyLog{fakeLog: &fakeLog{}}
+		}
+		if err := s.Initialize(ctx); err != nil {
+			t.Fatalf("Initialize(): %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := s.CreateEpoch(ctx, true); err != nil {
+				t.Fatalf("CreateEpoch(): %v", err)
+			}
+		}
+		if _, _, _, err := s.LogProofs(ctx, tc.firstTreeSize, tc.epoch); err != tc.want {
+			t.Errorf("LogProofs(strict: %v, empty proofs: %v, %v, %v): %v, want %v",
+				tc.strict, tc.emptyProofs, tc.firstTreeSize, tc.epoch, err, tc.want)
+		}
+	}
+}
+
+func TestCreateEpochZeroRevision(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.tmap = &zeroRevisionMap{fakeMap: newFakeMap()}
+	tlog := &proofLog{fakeLog: &fakeLog{}}
+	s.tlog = tlog
+	s.LogIntegrationTimeout = time.Second
+
+	if err := s.CreateEpoch(ctx, false); err == nil {
+		t.Errorf("CreateEpoch(): nil, want error")
+	}
+	if got := len(tlog.leaves); got != 0 {
+		t.Errorf("len(log leaves): %v, want 0", got)
+	}
+	if got := len(tlog.inclusionReqs); got != 0 {
+		t.Errorf("GetInclusionProof calls: %v, want 0", got)
+	}
+	if _, _, _, err := s.LogProofs(ctx, 0, -1); err == nil {
+		t.Errorf("LogProofs(epoch: -1): nil, want error")
+	}
+}
+
+func TestEpochTimeout(t *testing.T) {
+	minInterval := 10 * time.Millisecond
+	for _, tc := range []struct {
+		epochTimeout time.Duration
+		success      bool
+	}{
+		{0, false}, // Defaults to minInterval.
+		{time.Second, true},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		s.tmap = &slowMap{fakeMap: newFakeMap(), setLeavesDelay: 5 * minInterval}
+		s.EpochTimeout = tc.epochTimeout
+
+		ctx, cancel := s.epochContext(context.Background(), minInterval)
+		err := s.CreateEpoch(ctx, false)
+		cancel()
+		if got, want := err == nil, tc.success; got != want {
+			t.Errorf("CreateEpoch(EpochTimeout: %v): %v, want success %v", tc.epochTimeout, err, want)
+		}
+	}
+}
+
+func TestMirrorLog(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		fail       bool
+		bestEffort bool
+	}{
+		{},
+		{fail: true, bestEffort: true},
+		{fail: true},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		tlog := s.tlog.(*fakeLog)
+		mirror := &fakeLog{}
+		s.MirrorLog = mirror
+		if tc.fail {
+			s.MirrorLog = &failingLog{fakeLog: mirror}
+		}
+		s.MirrorLogID = logID + 1
+		s.MirrorBestEffort = tc.bestEffort
+
+		before := counterValue(t, mirrorErrCtr)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Errorf("CreateEpoch(fail: %v, bestEffort: %v): %v", tc.fail, tc.bestEffort, err)
+		}
+		if got, want := len(tlog.leaves), 1; got != want {
+			t.Errorf("CreateEpoch(fail: %v, bestEffort: %v): %v log leaves, want %v", tc.fail, tc.bestEffort, got, want)
+		}
+		wantMirror := 1
+		if tc.fail {
+			wantMirror = 0
+		}
+		if got := len(mirror.leaves); got != wantMirror {
+			t.Errorf("CreateEpoch(fail: %v, bestEffort: %v): %v mirror leaves, want %v", tc.fail, tc.bestEffort, got, wantMirror)
+		}
+		if wantMirror == 1 && !proto.Equal(mirror.leaves[0], tlog.leaves[0]) {
+			t.Errorf("mirror leaf: %v, want %v", mirror.leaves[0], tlog.leaves[0])
+		}
+		wantErrs := 0.0
+		if tc.fail {
+			wantErrs = 1
+		}
+		if got := counterValue(t, mirrorErrCtr) - before; got != wantErrs {
+			t.Errorf("mirrorErrCtr: %v, want %v", got, wantErrs)
+		}
+	}
+}
+
+func TestMirrorLogBackfill(t *testing.T) {
+	ctx := context.Background()
+	for _, bestEffort := range []bool{false, true} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 1)...)
+		s := newTestSequencer(fakeMutations)
+		tlog := s.tlog.(*fakeLog)
+		mirror := &fakeLog{}
+		s.MirrorLog = &failingLog{fakeLog: mirror}
+		s.MirrorLogID = logID + 1
+		s.MirrorBestEffort = bestEffort
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+
+		// The mirror recovers.
+		s.MirrorLog = mirror
+		fakeMutations.write(signedKV(2, 2)...)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		want := tlog.leaves
+		if bestEffort {
+			want = tlog.leaves[1:]
+		}
+		if got := len(mirror.leaves); got != len(want) {
+			t.Fatalf("bestEffort: %v: %v mirror leaves, want %v", bestEffort, got, len(want))
+		}
+		for i := range want {
+			if !proto.Equal(mirror.leaves[i], want[i]) {
+				t.Errorf("bestEffort: %v: mirror leaf %v: %v, want %v", bestEffort, i, mirror.leaves[i], want[i])
+			}
+		}
+	}
+}
+
+func TestLogIntegrationTimeout(t *testing.T) {
+	ctx := context.Background()
+	delay := 4 * logPollInterval
+	for _, tc := range []struct {
+		timeout      time.Duration
+		wantTimeouts float64
+		wantBlock    bool
+	}{
+		{timeout: 0},
+		{timeout: 10 * delay, wantBlock: true},
+		// The epoch is committed regardless.
+		{timeout: delay / 4, wantTimeouts: 1},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		tlog := &delayedLog{
+			fakeLog: &fakeLog{leaves: []*trillian.LogLeaf{{}}},
+			delay:   delay,
+		}
+		s.tlog = tlog
+		s.LogIntegrationTimeout = tc.timeout
+
+		before := counterValue(t, logIntegrationTimeoutCtr)
+		start := time.Now()
+		err := s.CreateEpoch(ctx, false)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Errorf("CreateEpoch(LogIntegrationTimeout: %v): %v", tc.timeout, err)
+		}
+		if got := counterValue(t, logIntegrationTimeoutCtr) - before; got != tc.wantTimeouts {
+			t.Errorf("CreateEpoch(LogIntegrationTimeout: %v): %v timeouts, want %v", tc.timeout, got, tc.wantTimeouts)
+		}
+		if got, want := elapsed >= delay, tc.wantBlock; got != want {
+			t.Errorf("CreateEpoch(LogIntegrationTimeout: %v) took %v, want blocked until integration %v", tc.timeout, elapsed, want)
+		}
+		if tc.wantBlock {
+			if got, want := len(tlog.leaves), 2; got != want {
+				t.Errorf("len(log leaves): %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestNewRegistries(t *testing.T) {
+	regs := []*prometheus.Registry{prometheus.NewRegistry(), prometheus.NewRegistry()}
+	for _, reg := range regs {
+		// Creating several signers on the same registry must not panic.
+		for i := 0; i < 2; i++ {
+			New(mapID, newFakeMap(), logID, &fakeLog{}, fakeMutator{}, &fakeMutation{}, fakeFactory{}, reg, Options{})
+		}
+	}
+	for i, reg := range regs {
+		for _, c := range collectors {
+			if _, ok := reg.Register(c).(prometheus.AlreadyRegisteredError); !ok {
+				t.Errorf("registry %v: collector %v was not registered", i, c)
+			}
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	buckets := []float64{1e-9, 60}
+	s := newSequencer(mapID, newFakeMap(), logID, &fakeLog{}, fakeMutator{}, fakeMutations, fakeFactory{},
+		prometheus.NewRegistry(), HistogramBuckets{MapUpdate: buckets, CreateEpoch: buckets}, Options{})
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	for name, h := range map[string]prometheus.Histogram{
+		"mapUpdateHist":   s.mapUpdateHist,
+		"createEpochHist": s.createEpochHist,
+	} {
+		var m dto.Metric
+		if err := h.Write(&m); err != nil {
+			t.Fatalf("Write(): %v", err)
+		}
+		got := m.GetHistogram().GetBucket()
+		if len(got) != len(buckets) {
+			t.Fatalf("%v: %v buckets, want %v", name, len(got), len(buckets))
+		}
+		// Bucket counts are cumulative: the observation is above the
+		// first bound and below the second.
+		for i, want := range []uint64{0, 1} {
+			if got[i].GetUpperBound() != buckets[i] || got[i].GetCumulativeCount() != want {
+				t.Errorf("%v: bucket %v: %v, want upper bound %v and count %v", name, i, got[i], buckets[i], want)
+			}
+		}
+	}
+}
+
+// histogramValue returns the sample count and sum of h.
+func histogramValue(t *testing.T, h prometheus.Histogram) (uint64, float64) {
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestCreateEpochWithMutations(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	tmap := s.tmap.(*fakeMap)
+
+	batch := signedKV(1, 3)
+	resp, err := s.createEpochWithMutations(ctx, batch, 3, false)
+	if err != nil {
+		t.Fatalf("createEpochWithMutations(): %v", err)
+	}
+	if got, want := resp.GetEpoch(), int64(1); got != want {
+		t.Errorf("Epoch: %v, want %v", got, want)
+	}
+	if got, want := len(resp.GetMutations()), len(batch); got != want {
+		t.Errorf("len(Mutations): %v, want %v", got, want)
+	}
+	for _, m := range batch {
+		leaf, ok := tmap.leaves[string(m.GetKeyValue().GetKey())]
+		if !ok {
+			t.Errorf("Leaf %s was not written", m.GetKeyValue().GetKey())
+			continue
+		}
+		var e tpb.Entry
+		if err := proto.Unmarshal(leaf.GetLeafValue(), &e); err != nil {
+			t.Fatalf("Unmarshal(): %v", err)
+		}
+		if got, want := e.GetCommitment(), m.GetKeyValue().GetValue(); !bytes.Equal(got, want) {
+			t.Errorf("Leaf %s: %s, want %s", m.GetKeyValue().GetKey(), got, want)
+		}
+	}
+	if got, want := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(), int64(3); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+	if got, want := len(s.tlog.(*fakeLog).leaves), 1; got != want {
+		t.Errorf("len(log leaves): %v, want %v", got, want)
+	}
+
+	// The sequence number may not move backwards.
+	if _, err := s.createEpochWithMutations(ctx, signedKV(4, 4), 2, false); err == nil {
+		t.Errorf("createEpochWithMutations(seq: 2): nil, want error")
+	}
+}
+
+func TestMutationsPerEpochHist(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	count, sum := histogramValue(t, mutationsPerEpochHist)
+	seq := 0
+	for _, size := range []int{1, 10, 100} {
+		seq += size
+		if _, err := s.createEpochWithMutations(ctx, signedKV(seq-size+1, seq), int64(seq), false); err != nil {
+			t.Fatalf("createEpochWithMutations(%v mutations): %v", size, err)
+		}
+	}
+	newCount, newSum := histogramValue(t, mutationsPerEpochHist)
+	if got, want := newCount-count, uint64(3); got != want {
+		t.Errorf("mutationsPerEpochHist observations: %v, want %v", got, want)
+	}
+	if got, want := newSum-sum, 111.0; got != want {
+		t.Errorf("mutationsPerEpochHist sum: %v, want %v", got, want)
+	}
+}
+
+func TestCreateEpochFromRange(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 10)...)
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+
+	resp, err := s.CreateEpochFromRange(ctx, 3, 6)
+	if err != nil {
+		t.Fatalf("CreateEpochFromRange(): %v", err)
+	}
+	if got, want := len(resp.GetMutations()), 3; got != want {
+		t.Fatalf("len(resp.Mutations): %v, want %v", got, want)
+	}
+	for i, m := range resp.GetMutations() {
+		if got, want := m.GetUpdate(), fakeMutations.mtns[3+i]; got != want {
+			t.Errorf("resp.Mutations[%v]: %v, want %v", i, got, want)
+		}
+	}
+	if got, want := len(tmap.leaves), 3; got != want {
+		t.Errorf("len(map leaves): %v, want %v", got, want)
+	}
+	for _, key := range []string{"key_4", "key_5", "key_6"} {
+		if _, ok := tmap.leaves[key]; !ok {
+			t.Errorf("map leaf %v not set", key)
+		}
+	}
+	// Mutations 1 to 3 have not been sequenced yet.
+	if got, want := resp.GetSmr().GetMetadata().GetHighestFullyCompletedSeq(), int64(0); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+
+	// Replaying an old range must not move the sequence number backwards.
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	resp, err = s.CreateEpochFromRange(ctx, 0, 2)
+	if err != nil {
+		t.Fatalf("CreateEpochFromRange(): %v", err)
+	}
+	if got, want := len(resp.GetMutations()), 2; got != want {
+		t.Errorf("len(resp.Mutations): %v, want %v", got, want)
+	}
+	if got, want := resp.GetSmr().GetMetadata().GetHighestFullyCompletedSeq(), int64(10); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+}
+
+func TestCreateEpochFromRangeGap(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 6)...)
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+
+	// Mutations 1 to 3 have not been sequenced yet.
+	resp, err := s.CreateEpochFromRange(ctx, 3, 6)
+	if err != nil {
+		t.Fatalf("CreateEpochFromRange(): %v", err)
+	}
+	if got, want := resp.GetSmr().GetMetadata().GetHighestFullyCompletedSeq(), int64(0); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	for _, key := range []string{"key_1", "key_2", "key_3"} {
+		if _, ok := tmap.leaves[key]; !ok {
+			t.Errorf("map leaf %v not set", key)
+		}
+	}
+	if got, want := tmap.roots[2].GetMetadata().GetHighestFullyCompletedSeq(), int64(6); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+}
+
+func TestCreateEpochFromRangeRateLimit(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 2)...)
+	s := newTestSequencer(fakeMutations)
+	s.MaxEpochsPerWindow = 1
+	s.EpochRateWindow = time.Hour
+
+	before := counterValue(t, epochsCtr.WithLabelValues(outcomeSuccess))
+	if _, err := s.CreateEpochFromRange(ctx, 0, 2); err != nil {
+		t.Fatalf("CreateEpochFromRange(): %v", err)
+	}
+	if got, want := counterValue(t, epochsCtr.WithLabelValues(outcomeSuccess))-before, 1.0; got != want {
+		t.Errorf("successful epochs: %v, want %v", got, want)
+	}
+	if _, err := s.CreateEpochFromRange(ctx, 0, 2); err != ErrRateLimited {
+		t.Errorf("CreateEpochFromRange(): %v, want %v", err, ErrRateLimited)
+	}
+}
+
+// newTestSequencer returns a sequencer backed by fake trillian clients and
+// the given mutation store.
+func newTestSequencer(mutations *fakeMutation) *sequencer {
+	return newSequencer(mapID, newFakeMap(), logID, &fakeLog{}, fakeMutator{}, mutations, fakeFactory{}, nil, HistogramBuckets{}, Options{})
+}
+
+func TestApplyMutationsOrder(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	mutations := signedKV(1, 20)
+	// Shuffle the mutations so that the input order does not match the
+	// index order.
+	r := rand.New(rand.NewSource(1))
+	for i := range mutations {
+		j := r.Intn(i + 1)
+		mutations[i], mutations[j] = mutations[j], mutations[i]
+	}
+	first, err := s.applyMutations(ctx, mutations, nil)
+	if err != nil {
+		t.Fatalf("applyMutations(): %v", err)
+	}
+	second, err := s.applyMutations(ctx, mutations, nil)
+	if err != nil {
+		t.Fatalf("applyMutations(): %v", err)
+	}
+	if got, want := len(first), len(mutations); got != want {
+		t.Fatalf("len(applyMutations()): %v, want %v", got, want)
+	}
+	for i := range first {
+		if !bytes.Equal(first[i].Index, second[i].Index) {
+			t.Errorf("applyMutations()[%v]: %s, then %s", i, first[i].Index, second[i].Index)
+		}
+		if i > 0 && bytes.Compare(first[i-1].Index, first[i].Index) >= 0 {
+			t.Errorf("applyMutations(): %s before %s", first[i-1].Index, first[i].Index)
+		}
+	}
+}
+
+func TestIndexConflicts(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	batch := append(signedKV(1, 3), signedKV(2, 2)...)
+	batch[3].KeyValue.Value = []byte("value_2b")
+
+	indexes, err := s.leafIndexes(batch)
+	if err != nil {
+		t.Fatalf("leafIndexes(): %v", err)
+	}
+	if got, want := indexConflicts(indexes, defaultIndexSize), [][]byte{[]byte("key_2")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indexConflicts(): %s, want %s", got, want)
+	}
+	before := counterValue(t, conflictsCtr)
+	if _, err := s.createEpochWithMutations(ctx, batch, int64(len(batch)), false); err != nil {
+		t.Fatalf("createEpochWithMutations(): %v", err)
+	}
+	if got, want := counterValue(t, conflictsCtr)-before, 1.0; got != want {
+		t.Errorf("conflictsCtr: %v, want %v", got, want)
+	}
+}
+
+func TestNoopMutations(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+
+	fakeMutations.write(signedKV(1, 3)...)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	// Write the same values again.
+	fakeMutations.write(signedKV(1, 3)...)
+	before := counterValue(t, noopCtr)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := len(tmap.roots), 2; got != want {
+		t.Errorf("len(map roots): %v, want %v", got, want)
+	}
+	if got, want := counterValue(t, noopCtr)-before, 3.0; got != want {
+		t.Errorf("noopCtr: %v, want %v", got, want)
+	}
+	// The no-op mutations are not read again.
+	before = counterValue(t, noopCtr)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got := counterValue(t, noopCtr) - before; got != 0 {
+		t.Errorf("noopCtr: %v, want 0", got)
+	}
+	// Forced epochs are created regardless.
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := len(tmap.roots), 3; got != want {
+		t.Errorf("len(map roots): %v, want %v", got, want)
+	}
+}
+
+func TestApplyMutationsSequenceOrder(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+	fakeMutations.write(signedKV(1, 2)...)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+
+	// key_1 is updated twice, key_2 once.
+	updates := signedKV(1, 2)
+	updates[0].KeyValue.Value = []byte("value_1a")
+	updates[1].KeyValue.Value = []byte("value_2a")
+	last := signedKV(1, 1)
+	last[0].KeyValue.Value = []byte("value_1b")
+	fakeMutations.write(updates[0], updates[1], last[0])
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	for _, tc := range []struct {
+		key, want string
+	}{
+		{"key_1", "value_1b"},
+		{"key_2", "value_2a"},
+	} {
+		var e tpb.Entry
+		if err := proto.Unmarshal(tmap.leaves[tc.key].GetLeafValue(), &e); err != nil {
+			t.Fatalf("Unmarshal(): %v", err)
+		}
+		if got := string(e.GetCommitment()); got != tc.want {
+			t.Errorf("Leaf %v: %v, want %v", tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestRevertingMutation(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+	fakeMutations.write(signedKV(1, 1)...)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	want := tmap.leaves["key_1"].GetLeafValue()
+
+	// key_1 is updated and then set back to its value in the map.
+	update := signedKV(1, 1)
+	update[0].KeyValue.Value = []byte("value_1a")
+	fakeMutations.write(update[0], signedKV(1, 1)[0])
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got := tmap.leaves["key_1"].GetLeafValue(); !bytes.Equal(got, want) {
+		t.Errorf("Leaf key_1: %x, want %x", got, want)
+	}
+}
+
+// shuffledMutation is a fakeMutation that returns mutations in reverse
+// sequence order.
+type shuffledMutation struct {
+	*fakeMutation
+}
+
+func (m shuffledMutation) ReadRange(txn transaction.Txn, startSequence, endSequence uint64, count int32) (uint64, []*tpb.SignedKV, error) {
+	maxSequence, kvs, err := m.fakeMutation.ReadRange(txn, startSequence, endSequence, count)
+	reversed := make([]*tpb.SignedKV, 0, len(kvs))
+	for i := len(kvs) - 1; i >= 0; i-- {
+		reversed = append(reversed, kvs[i])
+	}
+	return maxSequence, reversed, err
+}
+
+func (m shuffledMutation) ReadQueued(txn transaction.Txn, startSequence, endSequence uint64, count int32) ([]*mutator.QueuedMutation, error) {
+	_, kvs, err := m.fakeMutation.ReadRange(txn, startSequence, endSequence, count)
+	if err != nil {
+		return nil, err
+	}
+	queued := make([]*mutator.QueuedMutation, 0, len(kvs))
+	for i := len(kvs) - 1; i >= 0; i-- {
+		queued = append(queued, &mutator.QueuedMutation{
+			Sequence: startSequence + uint64(i) + 1,
+			Mutation: kvs[i],
+		})
+	}
+	return queued, nil
+}
+
+func TestApplyMutationsOutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	// key_1 is updated by sequence numbers 1 and 3, key_2 by 2.
+	fakeMutations.write(
+		signedMutation([]byte("key_1"), []byte("value_1a"), []byte("sig")),
+		signedMutation([]byte("key_2"), []byte("value_2"), []byte("sig")),
+		signedMutation([]byte("key_1"), []byte("value_1b"), []byte("sig")),
+	)
+	s := newSequencer(mapID, newFakeMap(), logID, &fakeLog{}, fakeMutator{}, shuffledMutation{fakeMutations}, fakeFactory{}, nil, HistogramBuckets{}, Options{})
+	tmap := s.tmap.(*fakeMap)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(), int64(3); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+	var e tpb.Entry
+	if err := proto.Unmarshal(tmap.leaves["key_1"].GetLeafValue(), &e); err != nil {
+		t.Fatalf("Unmarshal(): %v", err)
+	}
+	if got, want := string(e.GetCommitment()), "value_1b"; got != want {
+		t.Errorf("Leaf key_1: %v, want %v", got, want)
+	}
+}
+
+func TestMaxLeafValueBytes(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	s.MaxLeafValueBytes = 100
+	tmap := s.tmap.(*fakeMap)
+
+	mutations := signedKV(1, 3)
+	mutations[1].KeyValue.Value = make([]byte, 1000)
+	fakeMutations.write(mutations...)
+	before := counterValue(t, oversizedCtr)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := counterValue(t, oversizedCtr)-before, 1.0; got != want {
+		t.Errorf("oversizedCtr: %v, want %v", got, want)
+	}
+	if _, ok := tmap.leaves["key_2"]; ok {
+		t.Errorf("Oversized leaf key_2 was written")
+	}
+	if got, want := len(tmap.leaves), 2; got != want {
+		t.Errorf("len(leaves): %v, want %v", got, want)
+	}
+	if got, want := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(), int64(3); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+}
+
+func TestMutateContext(t *testing.T) {
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.mutator = blockingMutator{}
+	tmap := s.tmap.(*fakeMap)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- s.CreateEpoch(ctx, false)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("CreateEpoch(): nil, want error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CreateEpoch() did not return after its context was done")
+	}
+	if got, want := len(tmap.roots), 1; got != want {
+		t.Errorf("len(roots): %v, want %v", got, want)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	ctx := context.Background()
+	var hashes [][]byte
+	for i := 0; i < 2; i++ {
+		s := newTestSequencer(&fakeMutation{})
+		leaves, err := s.applyMutations(ctx, signedKV(1, 5), nil)
+		if err != nil {
+			t.Fatalf("applyMutations(): %v", err)
+		}
+		hashes = append(hashes, contentHash(leaves, 5))
+	}
+	if !bytes.Equal(hashes[0], hashes[1]) {
+		t.Errorf("contentHash(): %x and %x, want equal", hashes[0], hashes[1])
+	}
+
+	s := newTestSequencer(&fakeMutation{})
+	leaves, err := s.applyMutations(ctx, signedKV(1, 5), nil)
+	if err != nil {
+		t.Fatalf("applyMutations(): %v", err)
+	}
+	reversed := make([]*trillian.MapLeaf, 0, len(leaves))
+	for i := len(leaves) - 1; i >= 0; i-- {
+		reversed = append(reversed, leaves[i])
+	}
+	if got := contentHash(reversed, 5); !bytes.Equal(got, hashes[0]) {
+		t.Errorf("contentHash(reversed leaves): %x, want %x", got, hashes[0])
+	}
+	if got := contentHash(leaves, 6); bytes.Equal(got, hashes[0]) {
+		t.Errorf("contentHash(seq: 6) = contentHash(seq: 5)")
+	}
+	if got := contentHash(leaves[1:], 5); bytes.Equal(got, hashes[0]) {
+		t.Errorf("contentHash(fewer leaves) = contentHash(all leaves)")
+	}
+
+	// The content hash of an epoch does not depend on the previous epochs.
+	resps := make([]*tpb.GetMutationsResponse, 0, 2)
+	for _, history := range []bool{false, true} {
+		s := newTestSequencer(&fakeMutation{})
+		if history {
+			if _, err := s.createEpochWithMutations(ctx, signedKV(6, 7), 0, false); err != nil {
+				t.Fatalf("createEpochWithMutations(): %v", err)
+			}
+		}
+		resp, err := s.createEpochWithMutations(ctx, signedKV(1, 5), 5, false)
+		if err != nil {
+			t.Fatalf("createEpochWithMutations(): %v", err)
+		}
+		resps = append(resps, resp)
+	}
+	if got, want := resps[1].GetContentHash(), resps[0].GetContentHash(); len(got) == 0 || !bytes.Equal(got, want) {
+		t.Errorf("ContentHash: %x, want %x", got, want)
+	}
+	if got, want := resps[0].GetContentHash(), hashes[0]; !bytes.Equal(got, want) {
+		t.Errorf("ContentHash: %x, want %x", got, want)
+	}
+}
+
+func TestLeafTransform(t *testing.T) {
+	ctx := context.Background()
+	flip := func(leaves []*trillian.MapLeaf) ([]*trillian.MapLeaf, error) {
+		for _, l := range leaves {
+			l.LeafValue[0] ^= 0xff
+		}
+		return leaves, nil
+	}
+	fail := func(leaves []*trillian.MapLeaf) ([]*trillian.MapLeaf, error) {
+		return nil, fmt.Errorf("transform failed")
+	}
+	// The leaves written without a transform.
+	baseMutations := &fakeMutation{}
+	baseMutations.write(signedKV(1, 3)...)
+	base := newTestSequencer(baseMutations)
+	if err := base.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	baseLeaves := base.tmap.(*fakeMap).leaves
+
+	for _, tc := range []struct {
+		transform func([]*trillian.MapLeaf) ([]*trillian.MapLeaf, error)
+		wantErr   bool
+	}{
+		{transform: flip},
+		{transform: fail, wantErr: true},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		s.LeafTransform = tc.transform
+		tmap := s.tmap.(*fakeMap)
+
+		err := s.CreateEpoch(ctx, false)
+		if got, want := err != nil, tc.wantErr; got != want {
+			t.Errorf("CreateEpoch(): %v, want err %v", err, want)
+		}
+		if tc.wantErr {
+			if got, want := len(tmap.roots), 1; got != want {
+				t.Errorf("len(roots): %v, want %v", got, want)
+			}
+			continue
+		}
+		if got, want := len(tmap.leaves), 3; got != want {
+			t.Errorf("len(leaves): %v, want %v", got, want)
+		}
+		for k, l := range baseLeaves {
+			want := append([]byte(nil), l.GetLeafValue()...)
+			want[0] ^= 0xff
+			if got := tmap.leaves[k].GetLeafValue(); !bytes.Equal(got, want) {
+				t.Errorf("leaf %v: %x, want %x", k, got, want)
+			}
+		}
+	}
+}
+
+func TestPreCommit(t *testing.T) {
+	ctx := context.Background()
+	maxLeaves := func(ctx context.Context, leaves []*trillian.MapLeaf) error {
+		if len(leaves) > 10 {
+			return fmt.Errorf("%v leaves changed, more than 10", len(leaves))
+		}
+		return nil
+	}
+	for _, tc := range []struct {
+		mutations int
+		wantErr   bool
+	}{
+		{10, false},
+		{11, true},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, tc.mutations)...)
+		s := newTestSequencer(fakeMutations)
+		s.PreCommit = maxLeaves
+		tmap := s.tmap.(*fakeMap)
+
+		err := s.CreateEpoch(ctx, false)
+		if got, want := err != nil, tc.wantErr; got != want {
+			t.Errorf("CreateEpoch(%v mutations): %v, want err %v", tc.mutations, err, want)
+		}
+		wantRoots, wantLeaves := 2, tc.mutations
+		if tc.wantErr {
+			wantRoots, wantLeaves = 1, 0
+		}
+		if got := len(tmap.roots); got != wantRoots {
+			t.Errorf("CreateEpoch(%v mutations): len(roots): %v, want %v", tc.mutations, got, wantRoots)
+		}
+		if got := len(tmap.leaves); got != wantLeaves {
+			t.Errorf("CreateEpoch(%v mutations): len(leaves): %v, want %v", tc.mutations, got, wantLeaves)
+		}
+	}
+}
+
+func TestMapperDataFunc(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.MapperDataFunc = func(seq int64) *trillian.MapperMetadata {
+		return &trillian.MapperMetadata{
+			SourceLogId:              []byte("source"),
+			HighestFullyCompletedSeq: seq + 100,
+		}
+	}
+	tmap := s.tmap.(*fakeMap)
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	md := tmap.roots[1].GetMetadata()
+	if got, want := md.GetSourceLogId(), []byte("source"); !bytes.Equal(got, want) {
+		t.Errorf("SourceLogId: %s, want %s", got, want)
+	}
+	// The signer must keep control of its sequence watermark.
+	if got, want := md.GetHighestFullyCompletedSeq(), int64(3); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+}
+
+// divergentMap is a fakeMap whose map roots read back by revision have a
+// different root hash than the ones returned by SetLeaves.
+type divergentMap struct {
+	*fakeMap
+}
+
+func (m *divergentMap) GetSignedMapRootByRevision(ctx context.Context, in *trillian.GetSignedMapRootByRevisionRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	resp, err := m.fakeMap.GetSignedMapRootByRevision(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	root := *resp.GetMapRoot()
+	root.RootHash = []byte("split brain")
+	return &trillian.GetSignedMapRootResponse{MapRoot: &root}, nil
+}
+
+func TestVerifyMapRoot(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		divergent bool
+		want      error
+	}{
+		{false, nil},
+		{true, ErrMapRootMismatch},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		s.VerifyMapRoot = true
+		if tc.divergent {
+			s.tmap = &divergentMap{fakeMap: newFakeMap()}
+		}
+		if got := s.CreateEpoch(ctx, false); got != tc.want {
+			t.Errorf("CreateEpoch(divergent: %v): %v, want %v", tc.divergent, got, tc.want)
+		}
+	}
+}
+
+func TestEpochOutcomes(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	outcomes := []string{outcomeSuccess, outcomeEmptySkipped, outcomeError}
+	for _, tc := range []struct {
+		setup func()
+		want  string
+	}{
+		{func() {}, outcomeEmptySkipped},
+		{func() { fakeMutations.write(signedKV(1, 3)...) }, outcomeSuccess},
+		{func() { s.tmap = &failingMap{fakeMap: newFakeMap()} }, outcomeError},
+	} {
+		tc.setup()
+		before := make(map[string]float64)
+		for _, o := range outcomes {
+			before[o] = counterValue(t, epochsCtr.WithLabelValues(o))
+		}
+		err := s.CreateEpoch(ctx, false)
+		if got, want := err != nil, tc.want == outcomeError; got != want {
+			t.Errorf("CreateEpoch(): %v, want err %v", err, want)
+		}
+		for _, o := range outcomes {
+			want := 0.0
+			if o == tc.want {
+				want = 1
+			}
+			if got := counterValue(t, epochsCtr.WithLabelValues(o)) - before[o]; got != want {
+				t.Errorf("epochsCtr{outcome: %v} after a %v epoch: %v, want %v", o, tc.want, got, want)
+			}
+		}
+	}
+}
+
+func TestMutationsByType(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	types := []string{mutationCreate, mutationUpdate}
+	updates := signedKV(1, 2)
+	for _, m := range updates {
+		m.KeyValue.Value = append(m.KeyValue.Value, '2')
+	}
+	for _, tc := range []struct {
+		mutations []*tpb.SignedKV
+		want      map[string]float64
+	}{
+		{signedKV(1, 3), map[string]float64{mutationCreate: 3}},
+		{append(updates, signedKV(4, 4)...), map[string]float64{mutationCreate: 1, mutationUpdate: 2}},
+	} {
+		before := make(map[string]float64)
+		for _, typ := range types {
+			before[typ] = counterValue(t, mutationsByTypeCtr.WithLabelValues(typ))
+		}
+		fakeMutations.write(tc.mutations...)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		for _, typ := range types {
+			if got, want := counterValue(t, mutationsByTypeCtr.WithLabelValues(typ))-before[typ], tc.want[typ]; got != want {
+				t.Errorf("mutationsByTypeCtr{type: %v}: %v, want %v", typ, got, want)
+			}
+		}
+	}
+}
+
+func TestRepairCorruptLeaves(t *testing.T) {
+	ctx := context.Background()
+	corrupt := []byte{0xff, 0xff}
+	for _, tc := range []struct {
+		repair   bool
+		repaired bool
+	}{
+		{false, false},
+		{true, true},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 1)...)
+		s := newTestSequencer(fakeMutations)
+		s.RepairCorruptLeaves = tc.repair
+		tmap := s.tmap.(*fakeMap)
+		tmap.leaves["key_1"] = &trillian.MapLeaf{Index: []byte("key_1"), LeafValue: corrupt}
+
+		before := counterValue(t, corruptLeavesCtr)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		got := tmap.leaves["key_1"].GetLeafValue()
+		if repaired := !bytes.Equal(got, corrupt); repaired != tc.repaired {
+			t.Errorf("RepairCorruptLeaves %v: leaf value %x, repaired %v, want %v", tc.repair, got, repaired, tc.repaired)
+		}
+		if tc.repaired {
+			if _, err := entry.FromLeafValue(got); err != nil {
+				t.Errorf("RepairCorruptLeaves %v: entry.FromLeafValue(): %v", tc.repair, err)
+			}
+		}
+		if got, want := counterValue(t, corruptLeavesCtr)-before, 1.0; got != want {
+			t.Errorf("RepairCorruptLeaves %v: corruptLeavesCtr: %v, want %v", tc.repair, got, want)
+		}
+	}
+}
+
+// cappedMap is a fakeMap that rejects GetLeaves requests with more than
+// maxIndexes indexes with code, or ResourceExhausted if code is not set.
+type cappedMap struct {
+	*fakeMap
+	maxIndexes int
+	code       codes.Code
+	calls      int
+}
+
+func (m *cappedMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	m.calls++
+	if len(in.Index) > m.maxIndexes {
+		code := m.code
+		if code == codes.OK {
+			code = codes.ResourceExhausted
+		}
+		return nil, grpc.Errorf(code, "too many indexes: %v > %v", len(in.Index), m.maxIndexes)
+	}
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+func TestGetLeavesSplit(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		desc       string
+		maxIndexes int
+		code       codes.Code
+		wantErr    bool
+		wantCalls  int // Upper bound on the number of GetLeaves calls.
+	}{
+		{"split", 100, codes.ResourceExhausted, false, 10},
+		{"invalid argument", 100, codes.InvalidArgument, true, 1},
+		// The request would have to be split more than maxGetLeavesSplits
+		// times.
+		{"too many splits", 0, codes.ResourceExhausted, true, 1 << (maxGetLeavesSplits + 1)},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 250)...)
+		s := newTestSequencer(fakeMutations)
+		tmap := &cappedMap{fakeMap: newFakeMap(), maxIndexes: tc.maxIndexes, code: tc.code}
+		s.tmap = tmap
+
+		err := s.CreateEpoch(ctx, false)
+		if got := err != nil; got != tc.wantErr {
+			t.Fatalf("%v: CreateEpoch(): %v, want error %v", tc.desc, err, tc.wantErr)
+		}
+		if tmap.calls > tc.wantCalls {
+			t.Errorf("%v: GetLeaves called %v times, want at most %v", tc.desc, tmap.calls, tc.wantCalls)
+		}
+		if tc.wantErr {
+			continue
+		}
+		if got, want := len(tmap.leaves), 250; got != want {
+			t.Errorf("%v: len(leaves): %v, want %v", tc.desc, got, want)
+		}
+		if tmap.calls < 2 {
+			t.Errorf("%v: GetLeaves called %v times, want the request to be split", tc.desc, tmap.calls)
+		}
+	}
+}
+
+// unreadableMap fails the first failures calls to GetLeaves, or all of them
+// if failures is negative.
+type unreadableMap struct {
+	*fakeMap
+	failures int
+}
+
+func (m *unreadableMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	if m.failures != 0 {
+		m.failures--
+		return nil, fmt.Errorf("map unavailable")
+	}
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+func TestGetLeavesFailures(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		desc       string
+		failures   int
+		bestEffort bool
+		wantErr    bool
+		want       string // Commitment of key_1.
+	}{
+		{"transient failure", 2, false, false, "ab"},
+		{"persistent failure", -1, false, true, "a"},
+		{"best effort", -1, true, false, "b"},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(&tpb.SignedKV{KeyValue: &tpb.KeyValue{Key: []byte("key_1"), Value: []byte("a")}})
+		s := newTestSequencer(fakeMutations)
+		s.mutator = appendingMutator{}
+		s.GetLeavesRetries = 2
+		s.BestEffortLeaves = tc.bestEffort
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		tmap := &unreadableMap{fakeMap: s.tmap.(*fakeMap), failures: tc.failures}
+		s.tmap = tmap
+
+		fakeMutations.write(&tpb.SignedKV{KeyValue: &tpb.KeyValue{Key: []byte("key_1"), Value: []byte("b")}})
+		before := counterValue(t, bestEffortEpochsCtr)
+		err := s.CreateEpoch(ctx, false)
+		if got, want := err != nil, tc.wantErr; got != want {
+			t.Errorf("%v: CreateEpoch(): %v, want err %v", tc.desc, err, want)
+		}
+		want, err := proto.Marshal(&tpb.Entry{Commitment: []byte(tc.want)})
+		if err != nil {
+			t.Fatalf("proto.Marshal(): %v", err)
+		}
+		if got := tmap.leaves["key_1"].GetLeafValue(); !bytes.Equal(got, want) {
+			t.Errorf("%v: leaf key_1: %x, want %x", tc.desc, got, want)
+		}
+		wantCtr := 0.0
+		if tc.bestEffort {
+			wantCtr = 1
+		}
+		if got := counterValue(t, bestEffortEpochsCtr) - before; got != wantCtr {
+			t.Errorf("%v: bestEffortEpochsCtr: %v, want %v", tc.desc, got, wantCtr)
+		}
+	}
+}
+
+type recordingMap struct {
+	*fakeMap
+	indexes [][]byte
+}
+
+func (m *recordingMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	m.indexes = append(m.indexes, in.Index...)
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+func (m *recordingMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	for _, l := range in.Leaves {
+		m.indexes = append(m.indexes, l.Index)
+	}
+	return m.fakeMap.SetLeaves(ctx, in, opts...)
+}
+
+func TestIndexFunc(t *testing.T) {
+	ctx := context.Background()
+	hashIndex := func(key []byte) ([]byte, error) {
+		h := sha256.Sum256(key)
+		return h[:], nil
+	}
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.IndexFunc = hashIndex
+	s.FetchNewLeafProofs = true
+	tmap := &recordingMap{fakeMap: newFakeMap()}
+	s.tmap = tmap
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	fakeMutations.write(signedKV(1, 1)...)
+	if err := s.CreateEpoch(ctx, true); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+
+	want := make(map[string]bool)
+	for _, m := range signedKV(1, 3) {
+		index, _ := hashIndex(m.GetKeyValue().GetKey())
+		want[string(index)] = true
+	}
+	if len(tmap.indexes) == 0 {
+		t.Fatalf("no index was read or written")
+	}
+	for _, index := range tmap.indexes {
+		if !want[string(index)] {
+			t.Errorf("index %x was read or written, want a SHA-256 of a key", index)
+		}
+	}
+	if got, want := len(tmap.leaves), 3; got != want {
+		t.Errorf("len(leaves): %v, want %v", got, want)
+	}
+	for index := range want {
+		if _, ok := tmap.leaves[index]; !ok {
+			t.Errorf("no leaf at index %x", index)
+		}
+	}
+}
+
+func TestConflictResolver(t *testing.T) {
+	ctx := context.Background()
+	first := func(index []byte, candidates []*tpb.SignedKV) []*tpb.SignedKV {
+		return candidates[:1]
+	}
+	for _, tc := range []struct {
+		resolver func([]byte, []*tpb.SignedKV) []*tpb.SignedKV
+		want     string
+	}{
+		{nil, "c"},
+		{first, "a"},
+	} {
+		var mutations []*tpb.SignedKV
+		for _, v := range []string{"a", "b", "c"} {
+			mutations = append(mutations, &tpb.SignedKV{KeyValue: &tpb.KeyValue{Key: []byte("key_1"), Value: []byte(v)}})
+			mutations = append(mutations, signedKV(2, 2)...)
+		}
+		s := newTestSequencer(&fakeMutation{})
+		s.ConflictResolver = tc.resolver
+		leaves, err := s.applyMutations(ctx, mutations, nil)
+		if err != nil {
+			t.Fatalf("applyMutations(): %v", err)
+		}
+		if got, want := len(leaves), 2; got != want {
+			t.Fatalf("len(applyMutations()): %v, want %v", got, want)
+		}
+		want, err := proto.Marshal(&tpb.Entry{Commitment: []byte(tc.want)})
+		if err != nil {
+			t.Fatalf("proto.Marshal(): %v", err)
+		}
+		for _, l := range leaves {
+			if string(l.Index) == "key_1" && !bytes.Equal(l.LeafValue, want) {
+				t.Errorf("leaf key_1: %x, want commitment %q", l.LeafValue, tc.want)
+			}
+		}
+	}
+}
+
+func TestPriorityFunc(t *testing.T) {
+	ctx := context.Background()
+	revocation := func(m *tpb.SignedKV) int {
+		if bytes.Equal(m.GetKeyValue().GetValue(), []byte("revoke")) {
+			return 1
+		}
+		return 0
+	}
+	for _, tc := range []struct {
+		priority     func(*tpb.SignedKV) int
+		values       []string
+		want         string
+		wantRejected int
+	}{
+		// The revocation has a lower sequence number than the update.
+		{nil, []string{"revoke", "update"}, "update", 0},
+		{revocation, []string{"revoke", "update"}, "revoke", 1},
+		// The revocation has a higher sequence number than the update.
+		{nil, []string{"update", "revoke"}, "revoke", 0},
+		{revocation, []string{"update", "revoke"}, "revoke", 1},
+	} {
+		var mutations []*tpb.SignedKV
+		for _, v := range tc.values {
+			mutations = append(mutations, &tpb.SignedKV{
+				KeyValue: &tpb.KeyValue{Key: []byte("key_1"), Value: []byte(v)},
+			})
+		}
+		mutations = append(mutations, signedKV(2, 2)...)
+		s := newTestSequencer(&fakeMutation{})
+		s.PriorityFunc = tc.priority
+		leaves, err := s.applyMutations(ctx, mutations, nil)
+		if err != nil {
+			t.Fatalf("applyMutations(): %v", err)
+		}
+		if got, want := len(leaves), 2; got != want {
+			t.Fatalf("len(applyMutations()): %v, want %v", got, want)
+		}
+		want, err := proto.Marshal(&tpb.Entry{Commitment: []byte(tc.want)})
+		if err != nil {
+			t.Fatalf("proto.Marshal(): %v", err)
+		}
+		for _, l := range leaves {
+			if string(l.Index) == "key_1" && !bytes.Equal(l.LeafValue, want) {
+				t.Errorf("%v: leaf key_1: %x, want commitment %q", tc.values, l.LeafValue, tc.want)
+			}
+		}
+		if got := len(s.RecentRejections()); got != tc.wantRejected {
+			t.Errorf("%v: len(RecentRejections()): %v, want %v", tc.values, got, tc.wantRejected)
+		}
+	}
+}
+
+func TestLeafCounts(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	// Five mutations collapse into three leaves.
+	fakeMutations.write(signedKV(1, 3)...)
+	fakeMutations.write(signedMutation([]byte("key_1"), []byte("value_1b"), []byte("sig")))
+	fakeMutations.write(signedMutation([]byte("key_2"), []byte("value_2b"), []byte("sig")))
+	s := newTestSequencer(fakeMutations)
+	var resp *tpb.GetMutationsResponse
+	s.OnEpoch = func(ctx context.Context, r *tpb.GetMutationsResponse) error {
+		resp = r
+		return nil
+	}
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := resp.GetLeavesRead(), int64(5); got != want {
+		t.Errorf("LeavesRead: %v, want %v", got, want)
+	}
+	if got, want := resp.GetLeavesWritten(), int64(3); got != want {
+		t.Errorf("LeavesWritten: %v, want %v", got, want)
+	}
+}
+
+func TestMutateHist(t *testing.T) {
+	ctx := context.Background()
+	delay := 10 * time.Millisecond
+	s := newTestSequencer(&fakeMutation{})
+	s.mutator = sleepingMutator{delay: delay}
+
+	beforeCount, beforeSum := histogramValue(t, mutateHist)
+	if _, err := s.applyMutations(ctx, signedKV(1, 3), nil); err != nil {
+		t.Fatalf("applyMutations(): %v", err)
+	}
+	count, sum := histogramValue(t, mutateHist)
+	if got, want := count-beforeCount, uint64(3); got != want {
+		t.Errorf("mutateHist: %v observations, want %v", got, want)
+	}
+	if got, want := sum-beforeSum, 3*delay.Seconds(); got < want {
+		t.Errorf("mutateHist: total %vs, want at least %vs", got, want)
+	}
+}
+
+// unusedLog is a trillian.TrillianLogClient fake that counts the calls it
+// receives and fails them.
+type unusedLog struct {
+	trillian.TrillianLogClient
+	calls int
+}
+
+func (l *unusedLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	l.calls++
+	return nil, fmt.Errorf("log disabled")
+}
+
+func (l *unusedLog) GetLatestSignedLogRoot(ctx context.Context, in *trillian.GetLatestSignedLogRootRequest, opts ...grpc.CallOption) (*trillian.GetLatestSignedLogRootResponse, error) {
+	l.calls++
+	return nil, fmt.Errorf("log disabled")
+}
+
+func (l *unusedLog) GetInclusionProof(ctx context.Context, in *trillian.GetInclusionProofRequest, opts ...grpc.CallOption) (*trillian.GetInclusionProofResponse, error) {
+	l.calls++
+	return nil, fmt.Errorf("log disabled")
+}
+
+func (l *unusedLog) GetConsistencyProof(ctx context.Context, in *trillian.GetConsistencyProofRequest, opts ...grpc.CallOption) (*trillian.GetConsistencyProofResponse, error) {
+	l.calls++
+	return nil, fmt.Errorf("log disabled")
+}
+
+func TestMapOnly(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	tlog := &unusedLog{}
+	s.tlog = tlog
+	s.MapOnly = true
+	s.LogIntegrationTimeout = time.Second
+	tmap := s.tmap.(*fakeMap)
+
+	if err := s.Initialize(ctx); err != nil {
+		t.Fatalf("Initialize(): %v", err)
+	}
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := len(tmap.roots), 2; got != want {
+		t.Errorf("len(roots): %v, want %v", got, want)
+	}
+	ch := make(chan *tpb.GetMutationsResponse, 1)
+	if err := s.ReplayEpochs(ctx, 1, ch); err != nil {
+		t.Fatalf("ReplayEpochs(): %v", err)
+	}
+	if got := (<-ch).GetLogRoot(); got != nil {
+		t.Errorf("ReplayEpochs(): LogRoot %v, want nil", got)
+	}
+	if tlog.calls != 0 {
+		t.Errorf("%v log calls, want none", tlog.calls)
+	}
+}
+
+// stuckMap is a fakeMap whose SetLeaves returns the current map root without
+// creating a new revision.
+type stuckMap struct {
+	*fakeMap
+}
+
+func (m *stuckMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	return &trillian.SetMapLeavesResponse{
+		MapRoot: m.roots[len(m.roots)-1],
+	}, nil
+}
+
+func TestRevisionNotAdvanced(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+	fakeMutations.write(signedKV(1, 2)...)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	tlog := s.tlog.(*fakeLog)
+	logSize := len(tlog.leaves)
+
+	s.tmap = &stuckMap{fakeMap: tmap}
+	fakeMutations.write(signedKV(3, 4)...)
+	if got, want := s.CreateEpoch(ctx, false), ErrRevisionNotAdvanced; got != want {
+		t.Errorf("CreateEpoch(): %v, want %v", got, want)
+	}
+	if got := len(tlog.leaves); got != logSize {
+		t.Errorf("log size: %v, want %v", got, logSize)
+	}
+}
+
+// signedKV returns mutations for keys start to end inclusive.
+func signedKV(start, end int) []*tpb.SignedKV {
+	kvs := make([]*tpb.SignedKV, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		kvs = append(kvs, &tpb.SignedKV{
+			KeyValue: &tpb.KeyValue{
+				Key:   []byte(fmt.Sprintf("key_%v", i)),
+				Value: []byte(fmt.Sprintf("value_%v", i)),
+			}})
+	}
+	return kvs
+}
+
+// trillian.TrillianMapClient fake.
+type fakeMap struct {
+	roots  []*trillian.SignedMapRoot
+	leaves map[string]*trillian.MapLeaf
+	// signer, if set, signs the map roots written by SetLeaves.
+	signer *tcrypto.Signer
+}
+
+func newFakeMap() *fakeMap {
+	return &fakeMap{
+		roots:  []*trillian.SignedMapRoot{{MapId: mapID}},
+		leaves: make(map[string]*trillian.MapLeaf),
+	}
+}
+
+func (m *fakeMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	inclusions := make([]*trillian.MapLeafInclusion, 0, len(in.Index))
+	for _, index := range in.Index {
+		leaf, ok := m.leaves[string(index)]
+		if !ok {
+			leaf = &trillian.MapLeaf{Index: index}
+		}
+		inclusions = append(inclusions, &trillian.MapLeafInclusion{Leaf: leaf})
+	}
+	return &trillian.GetMapLeavesResponse{
+		MapLeafInclusion: inclusions,
+	}, nil
+}
+
+// SetLeaves stores the leaves and creates a new map revision.
+func (m *fakeMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	h := sha256.New()
+	h.Write(m.roots[len(m.roots)-1].GetRootHash())
+	for _, l := range in.Leaves {
+		m.leaves[string(l.Index)] = l
+		h.Write(l.Index)
+		h.Write(l.LeafValue)
+	}
+	root := &trillian.SignedMapRoot{
+		MapId:          in.MapId,
+		MapRevision:    int64(len(m.roots)),
+		RootHash:       h.Sum(nil),
+		TimestampNanos: time.Now().UnixNano(),
+		Metadata:       in.MapperData,
+	}
+	if m.signer != nil {
+		sig, err := m.signer.SignObject(*root)
+		if err != nil {
+			return nil, err
+		}
+		root.Signature = sig
+	}
+	m.roots = append(m.roots, root)
+	return &trillian.SetMapLeavesResponse{MapRoot: root}, nil
+}
+
+func (m *fakeMap) GetSignedMapRoot(ctx context.Context, in *trillian.GetSignedMapRootRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	return &trillian.GetSignedMapRootResponse{
+		MapRoot: m.roots[len(m.roots)-1],
+	}, nil
+}
+
+func (m *fakeMap) GetSignedMapRootByRevision(ctx context.Context, in *trillian.GetSignedMapRootByRevisionRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	if in.Revision < 0 || in.Revision >= int64(len(m.roots)) {
+		return nil, fmt.Errorf("revision %v not found", in.Revision)
+	}
+	return &trillian.GetSignedMapRootResponse{
+		MapRoot: m.roots[in.Revision],
+	}, nil
+}
+
+// slowMap delays calls to the embedded fakeMap, or fails them if ctx expires
+// first.
+type slowMap struct {
+	*fakeMap
+	getLeavesDelay time.Duration
+	setLeavesDelay time.Duration
+}
+
+func (m *slowMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	if err := sleep(ctx, m.getLeavesDelay); err != nil {
+		return nil, err
+	}
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+func (m *slowMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	if err := sleep(ctx, m.setLeavesDelay); err != nil {
+		return nil, err
+	}
+	return m.fakeMap.SetLeaves(ctx, in, opts...)
+}
+
+// advancingMap creates a new, empty, map revision after every call to
+// GetSignedMapRoot and records the revisions requested from GetLeaves.
+type advancingMap struct {
+	*fakeMap
+	getLeavesRevisions []int64
+}
+
+func (m *advancingMap) GetSignedMapRoot(ctx context.Context, in *trillian.GetSignedMapRootRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	resp, err := m.fakeMap.GetSignedMapRoot(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.fakeMap.SetLeaves(ctx, &trillian.SetMapLeavesRequest{MapId: in.MapId}); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (m *advancingMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	m.getLeavesRevisions = append(m.getLeavesRevisions, in.Revision)
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+// revisionMap records the revisions requested from GetLeaves.
+type revisionMap struct {
+	*fakeMap
+	getLeavesRevisions []int64
+}
+
+func (m *revisionMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	m.getLeavesRevisions = append(m.getLeavesRevisions, in.Revision)
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+// shuffledMap reverses the order of the inclusions returned by GetLeaves and
+// omits the inclusion of the first requested index.
+type shuffledMap struct {
+	*fakeMap
+}
+
+func (m *shuffledMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	resp, err := m.fakeMap.GetLeaves(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	inclusions := resp.MapLeafInclusion[1:]
+	for i, j := 0, len(inclusions)-1; i < j; i, j = i+1, j-1 {
+		inclusions[i], inclusions[j] = inclusions[j], inclusions[i]
+	}
+	resp.MapLeafInclusion = inclusions
+	return resp, nil
+}
+
+// failingMap fails all calls to GetSignedMapRoot.
+type failingMap struct {
+	*fakeMap
+	calls int
+}
+
+func (m *failingMap) GetSignedMapRoot(ctx context.Context, in *trillian.GetSignedMapRootRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	m.calls++
+	return nil, fmt.Errorf("map unavailable")
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// optsMap records the number of call options passed to the embedded fakeMap.
+type optsMap struct {
+	*fakeMap
+	calls map[string][]int
+}
+
+func (m *optsMap) record(method string, opts []grpc.CallOption) {
+	if m.calls == nil {
+		m.calls = make(map[string][]int)
+	}
+	m.calls[method] = append(m.calls[method], len(opts))
+}
+
+func (m *optsMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	m.record("GetLeaves", opts)
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+func (m *optsMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	m.record("SetLeaves", opts)
+	return m.fakeMap.SetLeaves(ctx, in, opts...)
+}
+
+func (m *optsMap) GetSignedMapRoot(ctx context.Context, in *trillian.GetSignedMapRootRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	m.record("GetSignedMapRoot", opts)
+	return m.fakeMap.GetSignedMapRoot(ctx, in, opts...)
+}
+
+// optsLog records the number of call options passed to the embedded fakeLog.
+type optsLog struct {
+	*fakeLog
+	calls map[string][]int
+}
+
+func (l *optsLog) record(method string, opts []grpc.CallOption) {
+	if l.calls == nil {
+		l.calls = make(map[string][]int)
+	}
+	l.calls[method] = append(l.calls[method], len(opts))
+}
+
+func (l *optsLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	l.record("QueueLeaf", opts)
+	return l.fakeLog.QueueLeaf(ctx, in, opts...)
+}
+
+func (l *optsLog) GetLatestSignedLogRoot(ctx context.Context, in *trillian.GetLatestSignedLogRootRequest, opts ...grpc.CallOption) (*trillian.GetLatestSignedLogRootResponse, error) {
+	l.record("GetLatestSignedLogRoot", opts)
+	return l.fakeLog.GetLatestSignedLogRoot(ctx, in, opts...)
+}
+
+// trillian.TrillianLogClient fake. Only the methods used by the sequencer
+// are implemented.
+type fakeLog struct {
+	trillian.TrillianLogClient
+	leaves []*trillian.LogLeaf
+}
+
+func (l *fakeLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	l.leaves = append(l.leaves, in.Leaf)
+	return &trillian.QueueLeafResponse{}, nil
+}
+
+func (l *fakeLog) GetLatestSignedLogRoot(ctx context.Context, in *trillian.GetLatestSignedLogRootRequest, opts ...grpc.CallOption) (*trillian.GetLatestSignedLogRootResponse, error) {
+	return &trillian.GetLatestSignedLogRootResponse{
+		SignedLogRoot: &trillian.SignedLogRoot{
+			TreeSize: int64(len(l.leaves)),
+		},
+	}, nil
+}
+
+func (l *fakeLog) GetLeavesByIndex(ctx context.Context, in *trillian.GetLeavesByIndexRequest, opts ...grpc.CallOption) (*trillian.GetLeavesByIndexResponse, error) {
+	resp := &trillian.GetLeavesByIndexResponse{}
+	for _, i := range in.LeafIndex {
+		if i < 0 || i >= int64(len(l.leaves)) {
+			return nil, fmt.Errorf("leaf %v not found", i)
+		}
+		leaf := *l.leaves[i]
+		leaf.LeafIndex = i
+		resp.Leaves = append(resp.Leaves, &leaf)
+	}
+	return resp, nil
+}
+
+// GetInclusionProof returns a proof containing the leaf hash of the requested
+// leaf.
+func (l *fakeLog) GetInclusionProof(ctx context.Context, in *trillian.GetInclusionProofRequest, opts ...grpc.CallOption) (*trillian.GetInclusionProofResponse, error) {
+	if in.LeafIndex < 0 || in.LeafIndex >= int64(len(l.leaves)) || in.TreeSize > int64(len(l.leaves)) {
+		return nil, fmt.Errorf("invalid inclusion proof request %v", in)
+	}
+	return &trillian.GetInclusionProofResponse{
+		Proof: &trillian.Proof{
+			LeafIndex: in.LeafIndex,
+			Hashes:    [][]byte{l.leaves[in.LeafIndex].LeafIdentityHash},
+		},
+	}, nil
+}
+
+// GetConsistencyProof returns a proof containing the leaf hash of the last
+// leaf of the second tree, or an empty proof if both trees are the same.
+func (l *fakeLog) GetConsistencyProof(ctx context.Context, in *trillian.GetConsistencyProofRequest, opts ...grpc.CallOption) (*trillian.GetConsistencyProofResponse, error) {
+	if in.FirstTreeSize < 1 || in.FirstTreeSize > in.SecondTreeSize || in.SecondTreeSize > int64(len(l.leaves)) {
+		return nil, fmt.Errorf("invalid consistency proof request %v", in)
+	}
+	proof := &trillian.Proof{}
+	if in.FirstTreeSize < in.SecondTreeSize {
+		proof.Hashes = [][]byte{l.leaves[in.SecondTreeSize-1].LeafIdentityHash}
+	}
+	return &trillian.GetConsistencyProofResponse{Proof: proof}, nil
+}
+
+// delayedLog integrates queued leaves into the embedded fakeLog only once
+// delay has elapsed since they were queued.
+type delayedLog struct {
+	*fakeLog
+	delay    time.Duration
+	queuedAt time.Time
+	pending  []*trillian.LogLeaf
+}
+
+func (l *delayedLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	l.pending = append(l.pending, in.Leaf)
+	l.queuedAt = time.Now()
+	return &trillian.QueueLeafResponse{}, nil
+}
+
+func (l *delayedLog) GetLatestSignedLogRoot(ctx context.Context, in *trillian.GetLatestSignedLogRootRequest, opts ...grpc.CallOption) (*trillian.GetLatestSignedLogRootResponse, error) {
+	if len(l.pending) > 0 && time.Since(l.queuedAt) >= l.delay {
+		l.leaves = append(l.leaves, l.pending...)
+		l.pending = nil
+	}
+	return l.fakeLog.GetLatestSignedLogRoot(ctx, in, opts...)
+}
+
+// failingLog fails all calls to QueueLeaf.
+type failingLog struct {
+	*fakeLog
+}
+
+func (l *failingLog) QueueLeaf(ctx context.Context, in *trillian.QueueLeafRequest, opts ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+	return nil, fmt.Errorf("log unavailable")
+}
+
+// noProofLog fails all calls for log proofs.
+// laggingLog reports a log root without the last queued leaf.
+type laggingLog struct {
+	*fakeLog
+}
+
+func (l *laggingLog) GetLatestSignedLogRoot(ctx context.Context, in *trillian.GetLatestSignedLogRootRequest, opts ...grpc.CallOption) (*trillian.GetLatestSignedLogRootResponse, error) {
+	return &trillian.GetLatestSignedLogRootResponse{
+		SignedLogRoot: &trillian.SignedLogRoot{
+			TreeSize: int64(len(l.leaves)) - 1,
+		},
+	}, nil
+}
+
+type noProofLog struct {
+	*fakeLog
+}
+
+func (l *noProofLog) GetInclusionProof(ctx context.Context, in *trillian.GetInclusionProofRequest, opts ...grpc.CallOption) (*trillian.GetInclusionProofResponse, error) {
+	return nil, fmt.Errorf("proofs unavailable")
+}
+
+func (l *noProofLog) GetConsistencyProof(ctx context.Context, in *trillian.GetConsistencyProofRequest, opts ...grpc.CallOption) (*trillian.GetConsistencyProofResponse, error) {
+	return nil, fmt.Errorf("proofs unavailable")
+}
+
+// zeroRevisionMap returns map roots with revision 0 from SetLeaves.
+type zeroRevisionMap struct {
+	*fakeMap
+}
+
+func (m *zeroRevisionMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	resp, err := m.fakeMap.SetLeaves(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	resp.MapRoot.MapRevision = 0
+	return resp, nil
+}
+
+// treeIDMap fails requests for maps other than mapID.
+type treeIDMap struct {
+	*fakeMap
+}
+
+func (m *treeIDMap) GetSignedMapRoot(ctx context.Context, in *trillian.GetSignedMapRootRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	if in.MapId != mapID {
+		return nil, fmt.Errorf("map %v not found", in.MapId)
+	}
+	return m.fakeMap.GetSignedMapRoot(ctx, in, opts...)
+}
+
+// treeIDLog fails requests for logs other than logID.
+type treeIDLog struct {
+	*fakeLog
+}
+
+func (l *treeIDLog) GetLatestSignedLogRoot(ctx context.Context, in *trillian.GetLatestSignedLogRootRequest, opts ...grpc.CallOption) (*trillian.GetLatestSignedLogRootResponse, error) {
+	if in.LogId != logID {
+		return nil, fmt.Errorf("log %v not found", in.LogId)
+	}
+	return l.fakeLog.GetLatestSignedLogRoot(ctx, in, opts...)
+}
+
+// proofLog records the proof requests made to the embedded fakeLog.
+type proofLog struct {
+	*fakeLog
+	inclusionReqs   []*trillian.GetInclusionProofRequest
+	consistencyReqs []*trillian.GetConsistencyProofRequest
+}
+
+func (l *proofLog) GetInclusionProof(ctx context.Context, in *trillian.GetInclusionProofRequest, opts ...grpc.CallOption) (*trillian.GetInclusionProofResponse, error) {
+	l.inclusionReqs = append(l.inclusionReqs, in)
+	return l.fakeLog.GetInclusionProof(ctx, in, opts...)
+}
+
+func (l *proofLog) GetConsistencyProof(ctx context.Context, in *trillian.GetConsistencyProofRequest, opts ...grpc.CallOption) (*trillian.GetConsistencyProofResponse, error) {
+	l.consistencyReqs = append(l.consistencyReqs, in)
+	return l.fakeLog.GetConsistencyProof(ctx, in, opts...)
+}
+
+// emptyConsistencyLog returns empty consistency proofs.
+type emptyConsistencyLog struct {
+	*fakeLog
+}
+
+func (l *emptyConsistencyLog) GetConsistencyProof(ctx context.Context, in *trillian.GetConsistencyProofRequest, opts ...grpc.CallOption) (*trillian.GetConsistencyProofResponse, error) {
+	return &trillian.GetConsistencyProofResponse{Proof: &trillian.Proof{}}, nil
+}
+
+// pagingMutation fails the failAt-th call to ReadRange.
+type pagingMutation struct {
+	*fakeMutation
+	failAt int
+	calls  int
+}
+
+func (m *pagingMutation) ReadRange(txn transaction.Txn, startSequence, endSequence uint64, count int32) (uint64, []*tpb.SignedKV, error) {
+	m.calls++
+	if m.calls == m.failAt {
+		return 0, nil, fmt.Errorf("connection reset")
+	}
+	return m.fakeMutation.ReadRange(txn, startSequence, endSequence, count)
+}
+
+// mutator.Mutator fake that stores the mutation value as the entry
+// commitment.
+type fakeMutator struct{}
+
+func (fakeMutator) Mutate(ctx context.Context, value, mutation proto.Message) ([]byte, error) {
+	kv := mutation.(*tpb.SignedKV).GetKeyValue()
+	return proto.Marshal(&tpb.Entry{Commitment: kv.GetValue()})
+}
+
+// sleepingMutator sleeps for delay before applying mutations like fakeMutator.
+type sleepingMutator struct {
+	delay time.Duration
+}
+
+func (m sleepingMutator) Mutate(ctx context.Context, value, mutation proto.Message) ([]byte, error) {
+	time.Sleep(m.delay)
+	return fakeMutator{}.Mutate(ctx, value, mutation)
+}
+
+// blockingMutator blocks until ctx is done.
+type blockingMutator struct{}
+
+func (blockingMutator) Mutate(ctx context.Context, value, mutation proto.Message) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// mutator.Mutation fake. Sequence numbers are 1-based.
+type fakeMutation struct {
+	mtns []*tpb.SignedKV
+}
+
+func (m *fakeMutation) write(kvs ...*tpb.SignedKV) {
+	m.mtns = append(m.mtns, kvs...)
+}
+
+func (m *fakeMutation) ReadRange(txn transaction.Txn, startSequence, endSequence uint64, count int32) (uint64, []*tpb.SignedKV, error) {
+	if startSequence >= uint64(len(m.mtns)) {
+		return 0, nil, nil
+	}
+	if endSequence > uint64(len(m.mtns)) {
+		endSequence = uint64(len(m.mtns))
+	}
+	if endSequence-startSequence > uint64(count) {
+		endSequence = startSequence + uint64(count)
+	}
+	return endSequence, m.mtns[startSequence:endSequence], nil
+}
+
+// ReadAll mirrors the SQL implementation and returns a maximum sequence
+// number of zero if there are no new mutations.
+func (m *fakeMutation) ReadAll(txn transaction.Txn, startSequence uint64) (uint64, []*tpb.SignedKV, error) {
+	if startSequence >= uint64(len(m.mtns)) {
+		return 0, nil, nil
+	}
+	return uint64(len(m.mtns)), m.mtns[startSequence:], nil
+}
+
+func (m *fakeMutation) HighestSequence(txn transaction.Txn) (uint64, error) {
+	return uint64(len(m.mtns)), nil
+}
+
+func (m *fakeMutation) Write(txn transaction.Txn, mutation *tpb.SignedKV) (uint64, error) {
+	m.write(mutation)
+	return uint64(len(m.mtns)), nil
+}
+
+// transaction.Txn fake.
+type fakeTxn struct{}
+
+func (fakeTxn) Prepare(query string) (*sql.Stmt, error) { return nil, nil }
+func (fakeTxn) Commit() error                           { return nil }
+func (fakeTxn) Rollback() error                         { return nil }
+
+// transaction.Factory fake.
+type fakeFactory struct{}
+
+func (fakeFactory) NewTxn(ctx context.Context) (transaction.Txn, error) {
+	return fakeTxn{}, nil
+}
+
 // genFakeTicker creates a time.Tick and generates n Ticks starting from start.
 func genFakeTicker(start time.Time, minInterval time.Duration, n int) <-chan time.Time {
 	tc := make(chan time.Time, n)
@@ -0,0 +1,182 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/google/keytransparency/core/mutator"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// shardMutations returns the mutations of all Shards with sequence numbers
+// greater than startSequence, merged in sequence order, a checkpoint after
+// every round, and the highest sequence number up to which every shard has
+// been read. Only mutations up to the watermark of the shards are returned:
+// a shard may later write mutations above its own highest sequence number
+// only, so sequencing past the lowest one could skip them. Each round reads a
+// page of ReadPageSize mutations from every shard. A shard that returns a
+// full page may hold more mutations, so its high-water sequence number bounds
+// the mutations that can be sequenced in that round. Mutations above it are
+// read again by the next round.
+func (s *sequencer) shardMutations(ctx context.Context, startSequence int64) ([]*tpb.SignedKV, []checkpoint, int64, error) {
+	watermark, err := s.shardsWatermark(ctx)
+	if err != nil {
+		return nil, nil, startSequence, err
+	}
+	pageSize := s.pageSize()
+	var mutations []*tpb.SignedKV
+	var checkpoints []checkpoint
+	seq := startSequence
+	for seq < watermark {
+		var queued []*mutator.QueuedMutation
+		highWater := make([]int64, len(s.Shards))
+		roundSeq := watermark
+		for i, shard := range s.Shards {
+			page, err := s.readShard(ctx, shard, uint64(seq), uint64(watermark), pageSize)
+			if err != nil {
+				return mutations, checkpoints, seq, fmt.Errorf("shard %v: %v", i, err)
+			}
+			highWater[i] = watermark
+			if len(page) == int(pageSize) {
+				highWater[i] = int64(page[len(page)-1].Sequence)
+			}
+			if highWater[i] < roundSeq {
+				roundSeq = highWater[i]
+			}
+			queued = append(queued, page...)
+		}
+		glog.V(3).Infof("shardMutations: high-water sequence numbers: %v, sequencing up to %v", highWater, roundSeq)
+		mutations = append(mutations, s.filterMutations(mergeQueued(queued, roundSeq))...)
+		checkpoints = append(checkpoints, checkpoint{count: len(mutations), seq: roundSeq})
+		seq = roundSeq
+	}
+	return mutations, checkpoints, seq, nil
+}
+
+// shardRange returns the mutations of all Shards with sequence numbers in
+// (startSequence, endSequence], merged in sequence order, and the highest
+// sequence number returned.
+func (s *sequencer) shardRange(ctx context.Context, startSequence, endSequence uint64) ([]*tpb.SignedKV, int64, error) {
+	var queued []*mutator.QueuedMutation
+	for i, shard := range s.Shards {
+		page, err := s.readShard(ctx, shard, startSequence, endSequence, math.MaxInt32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("shard %v: %v", i, err)
+		}
+		queued = append(queued, page...)
+	}
+	var maxSequence int64
+	for _, q := range queued {
+		if int64(q.Sequence) > maxSequence {
+			maxSequence = int64(q.Sequence)
+		}
+	}
+	return s.filterMutations(mergeQueued(queued, maxSequence)), maxSequence, nil
+}
+
+// readShard returns up to count mutations of shard with sequence numbers in
+// (startSequence, endSequence].
+func (s *sequencer) readShard(ctx context.Context, shard mutator.SequencedMutation, startSequence, endSequence uint64, count int32) ([]*mutator.QueuedMutation, error) {
+	txn, err := s.factory.NewTxn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("NewDBTxn(): %v", err)
+	}
+	queued, err := shard.ReadQueued(txn, startSequence, endSequence, count)
+	if err != nil {
+		if err := txn.Rollback(); err != nil {
+			glog.Errorf("Cannot rollback the transaction: %v", err)
+		}
+		return nil, fmt.Errorf("ReadQueued(%v, %v, %v): %v", startSequence, endSequence, count, err)
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, fmt.Errorf("txn.Commit(): %v", err)
+	}
+	return queued, nil
+}
+
+// shardsHighestSequence returns the highest sequence number written to any of
+// the Shards.
+func (s *sequencer) shardsHighestSequence(ctx context.Context) (uint64, error) {
+	var highest uint64
+	for i, shard := range s.Shards {
+		h, err := s.shardHighestSequence(ctx, shard)
+		if err != nil {
+			return 0, fmt.Errorf("shard %v: %v", i, err)
+		}
+		if h > highest {
+			highest = h
+		}
+	}
+	return highest, nil
+}
+
+// shardHighestSequence returns the highest sequence number written to shard.
+func (s *sequencer) shardHighestSequence(ctx context.Context, shard mutator.SequencedMutation) (uint64, error) {
+	txn, err := s.factory.NewTxn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("NewDBTxn(): %v", err)
+	}
+	h, err := shard.HighestSequence(txn)
+	if err != nil {
+		if err := txn.Rollback(); err != nil {
+			glog.Errorf("Cannot rollback the transaction: %v", err)
+		}
+		return 0, fmt.Errorf("HighestSequence(): %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, fmt.Errorf("txn.Commit(): %v", err)
+	}
+	return h, nil
+}
+
+// shardsWatermark returns the lowest of the highest sequence numbers written
+// to each of the Shards. Every shard has written all its mutations up to the
+// watermark, so none can be added below it.
+func (s *sequencer) shardsWatermark(ctx context.Context) (int64, error) {
+	var watermark uint64 = math.MaxUint64
+	for i, shard := range s.Shards {
+		h, err := s.shardHighestSequence(ctx, shard)
+		if err != nil {
+			return 0, fmt.Errorf("shard %v: %v", i, err)
+		}
+		if h < watermark {
+			watermark = h
+		}
+	}
+	return int64(watermark), nil
+}
+
+// mergeQueued returns the mutations of queued with sequence numbers up to
+// maxSequence, in sequence order.
+func mergeQueued(queued []*mutator.QueuedMutation, maxSequence int64) []*tpb.SignedKV {
+	sort.SliceStable(queued, func(i, j int) bool {
+		return queued[i].Sequence < queued[j].Sequence
+	})
+	mutations := make([]*tpb.SignedKV, 0, len(queued))
+	for _, q := range queued {
+		if int64(q.Sequence) > maxSequence {
+			break
+		}
+		mutations = append(mutations, q.Mutation)
+	}
+	return mutations
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/keytransparency/core/mutator"
+	"github.com/google/keytransparency/core/transaction"
+
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// fakeShard holds mutations with the given sequence numbers, in ascending
+// order. The key of each mutation is key_<sequence number>.
+type fakeShard struct {
+	mutator.Mutation
+	queued []*mutator.QueuedMutation
+}
+
+func newFakeShard(sequences ...uint64) *fakeShard {
+	s := &fakeShard{}
+	for _, seq := range sequences {
+		s.queued = append(s.queued, &mutator.QueuedMutation{
+			Sequence: seq,
+			Mutation: signedKV(int(seq), int(seq))[0],
+		})
+	}
+	return s
+}
+
+func (s *fakeShard) ReadQueued(txn transaction.Txn, startSequence, endSequence uint64, count int32) ([]*mutator.QueuedMutation, error) {
+	var ret []*mutator.QueuedMutation
+	for _, q := range s.queued {
+		if q.Sequence > startSequence && q.Sequence <= endSequence && len(ret) < int(count) {
+			ret = append(ret, q)
+		}
+	}
+	return ret, nil
+}
+
+func (s *fakeShard) HighestSequence(txn transaction.Txn) (uint64, error) {
+	if len(s.queued) == 0 {
+		return 0, nil
+	}
+	return s.queued[len(s.queued)-1].Sequence, nil
+}
+
+func keys(mutations []*tpb.SignedKV) []string {
+	ret := make([]string, 0, len(mutations))
+	for _, m := range mutations {
+		ret = append(ret, string(m.GetKeyValue().GetKey()))
+	}
+	return ret
+}
+
+func seqKeys(sequences ...int) []string {
+	ret := make([]string, 0, len(sequences))
+	for _, seq := range sequences {
+		ret = append(ret, fmt.Sprintf("key_%v", seq))
+	}
+	return ret
+}
+
+func TestShardMutations(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		desc     string
+		shards   []*fakeShard
+		pageSize int32
+		want     []string
+		wantSeq  int64
+	}{
+		{
+			desc:    "interleaved",
+			shards:  []*fakeShard{newFakeShard(1, 3, 5, 7), newFakeShard(2, 4, 6, 8)},
+			want:    seqKeys(1, 2, 3, 4, 5, 6, 7),
+			wantSeq: 7,
+		},
+		{
+			desc:     "interleaved pages",
+			shards:   []*fakeShard{newFakeShard(1, 3, 5, 7), newFakeShard(2, 4, 6, 8)},
+			pageSize: 3,
+			want:     seqKeys(1, 2, 3, 4, 5, 6, 7),
+			wantSeq:  7,
+		},
+		{
+			desc:     "uneven pages",
+			shards:   []*fakeShard{newFakeShard(1, 2, 3, 10), newFakeShard(4, 12)},
+			pageSize: 2,
+			want:     seqKeys(1, 2, 3, 4, 10),
+			wantSeq:  10,
+		},
+		{
+			desc:    "empty shard",
+			shards:  []*fakeShard{newFakeShard(), newFakeShard(1, 2)},
+			want:    seqKeys(),
+			wantSeq: 0,
+		},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		s.ReadPageSize = tc.pageSize
+		for _, shard := range tc.shards {
+			s.Shards = append(s.Shards, shard)
+		}
+		mutations, _, seq, err := s.newMutations(ctx, 0)
+		if err != nil {
+			t.Fatalf("%v: newMutations(): %v", tc.desc, err)
+		}
+		if got := keys(mutations); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: newMutations(): %v, want %v", tc.desc, got, tc.want)
+		}
+		if got, want := seq, tc.wantSeq; got != want {
+			t.Errorf("%v: newMutations(): seq %v, want %v", tc.desc, got, want)
+		}
+	}
+}
+
+func TestLaggingShard(t *testing.T) {
+	ctx := context.Background()
+	fast := newFakeShard(1, 3, 5, 7)
+	slow := newFakeShard(2)
+	s := newTestSequencer(&fakeMutation{})
+	s.Shards = []mutator.SequencedMutation{fast, slow}
+	mutations, _, seq, err := s.newMutations(ctx, 0)
+	if err != nil {
+		t.Fatalf("newMutations(): %v", err)
+	}
+	if got, want := keys(mutations), seqKeys(1, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("newMutations(0): %v, want %v", got, want)
+	}
+
+	// The slow shard catches up below the highest sequence number of the
+	// fast one.
+	*slow = *newFakeShard(2, 4, 6, 8)
+	mutations, _, seq, err = s.newMutations(ctx, seq)
+	if err != nil {
+		t.Fatalf("newMutations(): %v", err)
+	}
+	if got, want := keys(mutations), seqKeys(3, 4, 5, 6, 7); !reflect.DeepEqual(got, want) {
+		t.Errorf("newMutations(2): %v, want %v", got, want)
+	}
+	if got, want := seq, int64(7); got != want {
+		t.Errorf("newMutations(2): seq %v, want %v", got, want)
+	}
+}
+
+func TestShardEpochs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSequencer(&fakeMutation{})
+	s.Shards = []mutator.SequencedMutation{newFakeShard(1, 3, 5), newFakeShard(2, 4)}
+	tmap := s.tmap.(*fakeMap)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := len(tmap.leaves), 4; got != want {
+		t.Errorf("len(leaves): %v, want %v", got, want)
+	}
+	if got, want := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(), int64(4); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+	mutations, _, err := s.rangeMutations(ctx, 1, 3)
+	if err != nil {
+		t.Fatalf("rangeMutations(): %v", err)
+	}
+	if got, want := keys(mutations), seqKeys(2, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("rangeMutations(1, 3): %v, want %v", got, want)
+	}
+	// Mutation 5 waits for the second shard to pass it.
+	if pending, err := s.PendingMutations(ctx); err != nil || pending != 1 {
+		t.Errorf("PendingMutations(): %v, %v, want 1", pending, err)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"time"
+)
+
+// SequencerState is the in-memory state a sequencer keeps across epochs. The
+// map and the log hold everything else, e.g. the size of the log is one more
+// than the revision of the last epoch.
+type SequencerState struct {
+	// Revision is the map revision of the last epoch.
+	Revision int64
+	// Time is the map root timestamp of the last epoch.
+	Time time.Time
+	// HighestSequence is the highest sequence number committed to the map.
+	// Epochs starting from a lower one fail with ErrSequenceRegression.
+	HighestSequence int64
+	// Pending is the number of mutations that were left to sequence after
+	// the last epoch.
+	Pending uint64
+}
+
+// Snapshot returns the in-memory state of s, e.g. to restore it in a new
+// sequencer with Restore.
+func (s *sequencer) Snapshot() SequencerState {
+	s.lastMu.RLock()
+	defer s.lastMu.RUnlock()
+	return SequencerState{
+		Revision:        s.lastRev,
+		Time:            s.lastAt,
+		HighestSequence: s.lastSeq,
+		Pending:         s.pending,
+	}
+}
+
+// Restore replaces the in-memory state of s with state. It should be called
+// before s creates any epoch.
+func (s *sequencer) Restore(state SequencerState) {
+	s.lastMu.Lock()
+	defer s.lastMu.Unlock()
+	s.lastRev = state.Revision
+	s.lastAt = state.Time
+	s.lastSeq = state.HighestSequence
+	s.pending = state.Pending
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"testing"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	s := newTestSequencer(fakeMutations)
+	tmap := s.tmap.(*fakeMap)
+	for _, kvs := range [][]int{{1, 2}, {3, 4}} {
+		fakeMutations.write(signedKV(kvs[0], kvs[1])...)
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+	}
+	state := s.Snapshot()
+	if got, want := state.Revision, int64(2); got != want {
+		t.Errorf("Snapshot().Revision: %v, want %v", got, want)
+	}
+	if got, want := state.HighestSequence, int64(4); got != want {
+		t.Errorf("Snapshot().HighestSequence: %v, want %v", got, want)
+	}
+
+	// A new sequencer sharing the map, the log and the mutations continues
+	// where s left off.
+	restored := newSequencer(mapID, tmap, logID, s.tlog, fakeMutator{}, fakeMutations, fakeFactory{}, nil, HistogramBuckets{}, Options{})
+	restored.Restore(state)
+	if got := restored.Snapshot(); got != state {
+		t.Errorf("Snapshot() after Restore(): %+v, want %+v", got, state)
+	}
+	fakeMutations.write(signedKV(5, 6)...)
+	if err := restored.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if got, want := len(tmap.roots), 4; got != want {
+		t.Fatalf("len(roots): %v, want %v", got, want)
+	}
+	if got, want := tmap.roots[3].GetMetadata().GetHighestFullyCompletedSeq(), int64(6); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+	if rev, _ := restored.LastEpoch(); rev != 3 {
+		t.Errorf("LastEpoch(): revision %v, want 3", rev)
+	}
+
+	// The restored sequence number still guards against map regressions.
+	restored.Restore(state)
+	tmap.roots = append(tmap.roots, &trillian.SignedMapRoot{
+		MapRevision: 4,
+		Metadata:    &trillian.MapperMetadata{HighestFullyCompletedSeq: 2},
+	})
+	if got, want := restored.CreateEpoch(ctx, true), ErrSequenceRegression; got != want {
+		t.Errorf("CreateEpoch() after regression: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// StatusHandler returns an http.Handler that reports the last epoch created by
+// s. It responds with 503 Service Unavailable while s is saturated, e.g. so
+// that load balancers can throttle writers.
+func StatusHandler(s Sequencer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revision, at := s.LastEpoch()
+		if s.Saturated() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintf(w, "last epoch: revision %v at %v\n", revision, at.Format(time.RFC3339))
+	})
+}
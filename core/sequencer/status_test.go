@@ -0,0 +1,51 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/keytransparency/core/fake"
+	"github.com/google/keytransparency/core/sequencer"
+
+	"golang.org/x/net/context"
+)
+
+func TestStatusHandler(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		saturated bool
+		wantCode  int
+	}{
+		{false, http.StatusOK},
+		{true, http.StatusServiceUnavailable},
+	} {
+		s := &fake.Sequencer{IsSaturated: tc.saturated}
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		w := httptest.NewRecorder()
+		sequencer.StatusHandler(s).ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+		if got := w.Code; got != tc.wantCode {
+			t.Errorf("Saturated %v: status %v, want %v", tc.saturated, got, tc.wantCode)
+		}
+		if got, want := w.Body.String(), "revision 1 "; !strings.Contains(got, want) {
+			t.Errorf("Saturated %v: body %q, want it to contain %q", tc.saturated, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+// TraceIDKey is the gRPC metadata key carrying the ID of a sequencing run to
+// the Trillian map and log. CreateEpoch reuses the ID found in the outgoing or
+// incoming metadata of its context, if any, and generates one otherwise.
+const TraceIDKey = "kt-trace-id"
+
+// withTraceID returns the trace ID of ctx, and a context carrying it in its
+// outgoing metadata. A random ID is generated if ctx does not have one.
+func withTraceID(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if ids := md[TraceIDKey]; len(ids) > 0 && ids[0] != "" {
+		return ctx, ids[0]
+	}
+	var id string
+	if in, _ := metadata.FromIncomingContext(ctx); len(in[TraceIDKey]) > 0 && in[TraceIDKey][0] != "" {
+		id = in[TraceIDKey][0]
+	} else {
+		b := make([]byte, 8)
+		if _, err := crand.Read(b); err != nil {
+			return ctx, ""
+		}
+		id = hex.EncodeToString(b)
+	}
+	return metadata.NewOutgoingContext(ctx, metadata.Join(md, metadata.Pairs(TraceIDKey, id))), id
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"testing"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceMap records the trace IDs in the outgoing metadata of calls to the
+// embedded fakeMap.
+type traceMap struct {
+	*fakeMap
+	ids []string
+}
+
+func (m *traceMap) record(ctx context.Context) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	m.ids = append(m.ids, md[TraceIDKey]...)
+}
+
+func (m *traceMap) GetSignedMapRoot(ctx context.Context, in *trillian.GetSignedMapRootRequest, opts ...grpc.CallOption) (*trillian.GetSignedMapRootResponse, error) {
+	m.record(ctx)
+	return m.fakeMap.GetSignedMapRoot(ctx, in, opts...)
+}
+
+func (m *traceMap) GetLeaves(ctx context.Context, in *trillian.GetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.GetMapLeavesResponse, error) {
+	m.record(ctx)
+	return m.fakeMap.GetLeaves(ctx, in, opts...)
+}
+
+func (m *traceMap) SetLeaves(ctx context.Context, in *trillian.SetMapLeavesRequest, opts ...grpc.CallOption) (*trillian.SetMapLeavesResponse, error) {
+	m.record(ctx)
+	return m.fakeMap.SetLeaves(ctx, in, opts...)
+}
+
+func TestTraceID(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		ctx  context.Context
+		want string
+	}{
+		{desc: "generated", ctx: context.Background()},
+		{desc: "outgoing", ctx: metadata.NewOutgoingContext(context.Background(), metadata.Pairs(TraceIDKey, "out")), want: "out"},
+		{desc: "incoming", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(TraceIDKey, "in")), want: "in"},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		tmap := &traceMap{fakeMap: newFakeMap()}
+		s.tmap = tmap
+		if err := s.CreateEpoch(tc.ctx, false); err != nil {
+			t.Fatalf("%v: CreateEpoch(): %v", tc.desc, err)
+		}
+		// GetSignedMapRoot, GetLeaves and SetLeaves.
+		if got, want := len(tmap.ids), 3; got != want {
+			t.Fatalf("%v: map calls with a trace ID: %v, want %v", tc.desc, got, want)
+		}
+		want := tc.want
+		if want == "" {
+			want = tmap.ids[0]
+		}
+		for i, id := range tmap.ids {
+			if id == "" || id != want {
+				t.Errorf("%v: trace ID of map call %v: %q, want %q", tc.desc, i, id, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"errors"
+
+	"github.com/google/keytransparency/core/mutator"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+const (
+	// defaultIndexSize is the default size in bytes of a map index.
+	defaultIndexSize = 32
+)
+
+var (
+	// ErrMissingKeyValue occurs when a mutation does not contain a key value.
+	ErrMissingKeyValue = errors.New("sequencer: missing key value")
+	// ErrInvalidIndex occurs when the mutation key is not a valid map index.
+	ErrInvalidIndex = errors.New("sequencer: invalid index")
+	// ErrMissingValue occurs when a mutation does not carry a value.
+	ErrMissingValue = errors.New("sequencer: missing value")
+	// ErrMissingSignature occurs when a mutation is not signed or when one of
+	// its signatures is empty.
+	ErrMissingSignature = errors.New("sequencer: missing signature")
+)
+
+// validateMutation performs cheap structural checks on a mutation for a map
+// with indexes of indexSize bytes. The key length is not bounded if indexSize
+// is zero. It does not verify signatures, which is left to the mutator.
+func validateMutation(m *tpb.SignedKV, indexSize int) error {
+	kv := m.GetKeyValue()
+	if kv == nil {
+		return ErrMissingKeyValue
+	}
+	if len(kv.GetKey()) == 0 || (indexSize > 0 && len(kv.GetKey()) > indexSize) {
+		return ErrInvalidIndex
+	}
+	if len(kv.GetValue()) == 0 {
+		return ErrMissingValue
+	}
+	if len(m.GetSignatures()) == 0 {
+		return ErrMissingSignature
+	}
+	for _, sig := range m.GetSignatures() {
+		if len(sig.GetSignature()) == 0 {
+			return ErrMissingSignature
+		}
+	}
+	if proto.Size(m) > mutator.MaxMutationSize {
+		return mutator.ErrSize
+	}
+	return nil
+}
+
+// filterValidMutations returns the mutations that pass validateMutation.
+func (s *sequencer) filterValidMutations(mutations []*tpb.SignedKV) []*tpb.SignedKV {
+	size := s.indexSize()
+	if s.IndexFunc != nil {
+		// Keys are not indexes. filterIndexSize checks the indexes.
+		size = 0
+	}
+	valid := make([]*tpb.SignedKV, 0, len(mutations))
+	for _, m := range mutations {
+		if err := validateMutation(m, size); err != nil {
+			glog.Warningf("validateMutation(): %v", err)
+			invalidCtr.Inc()
+			s.reject(m.GetKeyValue().GetKey(), err.Error())
+			continue
+		}
+		valid = append(valid, m)
+	}
+	return valid
+}
+
+// filterIndexSize returns the mutations whose leaf index can be computed and
+// is not longer than indexSize. The map cannot hold the other ones.
+func (s *sequencer) filterIndexSize(mutations []*tpb.SignedKV) []*tpb.SignedKV {
+	size := s.indexSize()
+	kept := make([]*tpb.SignedKV, 0, len(mutations))
+	for _, m := range mutations {
+		index, err := s.leafIndex(m.GetKeyValue().GetKey())
+		if err != nil {
+			glog.Warningf("filterIndexSize: IndexFunc(%x): %v", m.GetKeyValue().GetKey(), err)
+			invalidCtr.Inc()
+			s.reject(m.GetKeyValue().GetKey(), err.Error())
+			continue
+		}
+		if len(index) > size {
+			glog.Warningf("filterIndexSize: dropping mutation for index %x longer than %v bytes", index, size)
+			invalidCtr.Inc()
+			s.reject(index, ErrInvalidIndex.Error())
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// filterMutations returns the mutations that pass validation, if enabled, and
+// MutationFilter, if set. Mutations with indexes longer than IndexSize, or
+// for which IndexFunc fails, are always dropped, as well as quarantined
+// mutations.
+func (s *sequencer) filterMutations(mutations []*tpb.SignedKV) []*tpb.SignedKV {
+	mutations = s.filterPoison(mutations)
+	if s.ValidateMutations {
+		mutations = s.filterValidMutations(mutations)
+	}
+	mutations = s.filterIndexSize(mutations)
+	if s.MutationFilter == nil {
+		return mutations
+	}
+	kept := make([]*tpb.SignedKV, 0, len(mutations))
+	for _, m := range mutations {
+		if !s.MutationFilter(m) {
+			glog.V(2).Infof("MutationFilter: dropping mutation for index %x", m.GetKeyValue().GetKey())
+			filteredCtr.Inc()
+			s.reject(m.GetKeyValue().GetKey(), "filtered")
+			continue
+		}
+		kept = append(kept, m)
+	}
+	return kept
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/keytransparency/core/mutator"
+	"github.com/google/keytransparency/core/transaction"
+
+	"github.com/google/trillian/crypto/sigpb"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+func signedMutation(key, value []byte, sig []byte) *tpb.SignedKV {
+	return &tpb.SignedKV{
+		KeyValue: &tpb.KeyValue{
+			Key:   key,
+			Value: value,
+		},
+		Signatures: map[string]*sigpb.DigitallySigned{
+			"key1": {Signature: sig},
+		},
+	}
+}
+
+func TestValidateMutation(t *testing.T) {
+	index := bytes.Repeat([]byte{1}, defaultIndexSize)
+	for _, tc := range []struct {
+		m    *tpb.SignedKV
+		want error
+	}{
+		{signedMutation(index, []byte("value"), []byte("sig")), nil},
+		{signedMutation(index[:1], []byte("value"), []byte("sig")), nil},
+		{&tpb.SignedKV{}, ErrMissingKeyValue},
+		{signedMutation(nil, []byte("value"), []byte("sig")), ErrInvalidIndex},
+		{signedMutation(append(index, 1), []byte("value"), []byte("sig")), ErrInvalidIndex},
+		{signedMutation(index, nil, []byte("sig")), ErrMissingValue},
+		{signedMutation(index, []byte("value"), nil), ErrMissingSignature},
+		{&tpb.SignedKV{KeyValue: &tpb.KeyValue{Key: index, Value: []byte("value")}}, ErrMissingSignature},
+		{signedMutation(index, make([]byte, mutator.MaxMutationSize), []byte("sig")), mutator.ErrSize},
+	} {
+		if got := validateMutation(tc.m, defaultIndexSize); got != tc.want {
+			t.Errorf("validateMutation(%v): %v, want %v", tc.m, got, tc.want)
+		}
+	}
+}
+
+func TestNewMutationsValidation(t *testing.T) {
+	ctx := context.Background()
+	valid := []*tpb.SignedKV{
+		signedMutation([]byte("key_1"), []byte("value_1"), []byte("sig")),
+		signedMutation([]byte("key_2"), []byte("value_2"), []byte("sig")),
+	}
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(
+		valid[0],
+		&tpb.SignedKV{},
+		signedMutation([]byte("key_3"), nil, []byte("sig")),
+		valid[1],
+		signedMutation([]byte("key_4"), []byte("value_4"), nil),
+	)
+	for _, tc := range []struct {
+		validate bool
+		want     int
+	}{
+		{false, 5},
+		{true, 2},
+	} {
+		s := newTestSequencer(fakeMutations)
+		s.ValidateMutations = tc.validate
+		mutations, _, seq, err := s.newMutations(ctx, 0)
+		if err != nil {
+			t.Fatalf("newMutations(): %v", err)
+		}
+		if got, want := len(mutations), tc.want; got != want {
+			t.Errorf("newMutations(validate: %v): len %v, want %v", tc.validate, got, want)
+		}
+		// The sequence number must advance past invalid mutations.
+		if got, want := seq, int64(5); got != want {
+			t.Errorf("newMutations(validate: %v): seq %v, want %v", tc.validate, got, want)
+		}
+		if tc.validate {
+			for i, m := range mutations {
+				if m != valid[i] {
+					t.Errorf("newMutations(): mutations[%v]: %v, want %v", i, m, valid[i])
+				}
+			}
+		}
+	}
+}
+
+// gappyMutation drops the mutation at position missing of every page read,
+// as if its sequence number had been skipped by the store.
+type gappyMutation struct {
+	*fakeMutation
+	missing int
+}
+
+func (m gappyMutation) ReadRange(txn transaction.Txn, startSequence, endSequence uint64, count int32) (uint64, []*tpb.SignedKV, error) {
+	maxSequence, page, err := m.fakeMutation.ReadRange(txn, startSequence, endSequence, count)
+	if err != nil || m.missing >= len(page) {
+		return maxSequence, page, err
+	}
+	gappy := append(append([]*tpb.SignedKV(nil), page[:m.missing]...), page[m.missing+1:]...)
+	return maxSequence, gappy, nil
+}
+
+func TestSequenceGap(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		gap     bool
+		wantGap float64
+	}{
+		{false, 0},
+		{true, 1},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(signedKV(1, 3)...)
+		s := newTestSequencer(fakeMutations)
+		if tc.gap {
+			s.mutations = gappyMutation{fakeMutation: fakeMutations, missing: 1}
+		}
+		before := counterValue(t, sequenceGapCtr)
+		if _, _, _, err := s.newMutations(ctx, 0); err != nil {
+			t.Fatalf("newMutations(): %v", err)
+		}
+		if got := counterValue(t, sequenceGapCtr) - before; got != tc.wantGap {
+			t.Errorf("gap %v: sequenceGapCtr: %v, want %v", tc.gap, got, tc.wantGap)
+		}
+	}
+}
+
+func TestMutationFilter(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	s.MutationFilter = func(m *tpb.SignedKV) bool {
+		return !bytes.Equal(m.GetKeyValue().GetKey(), []byte("key_2"))
+	}
+	tmap := s.tmap.(*fakeMap)
+
+	before := counterValue(t, filteredCtr)
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	if _, ok := tmap.leaves["key_2"]; ok {
+		t.Errorf("Leaf key_2 was written")
+	}
+	if got, want := len(tmap.leaves), 2; got != want {
+		t.Errorf("len(leaves): %v, want %v", got, want)
+	}
+	if got, want := tmap.roots[1].GetMetadata().GetHighestFullyCompletedSeq(), int64(3); got != want {
+		t.Errorf("HighestFullyCompletedSeq: %v, want %v", got, want)
+	}
+	if got, want := counterValue(t, filteredCtr)-before, 1.0; got != want {
+		t.Errorf("filteredCtr: %v, want %v", got, want)
+	}
+}
+
+func TestIndexSize(t *testing.T) {
+	ctx := context.Background()
+	index := bytes.Repeat([]byte{1}, defaultIndexSize)
+	long := append(append([]byte(nil), index...), 2)
+	for _, tc := range []struct {
+		indexSize    int
+		wantLeaves   int
+		wantRejected bool
+	}{
+		{0, 1, true},
+		{defaultIndexSize + 1, 2, false},
+	} {
+		fakeMutations := &fakeMutation{}
+		fakeMutations.write(
+			signedMutation(index, []byte("value"), []byte("sig")),
+			signedMutation(long, []byte("long"), []byte("sig")),
+		)
+		s := newTestSequencer(fakeMutations)
+		s.IndexSize = tc.indexSize
+		tmap := s.tmap.(*fakeMap)
+
+		if err := s.CreateEpoch(ctx, false); err != nil {
+			t.Fatalf("CreateEpoch(): %v", err)
+		}
+		if got, want := len(tmap.leaves), tc.wantLeaves; got != want {
+			t.Errorf("IndexSize %v: len(leaves): %v, want %v", tc.indexSize, got, want)
+		}
+		// The long index must not be truncated onto the valid one.
+		want, err := s.mutator.Mutate(ctx, nil, signedMutation(index, []byte("value"), []byte("sig")))
+		if err != nil {
+			t.Fatalf("Mutate(): %v", err)
+		}
+		if got := tmap.leaves[string(index)].GetLeafValue(); !bytes.Equal(got, want) {
+			t.Errorf("IndexSize %v: leaf %x: %x, want %x", tc.indexSize, index, got, want)
+		}
+		rejected := false
+		for _, r := range s.RecentRejections() {
+			rejected = rejected || bytes.Equal(r.Index, long)
+		}
+		if rejected != tc.wantRejected {
+			t.Errorf("IndexSize %v: long index rejected: %v, want %v", tc.indexSize, rejected, tc.wantRejected)
+		}
+	}
+}
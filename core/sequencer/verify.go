@@ -0,0 +1,158 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/google/trillian/client"
+	tcrypto "github.com/google/trillian/crypto"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+var (
+	// ErrMissingMapRoot occurs when a response does not contain a map root.
+	ErrMissingMapRoot = errors.New("sequencer: missing map root")
+	// ErrEpochMismatch occurs when the epoch of a response does not match
+	// the revision of its map root.
+	ErrEpochMismatch = errors.New("sequencer: epoch does not match map revision")
+	// ErrInvalidMapSignature occurs when the signature of the map server
+	// over the map root of a response is missing or does not verify.
+	ErrInvalidMapSignature = errors.New("sequencer: invalid map server signature")
+	// ErrInvalidMapRootSignature occurs when the map root signature of the
+	// EpochSigner of a response is missing or does not verify.
+	ErrInvalidMapRootSignature = errors.New("sequencer: invalid map root signature")
+	// ErrIndexMismatch occurs when the inclusion proof of a mutation is for
+	// another index.
+	ErrIndexMismatch = errors.New("sequencer: inclusion proof index does not match mutation")
+)
+
+// MapVerifier verifies that leafValue is at index in the map with root hash
+// rootHash, given its inclusion proof.
+type MapVerifier func(index, leafValue, rootHash []byte, proof [][]byte) error
+
+// VerifyOptions are the settings of the sequencer that created the responses
+// checked by VerifyResponse.
+type VerifyOptions struct {
+	// EpochOffset, IndexFunc, LeafCodec and CompressLeaves are those of
+	// the sequencer.
+	EpochOffset    int64
+	IndexFunc      func(key []byte) ([]byte, error)
+	LeafCodec      LeafCodec
+	CompressLeaves bool
+	// SignerOpts are the EpochSignerOpts of the sequencer.
+	SignerOpts crypto.SignerOpts
+}
+
+// VerifyResponse checks the internal consistency of resp, created by a
+// sequencer with settings opts. Epoch must match the revision of the map root
+// plus EpochOffset. The map root must be signed by the map server, whose public
+// key is mapPub. If pub is set, SmrSignature must also verify with it; it is
+// the public key of the EpochSigner of the sequencer. If logVerifier is
+// set and resp holds a log root, the map root must be included in it at the
+// index of its revision. If mapVerifier is set, the new inclusion proof of
+// every mutation must verify against the map root. The inclusion proof of the
+// leaf before each mutation is relative to the previous map revision and is
+// not checked.
+func VerifyResponse(resp *tpb.GetMutationsResponse, mapPub, pub crypto.PublicKey, logVerifier client.LogVerifier, mapVerifier MapVerifier, opts VerifyOptions) error {
+	smr := resp.GetSmr()
+	if smr == nil {
+		return ErrMissingMapRoot
+	}
+	if resp.GetEpoch()-opts.EpochOffset != smr.GetMapRevision() {
+		return ErrEpochMismatch
+	}
+	// The map server signed the map root without its signature.
+	unsigned := *smr
+	unsigned.Signature = nil
+	if err := tcrypto.VerifyObject(mapPub, unsigned, smr.GetSignature()); err != nil {
+		return ErrInvalidMapSignature
+	}
+	if pub != nil {
+		msg, signerOpts, err := mapRootDigest(smr, opts.LeafCodec, opts.SignerOpts)
+		if err != nil {
+			return err
+		}
+		if err := verifySignature(pub, msg, resp.GetSmrSignature(), signerOpts); err != nil {
+			return err
+		}
+	}
+	if logVerifier != nil && resp.GetLogRoot() != nil {
+		leaf, err := mapRootLeaf(smr, opts.LeafCodec, opts.CompressLeaves)
+		if err != nil {
+			return err
+		}
+		if err := logVerifier.VerifyInclusionAtIndex(resp.GetLogRoot(), leaf.LeafValue,
+			smr.GetMapRevision(), resp.GetLogInclusion()); err != nil {
+			return fmt.Errorf("VerifyInclusionAtIndex(%v): %v", resp.GetEpoch(), err)
+		}
+	}
+	if mapVerifier != nil {
+		for i, m := range resp.GetMutations() {
+			p := m.GetNewProof()
+			if p == nil {
+				continue
+			}
+			index := m.GetUpdate().GetKeyValue().GetKey()
+			if opts.IndexFunc != nil {
+				var err error
+				if index, err = opts.IndexFunc(index); err != nil {
+					return fmt.Errorf("mutation %v: IndexFunc(): %v", i, err)
+				}
+			}
+			if !bytes.Equal(p.GetLeaf().GetIndex(), index) {
+				return ErrIndexMismatch
+			}
+			if err := mapVerifier(index, p.GetLeaf().GetLeafValue(), smr.GetRootHash(), p.GetInclusion()); err != nil {
+				return fmt.Errorf("mutation %v: map inclusion proof for index %x: %v", i, index, err)
+			}
+		}
+	}
+	return nil
+}
+
+// verifySignature verifies that sig is a signature of msg by pub, made with
+// opts. Only ECDSA and RSA keys are supported.
+func verifySignature(pub crypto.PublicKey, msg, sig []byte, opts crypto.SignerOpts) error {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var ecSig struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(sig, &ecSig)
+		if err != nil || len(rest) > 0 || !ecdsa.Verify(pub, msg, ecSig.R, ecSig.S) {
+			return ErrInvalidMapRootSignature
+		}
+	case *rsa.PublicKey:
+		var err error
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			err = rsa.VerifyPSS(pub, pss.HashFunc(), msg, sig, pss)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, opts.HashFunc(), msg, sig)
+		}
+		if err != nil {
+			return ErrInvalidMapRootSignature
+		}
+	default:
+		return fmt.Errorf("sequencer: unsupported public key type %T", pub)
+	}
+	return nil
+}
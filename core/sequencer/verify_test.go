@@ -0,0 +1,122 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/trillian"
+	"github.com/google/trillian/client"
+	tcrypto "github.com/google/trillian/crypto"
+	"golang.org/x/net/context"
+
+	tpb "github.com/google/keytransparency/core/proto/keytransparency_v1_types"
+)
+
+// singleLeafLogVerifier accepts inclusion proofs of data in logs whose root
+// hash is the SHA256 hash of their last leaf.
+type singleLeafLogVerifier struct {
+	client.LogVerifier
+}
+
+func (singleLeafLogVerifier) VerifyInclusionAtIndex(trusted *trillian.SignedLogRoot, data []byte, leafIndex int64, proof [][]byte) error {
+	h := sha256.Sum256(data)
+	if leafIndex != trusted.GetTreeSize()-1 || !bytes.Equal(h[:], trusted.GetRootHash()) {
+		return fmt.Errorf("leaf %v not included", leafIndex)
+	}
+	return nil
+}
+
+func TestVerifyResponse(t *testing.T) {
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	mapKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	s := newTestSequencer(&fakeMutation{})
+	tmap := s.tmap.(*fakeMap)
+	tmap.signer = tcrypto.NewSHA256Signer(mapKey)
+	s.EpochSigner = key
+	s.FetchNewLeafProofs = true
+	// The fake map verifies leaves against its current state.
+	mapVerifier := func(index, leafValue, rootHash []byte, proof [][]byte) error {
+		if !bytes.Equal(rootHash, tmap.roots[len(tmap.roots)-1].GetRootHash()) {
+			return fmt.Errorf("unknown root %x", rootHash)
+		}
+		if !bytes.Equal(leafValue, tmap.leaves[string(index)].GetLeafValue()) {
+			return fmt.Errorf("leaf %x not included", index)
+		}
+		return nil
+	}
+
+	valid, err := s.createEpochWithMutations(ctx, signedKV(1, 3), 3, false)
+	if err != nil {
+		t.Fatalf("createEpochWithMutations(): %v", err)
+	}
+	leaf, err := mapRootLeaf(valid.GetSmr(), s.LeafCodec, s.CompressLeaves)
+	if err != nil {
+		t.Fatalf("mapRootLeaf(): %v", err)
+	}
+	h := sha256.Sum256(leaf.LeafValue)
+	valid.LogRoot = &trillian.SignedLogRoot{TreeSize: valid.GetEpoch() + 1, RootHash: h[:]}
+
+	for _, tc := range []struct {
+		desc    string
+		tamper  func(resp *tpb.GetMutationsResponse)
+		want    error
+		wantErr bool
+	}{
+		{desc: "valid", tamper: func(*tpb.GetMutationsResponse) {}},
+		{desc: "no map root", tamper: func(r *tpb.GetMutationsResponse) { r.Smr = nil }, want: ErrMissingMapRoot},
+		{desc: "epoch", tamper: func(r *tpb.GetMutationsResponse) { r.Epoch++ }, want: ErrEpochMismatch},
+		{desc: "no map signature", tamper: func(r *tpb.GetMutationsResponse) { r.Smr.Signature = nil }, want: ErrInvalidMapSignature},
+		{desc: "map signature", tamper: func(r *tpb.GetMutationsResponse) {
+			sig := r.Smr.Signature.Signature
+			sig[len(sig)-1] ^= 1
+		}, want: ErrInvalidMapSignature},
+		{desc: "no signature", tamper: func(r *tpb.GetMutationsResponse) { r.SmrSignature = nil }, want: ErrInvalidMapRootSignature},
+		{desc: "signature", tamper: func(r *tpb.GetMutationsResponse) { r.SmrSignature[len(r.SmrSignature)-1] ^= 1 }, want: ErrInvalidMapRootSignature},
+		{desc: "root hash", tamper: func(r *tpb.GetMutationsResponse) { r.Smr.RootHash[0] ^= 1 }, want: ErrInvalidMapSignature},
+		{desc: "log root", tamper: func(r *tpb.GetMutationsResponse) { r.LogRoot.RootHash[0] ^= 1 }, wantErr: true},
+		{desc: "log index", tamper: func(r *tpb.GetMutationsResponse) { r.LogRoot.TreeSize++ }, wantErr: true},
+		{desc: "leaf value", tamper: func(r *tpb.GetMutationsResponse) {
+			r.Mutations[1].NewProof.Leaf.LeafValue = []byte("tampered")
+		}, wantErr: true},
+		{desc: "leaf index", tamper: func(r *tpb.GetMutationsResponse) {
+			r.Mutations[1].NewProof.Leaf.Index = []byte("key_4")
+		}, want: ErrIndexMismatch},
+	} {
+		resp := proto.Clone(valid).(*tpb.GetMutationsResponse)
+		tc.tamper(resp)
+		err := VerifyResponse(resp, mapKey.Public(), key.Public(), singleLeafLogVerifier{}, mapVerifier, VerifyOptions{})
+		if tc.want != nil && err != tc.want {
+			t.Errorf("VerifyResponse(%v): %v, want %v", tc.desc, err, tc.want)
+		}
+		if got, want := err != nil, tc.want != nil || tc.wantErr; got != want {
+			t.Errorf("VerifyResponse(%v): %v, want err %v", tc.desc, err, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"fmt"
+
+	"github.com/google/keytransparency/core/transaction"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+)
+
+// Intent describes an epoch that the sequencer is about to commit.
+type Intent struct {
+	// StartSequence is the highest fully completed sequence number of the
+	// previous epoch.
+	StartSequence int64
+	// MaxSequence is the highest fully completed sequence number of the new
+	// epoch.
+	MaxSequence int64
+	// Revision is the map revision of the new epoch.
+	Revision int64
+}
+
+// WAL is a write-ahead log that records the epoch being committed, so that an
+// epoch interrupted between writing the map and writing the log can be
+// recovered.
+type WAL interface {
+	// Write records intent, replacing any previous intent.
+	Write(txn transaction.Txn, intent *Intent) error
+	// Read returns the current intent, or nil if there is none.
+	Read(txn transaction.Txn) (*Intent, error)
+	// Clear removes the current intent.
+	Clear(txn transaction.Txn) error
+}
+
+// writeIntent records intent in the WAL, if one is configured.
+func (s *sequencer) writeIntent(ctx context.Context, intent *Intent) error {
+	if s.WAL == nil {
+		return nil
+	}
+	return s.walTxn(ctx, func(txn transaction.Txn) error {
+		return s.WAL.Write(txn, intent)
+	})
+}
+
+// clearIntent removes the current intent from the WAL, if one is configured.
+func (s *sequencer) clearIntent(ctx context.Context) error {
+	if s.WAL == nil {
+		return nil
+	}
+	return s.walTxn(ctx, s.WAL.Clear)
+}
+
+// walTxn runs f in a new transaction.
+func (s *sequencer) walTxn(ctx context.Context, f func(txn transaction.Txn) error) error {
+	txn, err := s.factory.NewTxn(ctx)
+	if err != nil {
+		return fmt.Errorf("NewDBTxn(): %v", err)
+	}
+	if err := f(txn); err != nil {
+		if err := txn.Rollback(); err != nil {
+			glog.Errorf("Cannot rollback the transaction: %v", err)
+		}
+		return err
+	}
+	return txn.Commit()
+}
+
+// recoverIntent completes or abandons an epoch that was interrupted before
+// its map root was added to the log. If the map reached the intended revision,
+// its root is queued to the log again; the log ignores the leaf if it was
+// already added. Otherwise the map was not written and the intent is
+// abandoned, leaving the mutations to be picked up by the next epoch.
+func (s *sequencer) recoverIntent(ctx context.Context) error {
+	if s.WAL == nil {
+		return nil
+	}
+	var intent *Intent
+	if err := s.walTxn(ctx, func(txn transaction.Txn) error {
+		var err error
+		intent, err = s.WAL.Read(txn)
+		return err
+	}); err != nil {
+		return fmt.Errorf("WAL.Read(): %v", err)
+	}
+	if intent == nil {
+		return nil
+	}
+
+	rootResp, err := s.tmap.GetSignedMapRoot(ctx, &trillian.GetSignedMapRootRequest{
+		MapId: s.mapID,
+	}, s.callOpts...)
+	if err != nil {
+		return fmt.Errorf("GetSignedMapRoot(%v): %v", s.mapID, err)
+	}
+	if rootResp.GetMapRoot().GetMapRevision() < intent.Revision {
+		glog.Warningf("Initialize: abandoning uncommitted epoch %+v", intent)
+	} else {
+		glog.Warningf("Initialize: replaying log write of epoch %+v", intent)
+		smr, err := s.mapRoot(ctx, intent.Revision)
+		if err != nil {
+			return err
+		}
+		if err := s.queueLogLeaf(ctx, smr); err != nil {
+			return err
+		}
+	}
+	return s.clearIntent(ctx)
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequencer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/keytransparency/core/transaction"
+
+	"github.com/google/trillian"
+	"golang.org/x/net/context"
+)
+
+// WAL fake.
+type fakeWAL struct {
+	intent *Intent
+	writes []Intent
+}
+
+func (w *fakeWAL) Write(txn transaction.Txn, intent *Intent) error {
+	w.intent = intent
+	w.writes = append(w.writes, *intent)
+	return nil
+}
+
+func (w *fakeWAL) Read(txn transaction.Txn) (*Intent, error) {
+	return w.intent, nil
+}
+
+func (w *fakeWAL) Clear(txn transaction.Txn) error {
+	w.intent = nil
+	return nil
+}
+
+func TestWALCreateEpoch(t *testing.T) {
+	ctx := context.Background()
+	fakeMutations := &fakeMutation{}
+	fakeMutations.write(signedKV(1, 3)...)
+	s := newTestSequencer(fakeMutations)
+	wal := &fakeWAL{}
+	s.WAL = wal
+
+	if err := s.CreateEpoch(ctx, false); err != nil {
+		t.Fatalf("CreateEpoch(): %v", err)
+	}
+	want := Intent{StartSequence: 0, MaxSequence: 3, Revision: 1}
+	if got := wal.writes; len(got) != 1 || got[0] != want {
+		t.Errorf("WAL writes: %+v, want [%+v]", got, want)
+	}
+	if wal.intent != nil {
+		t.Errorf("WAL intent: %+v, want nil", wal.intent)
+	}
+}
+
+func TestWALRecovery(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		desc       string
+		setLeaves  bool // Whether the crash happened after SetLeaves.
+		wantLeaves int
+	}{
+		{"crash before SetLeaves", false, 1},
+		{"crash after SetLeaves", true, 2},
+	} {
+		s := newTestSequencer(&fakeMutation{})
+		if err := s.Initialize(ctx); err != nil {
+			t.Fatalf("Initialize(): %v", err)
+		}
+		tmap := s.tmap.(*fakeMap)
+		tlog := s.tlog.(*fakeLog)
+		if tc.setLeaves {
+			if _, err := tmap.SetLeaves(ctx, &trillian.SetMapLeavesRequest{
+				MapId:      mapID,
+				MapperData: &trillian.MapperMetadata{HighestFullyCompletedSeq: 3},
+			}); err != nil {
+				t.Fatalf("SetLeaves(): %v", err)
+			}
+		}
+		wal := &fakeWAL{intent: &Intent{StartSequence: 0, MaxSequence: 3, Revision: 1}}
+		s.WAL = wal
+
+		// Restart.
+		if err := s.Initialize(ctx); err != nil {
+			t.Fatalf("%v: Initialize(): %v", tc.desc, err)
+		}
+		if wal.intent != nil {
+			t.Errorf("%v: WAL intent: %+v, want nil", tc.desc, wal.intent)
+		}
+		if got, want := len(tlog.leaves), tc.wantLeaves; got != want {
+			t.Fatalf("%v: len(log leaves): %v, want %v", tc.desc, got, want)
+		}
+		if tc.setLeaves {
+			want, err := mapRootLeaf(tmap.roots[1], JSONLeafCodec, false)
+			if err != nil {
+				t.Fatalf("mapRootLeaf(): %v", err)
+			}
+			if got := tlog.leaves[1]; !bytes.Equal(got.LeafIdentityHash, want.LeafIdentityHash) {
+				t.Errorf("%v: log leaf 1: %x, want %x", tc.desc, got.LeafIdentityHash, want.LeafIdentityHash)
+			}
+		}
+	}
+}
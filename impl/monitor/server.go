@@ -64,21 +64,22 @@ func (s *Server) GetSignedMapRoot(ctx context.Context, in *mopb.GetMonitoringReq
 	if latestEpoch == 0 {
 		return nil, ErrNothingProcessed
 	}
-	return s.getResponseByRevision(latestEpoch)
+	return s.getResponseByEpoch(latestEpoch)
 }
 
 // GetSignedMapRootByRevision works similar to GetSignedMapRoot but returns
-// the monitor's result for a specific map revision.
+// the monitor's result for a specific epoch, which is the map revision plus
+// the epoch offset of the sequencer.
 //
 // Returns the signed map root for the specified epoch the monitor observed.
 // If the monitor could not reconstruct the map root given the set of
 // mutations from the previous to the current epoch it won't sign the map root
 // and additional data will be provided to reproduce the failure.
 func (s *Server) GetSignedMapRootByRevision(ctx context.Context, in *mopb.GetMonitoringRequest) (*mopb.GetMonitoringResponse, error) {
-	return s.getResponseByRevision(in.GetEpoch())
+	return s.getResponseByEpoch(in.GetEpoch())
 }
 
-func (s *Server) getResponseByRevision(epoch int64) (*mopb.GetMonitoringResponse, error) {
+func (s *Server) getResponseByEpoch(epoch int64) (*mopb.GetMonitoringResponse, error) {
 	res, err := s.storage.Get(epoch)
 	if err == storage.ErrNotFound {
 		return nil, grpc.Errorf(codes.NotFound,
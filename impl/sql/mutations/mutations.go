@@ -41,6 +41,9 @@ const (
  	SELECT Sequence, Mutation FROM Mutations
  	WHERE MapID = ? AND Sequence > ?
 	ORDER BY Sequence ASC;`
+	highestSequenceExpr = `
+	SELECT COALESCE(MAX(Sequence), 0) FROM Mutations
+	WHERE MapID = ?;`
 )
 
 type mutations struct {
@@ -49,7 +52,7 @@ type mutations struct {
 }
 
 // New creates a new mutations instance.
-func New(db *sql.DB, mapID int64) (mutator.Mutation, error) {
+func New(db *sql.DB, mapID int64) (mutator.SequencedMutation, error) {
 	m := &mutations{
 		mapID: mapID,
 		db:    db,
@@ -84,6 +87,21 @@ func (m *mutations) ReadRange(txn transaction.Txn, startSequence, endSequence ui
 	return readRows(rows)
 }
 
+// ReadQueued reads mutations like ReadRange, along with their sequence numbers.
+func (m *mutations) ReadQueued(txn transaction.Txn, startSequence, endSequence uint64, count int32) ([]*mutator.QueuedMutation, error) {
+	readStmt, err := txn.Prepare(readRangeExpr)
+	if err != nil {
+		return nil, err
+	}
+	defer readStmt.Close()
+	rows, err := readStmt.Query(m.mapID, startSequence, endSequence, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return readQueuedRows(rows)
+}
+
 // ReadAll reads all mutations starting from the given sequence number. Note that
 // startSequence is not included in the result. ReadAll also returns the maximum
 // sequence number read.
@@ -101,28 +119,58 @@ func (m *mutations) ReadAll(txn transaction.Txn, startSequence uint64) (uint64,
 	return readRows(rows)
 }
 
+// HighestSequence returns the highest sequence number written so far, or zero
+// if no mutations have been written.
+func (m *mutations) HighestSequence(txn transaction.Txn) (uint64, error) {
+	readStmt, err := txn.Prepare(highestSequenceExpr)
+	if err != nil {
+		return 0, err
+	}
+	defer readStmt.Close()
+	var sequence uint64
+	if err := readStmt.QueryRow(m.mapID).Scan(&sequence); err != nil {
+		return 0, err
+	}
+	return sequence, nil
+}
+
 func readRows(rows *sql.Rows) (uint64, []*tpb.SignedKV, error) {
-	results := make([]*tpb.SignedKV, 0)
+	queued, err := readQueuedRows(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+	results := make([]*tpb.SignedKV, 0, len(queued))
 	maxSequence := uint64(0)
+	for _, q := range queued {
+		if q.Sequence > maxSequence {
+			maxSequence = q.Sequence
+		}
+		results = append(results, q.Mutation)
+	}
+	return maxSequence, results, nil
+}
+
+func readQueuedRows(rows *sql.Rows) ([]*mutator.QueuedMutation, error) {
+	results := make([]*mutator.QueuedMutation, 0)
 	for rows.Next() {
 		var sequence uint64
 		var mData []byte
 		if err := rows.Scan(&sequence, &mData); err != nil {
-			return 0, nil, err
-		}
-		if sequence > maxSequence {
-			maxSequence = sequence
+			return nil, err
 		}
 		mutation := new(tpb.SignedKV)
 		if err := proto.Unmarshal(mData, mutation); err != nil {
-			return 0, nil, err
+			return nil, err
 		}
-		results = append(results, mutation)
+		results = append(results, &mutator.QueuedMutation{
+			Sequence: sequence,
+			Mutation: mutation,
+		})
 	}
 	if err := rows.Err(); err != nil {
-		return 0, nil, err
+		return nil, err
 	}
-	return maxSequence, results, nil
+	return results, nil
 }
 
 // Write saves the mutation in the database. Write returns the auto-inserted
@@ -446,3 +446,82 @@ func TestReadAll(t *testing.T) {
 		}
 	}
 }
+
+func TestReadQueued(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	factory := testutil.NewFakeFactory(db)
+	m, err := New(db, mapID)
+	if err != nil {
+		t.Fatalf("Failed to create mutations: %v", err)
+	}
+	fillDB(ctx, t, m, factory)
+
+	for _, tc := range []struct {
+		startSequence uint64
+		endSequence   uint64
+		count         int32
+		want          []uint64
+	}{
+		{0, 5, 10, []uint64{1, 2, 3, 4, 5}},
+		{1, 5, 2, []uint64{2, 3}},
+		{3, 4, 10, []uint64{4}},
+		{5, 10, 10, nil},
+	} {
+		txn, err := factory.NewTxn(ctx)
+		if err != nil {
+			t.Fatalf("failed to create read transaction: %v", err)
+		}
+		queued, err := m.ReadQueued(txn, tc.startSequence, tc.endSequence, tc.count)
+		if err != nil {
+			t.Fatalf("ReadQueued(%v, %v, %v): %v", tc.startSequence, tc.endSequence, tc.count, err)
+		}
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("txn.Commit() failed: %v", err)
+		}
+		if got, want := len(queued), len(tc.want); got != want {
+			t.Fatalf("ReadQueued(%v, %v, %v): len %v, want %v", tc.startSequence, tc.endSequence, tc.count, got, want)
+		}
+		for i, q := range queued {
+			if got, want := q.Sequence, tc.want[i]; got != want {
+				t.Errorf("ReadQueued(%v, %v, %v)[%v].Sequence: %v, want %v", tc.startSequence, tc.endSequence, tc.count, i, got, want)
+			}
+			if got, want := string(q.Mutation.GetKeyValue().GetKey()), fmt.Sprintf("index%v", tc.want[i]); got != want {
+				t.Errorf("ReadQueued(%v, %v, %v)[%v] index: %v, want %v", tc.startSequence, tc.endSequence, tc.count, i, got, want)
+			}
+		}
+	}
+}
+
+func TestHighestSequence(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	factory := testutil.NewFakeFactory(db)
+	m, err := New(db, mapID)
+	if err != nil {
+		t.Fatalf("Failed to create mutations: %v", err)
+	}
+	for _, fill := range []bool{false, true} {
+		if fill {
+			fillDB(ctx, t, m, factory)
+		}
+		txn, err := factory.NewTxn(ctx)
+		if err != nil {
+			t.Fatalf("failed to create read transaction: %v", err)
+		}
+		sequence, err := m.HighestSequence(txn)
+		if err != nil {
+			t.Fatalf("HighestSequence(): %v", err)
+		}
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("txn.Commit() failed: %v", err)
+		}
+		want := uint64(0)
+		if fill {
+			want = 5
+		}
+		if got := sequence; got != want {
+			t.Errorf("HighestSequence()=%v, want %v", got, want)
+		}
+	}
+}
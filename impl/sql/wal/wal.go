@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wal implements the sequencer write-ahead log on top of an SQL
+// database.
+package wal
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/keytransparency/core/sequencer"
+	"github.com/google/keytransparency/core/transaction"
+)
+
+const (
+	writeExpr = `
+	REPLACE INTO SequencerWAL (MapID, StartSequence, MaxSequence, Revision)
+	VALUES (?, ?, ?, ?);`
+	readExpr = `
+	SELECT StartSequence, MaxSequence, Revision FROM SequencerWAL
+	WHERE MapID = ?;`
+	clearExpr = `DELETE FROM SequencerWAL WHERE MapID = ?;`
+)
+
+type wal struct {
+	mapID int64
+	db    *sql.DB
+}
+
+// New creates a new write-ahead log for mapID.
+func New(db *sql.DB, mapID int64) (sequencer.WAL, error) {
+	w := &wal{
+		mapID: mapID,
+		db:    db,
+	}
+	if err := w.create(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write records intent, replacing any previous intent.
+func (w *wal) Write(txn transaction.Txn, intent *sequencer.Intent) error {
+	writeStmt, err := txn.Prepare(writeExpr)
+	if err != nil {
+		return err
+	}
+	defer writeStmt.Close()
+	_, err = writeStmt.Exec(w.mapID, intent.StartSequence, intent.MaxSequence, intent.Revision)
+	return err
+}
+
+// Read returns the current intent, or nil if there is none.
+func (w *wal) Read(txn transaction.Txn) (*sequencer.Intent, error) {
+	readStmt, err := txn.Prepare(readExpr)
+	if err != nil {
+		return nil, err
+	}
+	defer readStmt.Close()
+	intent := &sequencer.Intent{}
+	switch err := readStmt.QueryRow(w.mapID).Scan(
+		&intent.StartSequence, &intent.MaxSequence, &intent.Revision); {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return intent, nil
+}
+
+// Clear removes the current intent.
+func (w *wal) Clear(txn transaction.Txn) error {
+	clearStmt, err := txn.Prepare(clearExpr)
+	if err != nil {
+		return err
+	}
+	defer clearStmt.Close()
+	_, err = clearStmt.Exec(w.mapID)
+	return err
+}
+
+// Create creates new database tables.
+func (w *wal) create() error {
+	for _, stmt := range createStmt {
+		if _, err := w.db.Exec(stmt); err != nil {
+			return fmt.Errorf("Failed to create WAL tables: %v", err)
+		}
+	}
+	return nil
+}
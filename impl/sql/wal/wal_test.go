@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wal
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/keytransparency/core/sequencer"
+	"github.com/google/keytransparency/core/transaction"
+	"github.com/google/keytransparency/impl/sql/testutil"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const mapID = 0
+
+func newDB(t testing.TB) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open(): %v", err)
+	}
+	return db
+}
+
+// inTxn runs f in a new transaction and commits it.
+func inTxn(ctx context.Context, t *testing.T, factory *testutil.FakeFactory, f func(txn transaction.Txn) error) {
+	txn, err := factory.NewTxn(ctx)
+	if err != nil {
+		t.Fatalf("NewTxn(): %v", err)
+	}
+	if err := f(txn); err != nil {
+		t.Fatalf("txn: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("txn.Commit(): %v", err)
+	}
+}
+
+func TestWAL(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t)
+	factory := testutil.NewFakeFactory(db)
+	w, err := New(db, mapID)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+
+	read := func() *sequencer.Intent {
+		var intent *sequencer.Intent
+		inTxn(ctx, t, factory, func(txn transaction.Txn) error {
+			var err error
+			intent, err = w.Read(txn)
+			return err
+		})
+		return intent
+	}
+
+	if got := read(); got != nil {
+		t.Errorf("Read(): %+v, want nil", got)
+	}
+	for _, want := range []sequencer.Intent{
+		{StartSequence: 0, MaxSequence: 3, Revision: 1},
+		{StartSequence: 3, MaxSequence: 5, Revision: 2},
+	} {
+		want := want
+		inTxn(ctx, t, factory, func(txn transaction.Txn) error {
+			return w.Write(txn, &want)
+		})
+		if got := read(); got == nil || *got != want {
+			t.Errorf("Read(): %+v, want %+v", got, want)
+		}
+	}
+	inTxn(ctx, t, factory, w.Clear)
+	if got := read(); got != nil {
+		t.Errorf("Read() after Clear(): %+v, want nil", got)
+	}
+}
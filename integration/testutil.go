@@ -37,6 +37,7 @@ import (
 	"github.com/google/trillian/crypto/keys/der"
 	"github.com/google/trillian/merkle/coniks"
 	"github.com/google/trillian/testonly/integration"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -81,7 +82,7 @@ type Env struct {
 	V2Server   *keyserver.Server
 	Conn       *grpc.ClientConn
 	Client     *grpcc.Client
-	Signer     *sequencer.Sequencer
+	Signer     sequencer.Sequencer
 	db         *sql.DB
 	Factory    *transaction.Factory
 	VrfPriv    vrf.PrivateKey
@@ -163,7 +164,7 @@ func NewEnv(t *testing.T) *Env {
 	pb.RegisterKeyTransparencyServiceServer(s, server)
 
 	// Signer
-	signer := sequencer.New(mapID, mapEnv.MapClient, logID, tlog, mutator, mutations, factory)
+	signer := sequencer.New(mapID, mapEnv.MapClient, logID, tlog, mutator, mutations, factory, prometheus.NewRegistry(), sequencer.Options{})
 
 	addr, lis := Listen(t)
 	go s.Serve(lis)